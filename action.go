@@ -6,20 +6,76 @@ import (
 	"fmt"
 	"io"
 	"os"
-
-	"github.com/snhmibby/filebuf"
 )
 
 func actionGoto() {
 	IntDialog(DialogGoto)
 }
 
+//addr is a load address for firmware images
 func actionGotoAddr(addr int64) {
 	tab := ActiveTab()
-	if tab == nil {
+	file := ActiveFile()
+	if tab == nil || file == nil {
 		panic("Goto: tab or file is nil (shouldn't happen)")
 	}
-	tab.setCursor(addr)
+	tab.setCursor(addr - file.fw.base)
+}
+
+func actionFillByte() {
+	IntDialog(DialogFill)
+}
+
+func actionSetFillByte(b int64) {
+	if b < 0 || b > 255 {
+		ErrorDialog("Fill Byte", fmt.Sprintf("%d is not a byte value", b))
+		return
+	}
+	HD.FillByte = byte(b)
+}
+
+//fill the selection, or insert bytes at the cursor
+func actionFill() {
+	if ActiveFile() != nil {
+		FillDialog(DialogFillData)
+	}
+}
+
+//xor, shift, swap... the selection
+func actionTransform() {
+	if tab := ActiveTab(); tab != nil && tab.view.selectionSize > 0 {
+		TransformDialog(DialogTransform)
+	}
+}
+
+//base64, compression... of the selection
+func actionCodec() {
+	if tab := ActiveTab(); tab != nil && tab.view.selectionSize > 0 {
+		CodecDialog(DialogCodec)
+	}
+}
+
+//go to the next (or previous) hit of the last search and select it
+func actionFindNext(forward bool) {
+	tab := ActiveTab()
+	hf := ActiveFile()
+	if tab == nil || hf == nil {
+		return
+	}
+	hits, n := hf.SearchHits()
+	at, ok := nextHit(hits, tab.view.cursor, forward)
+	if !ok {
+		return
+	}
+	tab.setCursor(at)
+	tab.view.SetSelection(at, n)
+}
+
+//find all occurrences of a text or hex pattern
+func actionFind() {
+	if ActiveFile() != nil {
+		FindDialog(DialogFind)
+	}
 }
 
 func actionMove(move int64) {
@@ -35,7 +91,7 @@ func actionRedo() {
 	file := ActiveFile()
 	if file == nil {
 		panic("Redo: file is nil (shouldn't happen)")
-	} else {
+	} else if file.writable("Redo") {
 		file.Redo()
 	}
 }
@@ -44,7 +100,7 @@ func actionUndo() {
 	file := ActiveFile()
 	if file == nil {
 		panic("Undo: file is nil (shouldn't happen)")
-	} else {
+	} else if file.writable("Undo") {
 		file.Undo()
 	}
 }
@@ -55,8 +111,12 @@ func actionInsert(b byte) {
 	if tab == nil || file == nil {
 		panic("Insert: tab or file is nil (shouldn't happen)")
 	}
+	if !file.writable("Insert") {
+		return
+	}
 	off := tab.view.cursor
 	file.buf.Insert1(off, b)
+	file.edited(off, 0, 1)
 	tab.view.SetSelection(0, 0)
 	tab.setCursor(off + 1)
 	file.emptyRedo()
@@ -67,6 +127,7 @@ func actionInsert(b byte) {
 		},
 		redo: func() (int64, int64) {
 			file.buf.Insert1(off, b)
+			file.edited(off, 0, 1)
 			return off, 0
 		},
 	})
@@ -78,6 +139,9 @@ func actionOverWrite(b byte) {
 	if tab == nil || file == nil {
 		panic("Overwrite: tab or file is nil (shouldn't happen)")
 	}
+	if !file.writable("Overwrite") {
+		return
+	}
 	off := tab.view.cursor
 	file.buf.Seek(off, io.SeekStart)
 	var overwritten_ = make([]byte, 1)
@@ -89,6 +153,7 @@ func actionOverWrite(b byte) {
 	overwritten := overwritten_[0]
 	file.buf.Remove(off, 1)
 	file.buf.Insert1(off, b)
+	file.edited(off, 1, 1)
 	tab.setCursor(off + 1)
 	tab.view.SetSelection(0, 0)
 
@@ -97,11 +162,13 @@ func actionOverWrite(b byte) {
 		undo: func() (int64, int64) {
 			file.buf.Remove(off, 1)
 			file.buf.Insert1(off, overwritten)
+			file.edited(off, 1, 1)
 			return off, 0
 		},
 		redo: func() (int64, int64) {
 			file.buf.Remove(off, 1)
 			file.buf.Insert1(off, b)
+			file.edited(off, 1, 1)
 			return off + 1, 0
 		},
 	})
@@ -113,6 +180,9 @@ func actionCut() {
 	if tab == nil || file == nil {
 		panic("Cut: tab or file is nil (shouldn't happen)")
 	}
+	if !file.writable("Cut") {
+		return
+	}
 	off, size := tab.view.Selection()
 	cut, err := file.Cut(off, size) //XXX BUG this possibly creates hidden filetree copies (need to find them on saving!)
 	if err != nil {
@@ -126,11 +196,11 @@ func actionCut() {
 	file.emptyRedo()
 	file.addUndo(Undo{
 		undo: func() (int64, int64) {
-			file.buf.Paste(off, cut)
+			file.Paste(off, cut)
 			return off, cut.Size()
 		},
 		redo: func() (int64, int64) {
-			file.buf.Cut(off, size)
+			file.Cut(off, size)
 			return off, 0
 		},
 	})
@@ -163,6 +233,9 @@ func actionPaste() {
 	if file == nil || tab == nil {
 		panic("Paste: tab or file is nil (shouldn't happen)")
 	}
+	if !file.writable("Paste") {
+		return
+	}
 	off := tab.view.cursor
 	buf := HD.ClipBoard //XXX BUG this creates hidden copies of a file-based tree
 	file.Paste(off, buf)
@@ -171,11 +244,11 @@ func actionPaste() {
 	file.emptyRedo()
 	file.addUndo(Undo{
 		undo: func() (int64, int64) {
-			file.buf.Cut(off, buf.Size())
+			file.Cut(off, buf.Size())
 			return off, 0
 		},
 		redo: func() (int64, int64) {
-			file.buf.Paste(off, buf)
+			file.Paste(off, buf)
 			return off, buf.Size()
 		},
 	})
@@ -202,58 +275,112 @@ func actionOpen(p string) {
 	}
 }
 
+func actionClearRecent() {
+	HD.Recent = nil
+}
+
+//callback of the open dialog
+func actionOpenDialog(p string, readOnly bool) {
+	hf, err := OpenHexFile(p)
+	if err != nil {
+		ErrorDialog(fmt.Sprintf("Opening File <%s>", p), fmt.Sprint(err))
+		return
+	}
+	if readOnly {
+		hf.readOnly = true
+	}
+}
+
+func actionToggleReadOnly() {
+	tab := ActiveTab()
+	file := ActiveFile()
+	if tab == nil || file == nil {
+		return
+	}
+	file.readOnly = !file.readOnly
+	if file.readOnly {
+		for _, t := range HD.Tabs {
+			if t.name == file.name {
+				t.view.editmode = NormalMode
+			}
+		}
+	}
+}
+
+//follow mode: start at the end of the file
+func actionToggleFollow() {
+	tab := ActiveTab()
+	file := ActiveFile()
+	if tab == nil || file == nil || !file.canFollow() {
+		return
+	}
+	file.follow = !file.follow
+	if file.follow {
+		file.followGrowth()
+		tab.setCursor(file.buf.Size())
+	}
+}
+
+func actionScriptConsole() {
+	HD.Script.open = true
+}
+
+func actionRunScript() {
+	FileDialog(DialogScript)
+}
+
+func actionScriptFile(p string) {
+	HD.Script.runFile(p)
+}
+
+func actionLoadPlugin() {
+	FileDialog(DialogPlugin)
+}
+
+func actionOpenPlugin(p string) {
+	if err := loadPlugin(p); err != nil {
+		ErrorDialog(fmt.Sprintf("Loading Plugin <%s>", p), err.Error())
+	}
+}
+
 func actionOpenFile() {
 	FileDialog(DialogOpen)
 }
 
 func actionWriteFile(p string) {
 	hf := ActiveFile()
-	f, err := os.CreateTemp("", "")
-	if err != nil {
-		title := fmt.Sprintf("Opening File <%s> for saving.", p)
-		msg := fmt.Sprint(err)
-		ErrorDialog(title, msg)
-	}
-	/*
-	 *hf.buf.Seek(0, io.SeekStart)
-	 *n, err := io.Copy(f, hf.buf)
-	 *if err != nil || n != hf.buf.Size() {
-	 *    os.Remove(f.Name())
-	 *    title := fmt.Sprintf("Writing File <%s>", p)
-	 *    msg := fmt.Sprintf("Written %d bytes (expected %d)\nError: %v", n, hf.buf.Size(), err)
-	 *    ErrorDialog(title, msg)
-	 *}
-	 */
-	//use the iter interface
-	hf.buf.Iter(func(slice []byte) bool {
-		var n int
-		n, err = f.Write(slice)
-		return n != len(slice) || err != nil
-	})
-	if err != nil {
-		os.Remove(f.Name())
-		title := fmt.Sprintf("Writing File <%s>", p)
-		msg := fmt.Sprintf("Error: %v", err)
-		ErrorDialog(title, msg)
+	if !hf.writable("Save") {
+		return
 	}
-	err = os.Rename(f.Name(), p)
-	if err != nil {
-		os.Remove(f.Name())
-		title := fmt.Sprintf("Naming File <%s>", p)
-		msg := fmt.Sprintf("Couldn't rename tmp file <%s> to <%s>", f.Name(), p)
-		ErrorDialog(title, msg)
+	if _, changed := hf.changedOnDisk(); changed && p == hf.name {
+		msg := fmt.Sprintf("%s was changed by another program since it was loaded.\nOverwrite those changes?", p)
+		YesNoDialog("File Changed", msg, func() { writeFile(hf, p) })
+		return
 	}
+	writeFile(hf, p)
+}
 
-	//TODO XXX open a new buffer on the whole file again here?
-	//this would refresh the working tree buffer
-	hf.buf, err = filebuf.OpenFile(p)
-	if err != nil {
-		panic("TODO: handle OpenFile error after save")
+func writeFile(hf *HexFile, p string) {
+	inPlace := (hf.kind == KindBlockDevice || hf.kind == KindProcess) && p == hf.name
+	if err := hf.save(p); err != nil {
+		ErrorDialog(fmt.Sprintf("Saving <%s>", p), err.Error())
+		return
+	}
+	if inPlace {
+		return
+	}
+	if err := hf.saveProject(p); err != nil {
+		ErrorDialog(fmt.Sprintf("Saving Project <%s>", projectPath(p)), err.Error())
 	}
 }
 
 func actionSaveFile() {
-	if ActiveFile() != nil {
+	if file := ActiveFile(); file != nil && file.writable("Save") {
+		if file.kind == KindProcess {
+			//process memory has no file to pick, it goes back where it came from
+			actionWriteFile(file.name)
+			return
+		}
 		//TODO:
 		//if not is tmp/new file {
 		//   save to real file name
@@ -263,18 +390,171 @@ func actionSaveFile() {
 }
 
 func actionSaveAs() {
-	if ActiveFile() != nil {
+	if file := ActiveFile(); file != nil && file.writable("Save As") {
 		FileDialog(DialogSaveAs)
 	}
 }
 
+//put back the on-disk bytes of the changes in the selection
+func actionRevert() {
+	tab := ActiveTab()
+	file := ActiveFile()
+	if tab == nil || file == nil {
+		panic("Revert: tab or file is nil (shouldn't happen)")
+	}
+	if !file.writable("Revert") {
+		return
+	}
+	off, size := tab.view.Selection()
+	hunks := file.Changes()
+	which := hunksIn(hunks, off, size)
+	if len(which) == 0 {
+		return
+	}
+	err := file.Revert(hunks, which)
+	if err != nil {
+		ErrorDialog(fmt.Sprintf("Revert(%d, %d)", off, size), fmt.Sprint(err))
+	}
+}
+
+//vi mark: ma sets mark a at the cursor
+func actionSetMark(c byte) {
+	tab := ActiveTab()
+	file := ActiveFile()
+	if tab == nil || file == nil {
+		panic("SetMark: tab or file is nil (shouldn't happen)")
+	}
+	file.marks.setMark(c, tab.view.cursor)
+}
+
+//vi mark: 'a jumps to mark a
+func actionJumpMark(c byte) {
+	tab := ActiveTab()
+	file := ActiveFile()
+	if tab == nil || file == nil {
+		panic("JumpMark: tab or file is nil (shouldn't happen)")
+	}
+	off, ok := file.marks.mark(c)
+	if !ok {
+		return
+	}
+	tab.setCursor(off)
+	tab.view.SetSelection(0, 0)
+}
+
+func actionAddBookmark() {
+	tab := ActiveTab()
+	file := ActiveFile()
+	if tab == nil || file == nil {
+		return
+	}
+	off := tab.view.cursor
+	file.marks.addBookmark(fmt.Sprintf("bookmark %d", len(file.marks.bookmarks)+1), "", off)
+	HD.Bookmarks.open = true
+}
+
+func actionBookmarks() {
+	HD.Bookmarks.open = true
+}
+
+//annotate the selection
+func actionAnnotate() {
+	tab := ActiveTab()
+	file := ActiveFile()
+	if tab == nil || file == nil {
+		return
+	}
+	off, size := tab.view.Selection()
+	if size <= 0 {
+		return
+	}
+	a := file.annotations.add(off, size, fmt.Sprintf("annotation %d", len(file.annotations)+1))
+	HD.Annotations.open = true
+	HD.Annotations.selected = a
+}
+
+func actionAnnotations() {
+	HD.Annotations.open = true
+}
+
+func actionSaveProject() {
+	file := ActiveFile()
+	if file == nil {
+		return
+	}
+	if !file.onDisk() {
+		ErrorDialog("Save Project", fmt.Sprintf("%s is not a file, it has no project file", file.name))
+		return
+	}
+	if err := file.saveProject(file.name); err != nil {
+		ErrorDialog(fmt.Sprintf("Saving Project <%s>", projectPath(file.name)), err.Error())
+	}
+}
+
+func actionChanges() {
+	HD.Changes.open = true
+}
+
+func actionCompare() {
+	HD.Compare.Open()
+}
+
+func actionOpenProcess() {
+	HD.Process.Open()
+}
+
+func actionHash() {
+	HD.Hash.Open()
+}
+
+func actionBinPatch() {
+	HD.BinPatch.Open()
+}
+
+func actionEntropy() {
+	HD.Entropy.Open()
+}
+
+func actionStrings() {
+	HD.Strings.Open()
+}
+
+func actionBitmap() {
+	HD.Bitmap.Open()
+}
+
+//show or hide the minimap beside the hex view
+func actionMinimap() {
+	HD.Minimap.hidden = !HD.Minimap.hidden
+}
+
+func actionExport() {
+	if ActiveFile() != nil {
+		ExportDialog(DialogExport)
+	}
+}
+
 func actionCloseTab() {
 	if HD.ActiveTab >= 0 {
 		CloseTab(HD.ActiveTab)
 	}
 }
 
+//save the session and the projects of the opened files
+func shutdown() {
+	if err := saveSession(); err != nil {
+		fmt.Fprintf(os.Stderr, "saving session: %v\n", err)
+	}
+	for _, hf := range HD.Files {
+		if err := hf.closeProject(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: saving project: %v\n", hf.name, err)
+		}
+		hf.removeTemp()
+	}
+}
+
 func actionQuit() {
 	//TODO: "do you want to save unsaved changes..." dialog
+	shutdown()
 	os.Exit(0)
 }
@@ -0,0 +1,231 @@
+package main
+
+//annotations: coloured byte ranges with a label and a note
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+)
+
+//colours given to new annotations, in turn
+var annotationColors = []color.RGBA{
+	{R: 220, G: 60, B: 60, A: 110},
+	{R: 60, G: 180, B: 60, A: 110},
+	{R: 60, G: 100, B: 230, A: 110},
+	{R: 220, G: 180, B: 0, A: 110},
+	{R: 180, G: 60, B: 200, A: 110},
+	{R: 0, G: 190, B: 190, A: 110},
+}
+
+type annotation struct {
+	off, size int64
+	color     color.RGBA
+	label     string
+	note      string
+}
+
+//sorted by offset
+type fileAnnotations []*annotation
+
+//an annotation as it was before an edit cut into it
+type annotationState struct {
+	a         *annotation
+	off, size int64
+	removed   bool
+}
+
+//keep the range on the same bytes after [off, off+oldSize) is replaced by newSize bytes.
+//bytes inserted inside the range become part of it, a range that is cut away is removed.
+//returns the ranges that were cut into, undo puts them back with restore
+func (fa *fileAnnotations) adjust(off, oldSize, newSize int64) []annotationState {
+	var keep fileAnnotations
+	var cut []annotationState
+	for _, a := range *fa {
+		if oldSize > 0 && a.off <= off+oldSize && a.off+a.size >= off {
+			cut = append(cut, annotationState{a: a, off: a.off, size: a.size})
+		}
+		start := adjustOffset(a.off, off, oldSize, newSize)
+		end := a.off + a.size
+		switch {
+		case end <= off:
+		case end >= off+oldSize:
+			end += newSize - oldSize
+		case end-off > newSize:
+			end = off + newSize
+		}
+		if end > start {
+			a.off, a.size = start, end-start
+			keep = append(keep, a)
+		} else if len(cut) > 0 && cut[len(cut)-1].a == a {
+			cut[len(cut)-1].removed = true
+		}
+	}
+	*fa = keep
+	return cut
+}
+
+func (fa *fileAnnotations) restore(cut []annotationState) {
+	for _, s := range cut {
+		s.a.off, s.a.size = s.off, s.size
+		if s.removed {
+			*fa = append(*fa, s.a)
+		}
+	}
+	fa.sort()
+}
+
+func (fa *fileAnnotations) add(off, size int64, label string) *annotation {
+	a := &annotation{
+		off:   off,
+		size:  size,
+		color: annotationColors[len(*fa)%len(annotationColors)],
+		label: label,
+	}
+	*fa = append(*fa, a)
+	fa.sort()
+	return a
+}
+
+func (fa *fileAnnotations) sort() {
+	s := *fa
+	sort.SliceStable(s, func(i, j int) bool { return s[i].off < s[j].off })
+}
+
+func (fa *fileAnnotations) remove(a *annotation) {
+	s := *fa
+	for i := range s {
+		if s[i] == a {
+			*fa = append(s[:i], s[i+1:]...)
+			return
+		}
+	}
+}
+
+//the last (i.e. innermost, for nested ranges) annotation containing addr
+func (fa fileAnnotations) at(addr int64) *annotation {
+	var found *annotation
+	for _, a := range fa {
+		if a.off > addr {
+			break
+		}
+		if addr < a.off+a.size {
+			found = a
+		}
+	}
+	return found
+}
+
+func (fa fileAnnotations) highlighter() highlighter {
+	return func(addr int64) (color.RGBA, bool) {
+		if a := fa.at(addr); a != nil {
+			return a.color, true
+		}
+		return color.RGBA{}, false
+	}
+}
+
+//tooltip for the hexview
+func (fa fileAnnotations) tooltip() tooltipper {
+	return func(addr int64) string {
+		a := fa.at(addr)
+		if a == nil {
+			return ""
+		}
+		s := fmt.Sprintf("%s [%X-%X] (%d bytes)", a.label, a.off, a.off+a.size, a.size)
+		if a.note != "" {
+			s += "\n" + a.note
+		}
+		return s
+	}
+}
+
+/*
+ * Annotations window
+ */
+
+type annotationsWindow struct {
+	open     bool
+	selected *annotation //its note is shown in the editor
+}
+
+func (aw *annotationsWindow) annotationTable(hf *HexFile) {
+	flags := I.TableFlags_ScrollY | I.TableFlags_RowBg | I.TableFlags_Resizable
+	if I.BeginTable("AnnotationsTable", 4, flags, I.ContentRegionAvail(), 0) {
+		defer I.EndTable()
+		I.TableSetupColumn("Label", 0, 0, 0)
+		I.TableSetupColumn("Range", 0, 0, 0)
+		I.TableSetupColumn("Colour", 0, 0, 0)
+		I.TableSetupColumn("", 0, 0, 0)
+		I.TableSetupScrollFreeze(0, 1)
+		I.TableHeadersRow()
+
+		for i, a := range hf.annotations {
+			I.TableNextRow(0, 0)
+			I.TableNextColumn()
+			cellInput(fmt.Sprint("##annotationlabel", i), &a.label)
+			I.TableNextColumn()
+			label := fmt.Sprintf("%X-%X##annotation%d", hf.fw.base+a.off, hf.fw.base+a.off+a.size, i)
+			if I.SelectableV(label, a == aw.selected, selectFlags, I.Vec2{}) {
+				aw.selected = a
+				if tab := ActiveTab(); tab != nil {
+					tab.setCursor(a.off)
+					tab.view.SetSelection(a.off, a.size)
+				}
+			}
+			I.TableNextColumn()
+			c := G.ToVec4Color(a.color)
+			col := [4]float32{c.X, c.Y, c.Z, c.W}
+			if I.ColorEdit4V(fmt.Sprint("##annotationcolor", i), &col, I.ColorEditFlagsNoInputs|I.ColorEditFlagsAlphaBar) {
+				a.color = G.Vec4ToRGBA(I.Vec4{X: col[0], Y: col[1], Z: col[2], W: col[3]})
+			}
+			I.TableNextColumn()
+			if I.SmallButton(fmt.Sprint("Delete##annotationdel", i)) {
+				hf.annotations.remove(a)
+				if aw.selected == a {
+					aw.selected = nil
+				}
+			}
+		}
+	}
+}
+
+func (aw *annotationsWindow) noteEditor(hf *HexFile) G.Widget {
+	a := aw.selected
+	for _, b := range hf.annotations {
+		if a == b {
+			return G.Custom(func() {
+				I.Text("Note for " + a.label)
+				I.InputTextMultilineV("##annotationnote", &a.note, I.Vec2{X: -1, Y: -1}, 0, nil)
+			})
+		}
+	}
+	return G.Label("Select an annotation to edit its note.")
+}
+
+func drawAnnotationsWindow() {
+	aw := &HD.Annotations
+	if !aw.open {
+		return
+	}
+	var layout G.Layout
+	hf := ActiveFile()
+	if hf == nil {
+		layout = G.Layout{G.Label("No file opened.")}
+	} else {
+		layout = G.Layout{
+			G.Row(
+				G.Button("Annotate Selection").OnClick(actionAnnotate),
+				G.Button("Save Project").OnClick(actionSaveProject),
+			),
+			G.SplitLayout(G.DirectionVertical, true, 250,
+				G.Custom(func() { aw.annotationTable(hf) }),
+				aw.noteEditor(hf),
+			),
+		}
+	}
+	G.Window("Annotations").IsOpen(&aw.open).Pos(620, 30).Size(450, 450).Layout(layout...)
+}
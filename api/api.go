@@ -0,0 +1,117 @@
+//Package api is the interface between hexdunk and its plugins.
+//
+//A plugin is a Go plugin (go build -buildmode=plugin) that registers itself in an
+//init function:
+//
+//	func init() {
+//		api.Register(&api.Plugin{
+//			Name: "Upper",
+//			Menu: []api.MenuItem{{Label: "Upper Case Selection", Action: upper}},
+//		})
+//	}
+//
+//It is loaded with Plugin -> Load. Go plugins must be built with the same Go version
+//and the same versions of the packages they share with hexdunk (at least this one).
+//
+//All functions work on the active file and view, and must be called from a menu
+//action (i.e. in the gui thread). Edits can be undone like any other edit.
+package api
+
+import "errors"
+
+//ErrNoFile is returned when there is no active file
+var ErrNoFile = errors.New("no file opened")
+
+//a menu item in the submenu of a plugin, an error returned by Action is shown in an
+//error dialog
+type MenuItem struct {
+	Label  string
+	Action func() error
+}
+
+type Plugin struct {
+	Name string
+	Menu []MenuItem
+}
+
+var plugins []*Plugin
+
+//Register a plugin, call it in the init function of the plugin
+func Register(p *Plugin) {
+	plugins = append(plugins, p)
+}
+
+//Registered returns all plugins registered so far, for hexdunk
+func Registered() []*Plugin {
+	return plugins
+}
+
+//Host is implemented by hexdunk
+type Host interface {
+	FileName() (string, error)
+	Size() (int64, error)
+	Read(off, size int64) ([]byte, error)
+	Write(off int64, data []byte) error
+	Insert(off int64, data []byte) error
+	Delete(off, size int64) error
+
+	Cursor() (int64, error)
+	SetCursor(off int64) error
+	Selection() (off, size int64, err error)
+	SetSelection(off, size int64) error
+
+	Group(edits func() error) error
+
+	Info(title, msg string)
+	Error(title, msg string)
+	Confirm(title, msg string, yes func())
+}
+
+var host Host
+
+//SetHost is called by hexdunk on startup
+func SetHost(h Host) {
+	host = h
+}
+
+//FileName returns the path of the active file
+func FileName() (string, error) { return host.FileName() }
+
+//Size returns the size of the active file
+func Size() (int64, error) { return host.Size() }
+
+//Read size bytes at off
+func Read(off, size int64) ([]byte, error) { return host.Read(off, size) }
+
+//Write overwrites the bytes at off with data, the file grows if data runs past EOF
+func Write(off int64, data []byte) error { return host.Write(off, data) }
+
+//Insert data at off
+func Insert(off int64, data []byte) error { return host.Insert(off, data) }
+
+//Delete size bytes at off
+func Delete(off, size int64) error { return host.Delete(off, size) }
+
+//Cursor returns the offset of the cursor
+func Cursor() (int64, error) { return host.Cursor() }
+
+//SetCursor moves the cursor to off and scrolls it into view
+func SetCursor(off int64) error { return host.SetCursor(off) }
+
+//Selection returns the selected range (size 0 for none)
+func Selection() (off, size int64, err error) { return host.Selection() }
+
+//SetSelection selects size bytes at off
+func SetSelection(off, size int64) error { return host.SetSelection(off, size) }
+
+//Group calls edits, the edits it makes are undone (and redone) as one
+func Group(edits func() error) error { return host.Group(edits) }
+
+//Info shows a message dialog
+func Info(title, msg string) { host.Info(title, msg) }
+
+//Error shows an error dialog
+func Error(title, msg string) { host.Error(title, msg) }
+
+//Confirm asks a yes/no question, yes is called if the answer is yes
+func Confirm(title, msg string, yes func()) { host.Confirm(title, msg, yes) }
@@ -0,0 +1,606 @@
+package main
+
+//binary patch files: IPS, BPS (beat) and a simple JSON format.
+//patches are made from the differences between the active file and its original (or
+//another opened file), and applied to the active file as 1 undo-able edit.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	G "github.com/AllenDang/giu"
+	B "github.com/snhmibby/filebuf"
+)
+
+//patches are made and applied in memory
+const maxPatchedSize = 512 << 20
+
+type patchFormat struct {
+	name string
+	ext  string
+	make func(src, dst []byte) ([]byte, error)
+	//apply returns the patched src, or an error if the patch doesn't fit src
+	apply func(patch, src []byte) ([]byte, error)
+}
+
+var patchFormats = []patchFormat{
+	{"IPS", ".ips", makeIPS, applyIPS},
+	{"BPS", ".bps", makeBPS, applyBPS},
+	{"JSON", ".json", makeJSONPatch, applyJSONPatch},
+}
+
+//the format of a patch file, by its magic
+func detectPatchFormat(patch []byte) (*patchFormat, error) {
+	switch {
+	case bytes.HasPrefix(patch, []byte("PATCH")):
+		return &patchFormats[0], nil
+	case bytes.HasPrefix(patch, []byte("BPS1")):
+		return &patchFormats[1], nil
+	case bytes.HasPrefix(bytes.TrimSpace(patch), []byte("{")):
+		return &patchFormats[2], nil
+	}
+	return nil, fmt.Errorf("not an IPS, BPS or JSON patch")
+}
+
+//all bytes of b
+func bufBytes(b *B.Buffer) ([]byte, error) {
+	if b.Size() > maxPatchedSize {
+		return nil, fmt.Errorf("%s is too big to patch", mkSize(b.Size()))
+	}
+	data := make([]byte, b.Size())
+	b.Seek(0, io.SeekStart)
+	_, err := io.ReadFull(b, data)
+	return data, err
+}
+
+//the differences between src and dst, the a-side is src
+func diffBytes(src, dst []byte) []diffHunk {
+	return diffReaders(bytes.NewReader(src), bytes.NewReader(dst), int64(len(src)), int64(len(dst)), nil)
+}
+
+//apply a patch file to hf as 1 undo-able edit, returns the number of changes made
+func (hf *HexFile) applyPatch(patch []byte) (*patchFormat, int, error) {
+	format, err := detectPatchFormat(patch)
+	if err != nil {
+		return nil, 0, err
+	}
+	if hf.readOnly {
+		return format, 0, fmt.Errorf("%s is opened read-only", hf.name)
+	}
+	src, err := bufBytes(hf.buf)
+	if err != nil {
+		return format, 0, err
+	}
+	dst, err := format.apply(patch, src)
+	if err != nil {
+		return format, 0, fmt.Errorf("%s patch: %v", format.name, err)
+	}
+
+	//only replace what changed, from back to front so the offsets stay valid
+	hunks := diffBytes(src, dst)
+	n := 0
+	for i := len(hunks) - 1; i >= 0; i-- {
+		h := hunks[i]
+		data := append([]byte(nil), dst[h.bOff:h.bOff+h.bSize]...)
+		if err := hf.Replace(h.aOff, h.aSize, B.NewMem(data)); err != nil {
+			hf.groupUndo(n)
+			return format, n, err
+		}
+		n++
+	}
+	hf.groupUndo(n)
+	return format, n, nil
+}
+
+/*
+ * IPS: records of (3 byte offset, 2 byte size, data), or run-length encoded records
+ * (offset, 0, 2 byte count, byte). Followed by "EOF" and an optional 3 byte size to
+ * truncate the file to. No checksums, offsets are limited to 16MB.
+ */
+
+const (
+	ipsMaxOffset = 1<<24 - 1
+	ipsMaxRecord = 0xFFFF
+	ipsEOF       = 0x454F46 //"EOF", can't be used as a record offset
+)
+
+func makeIPS(src, dst []byte) ([]byte, error) {
+	if len(dst) > ipsMaxOffset {
+		return nil, fmt.Errorf("IPS patches are limited to 16MB files")
+	}
+	differs := func(i int) bool {
+		return i >= len(src) || src[i] != dst[i]
+	}
+	out := []byte("PATCH")
+	record := func(off, end int) {
+		for off < end {
+			if off == ipsEOF {
+				off-- //start a byte earlier
+			}
+			n := end - off
+			if n > ipsMaxRecord {
+				n = ipsMaxRecord
+			}
+			out = append(out, byte(off>>16), byte(off>>8), byte(off), byte(n>>8), byte(n))
+			out = append(out, dst[off:off+n]...)
+			off += n
+		}
+	}
+	for i := 0; i < len(dst); {
+		if !differs(i) {
+			i++
+			continue
+		}
+		//a record costs 5 bytes, so join runs of changes with small gaps between them
+		end, gap := i, 0
+		for j := i; j < len(dst) && gap < 5; j++ {
+			if differs(j) {
+				end, gap = j+1, 0
+			} else {
+				gap++
+			}
+		}
+		record(i, end)
+		i = end
+	}
+	out = append(out, "EOF"...)
+	if len(dst) < len(src) {
+		//extension: the size to truncate the file to
+		n := len(dst)
+		out = append(out, byte(n>>16), byte(n>>8), byte(n))
+	}
+	return out, nil
+}
+
+func applyIPS(patch, src []byte) ([]byte, error) {
+	p := patch[len("PATCH"):]
+	dst := append([]byte(nil), src...)
+	need := func(n int) error {
+		if len(p) < n {
+			return fmt.Errorf("the patch is cut short")
+		}
+		return nil
+	}
+	for {
+		if err := need(3); err != nil {
+			return nil, err
+		}
+		off := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		p = p[3:]
+		if off == ipsEOF {
+			break
+		}
+		if err := need(2); err != nil {
+			return nil, err
+		}
+		size := int(binary.BigEndian.Uint16(p))
+		p = p[2:]
+		var data []byte
+		if size == 0 {
+			//run-length encoded
+			if err := need(3); err != nil {
+				return nil, err
+			}
+			data = bytes.Repeat(p[2:3], int(binary.BigEndian.Uint16(p)))
+			p = p[3:]
+		} else {
+			if err := need(size); err != nil {
+				return nil, err
+			}
+			data, p = p[:size], p[size:]
+		}
+		if end := off + len(data); end > len(dst) {
+			dst = append(dst, make([]byte, end-len(dst))...)
+		}
+		copy(dst[off:], data)
+	}
+	if len(p) >= 3 {
+		n := int(p[0])<<16 | int(p[1])<<8 | int(p[2])
+		if n < len(dst) {
+			dst = dst[:n]
+		}
+	}
+	return dst, nil
+}
+
+/*
+ * BPS: the source and target sizes, and actions that build the target from pieces of
+ * the source, new data and pieces of the target made so far. Ends with the CRC-32 of
+ * the source, the target and the patch itself.
+ */
+
+const (
+	bpsSourceRead = iota
+	bpsTargetRead
+	bpsSourceCopy
+	bpsTargetCopy
+)
+
+func bpsPutNumber(out []byte, n uint64) []byte {
+	for {
+		x := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(out, x|0x80)
+		}
+		out = append(out, x)
+		n--
+	}
+}
+
+func bpsNumber(p []byte) (uint64, []byte, error) {
+	var n uint64
+	shift := uint64(1)
+	for i, x := range p {
+		if i > 9 {
+			break
+		}
+		n += uint64(x&0x7f) * shift
+		if x&0x80 != 0 {
+			return n, p[i+1:], nil
+		}
+		shift <<= 7
+		n += shift
+	}
+	return 0, nil, fmt.Errorf("bad number")
+}
+
+func makeBPS(src, dst []byte) ([]byte, error) {
+	out := []byte("BPS1")
+	out = bpsPutNumber(out, uint64(len(src)))
+	out = bpsPutNumber(out, uint64(len(dst)))
+	out = bpsPutNumber(out, 0) //no metadata
+	action := func(cmd int, size int64) {
+		out = bpsPutNumber(out, uint64(size-1)<<2|uint64(cmd))
+	}
+
+	//the equal bytes between the hunks are read from the source
+	var a, b, sourceRel int64
+	equal := func(size int64) {
+		if size == 0 {
+			return
+		}
+		if a == b {
+			action(bpsSourceRead, size)
+		} else {
+			action(bpsSourceCopy, size)
+			d := a - sourceRel
+			if d < 0 {
+				out = bpsPutNumber(out, uint64(-d)<<1|1)
+			} else {
+				out = bpsPutNumber(out, uint64(d)<<1)
+			}
+			sourceRel = a + size
+		}
+		a += size
+		b += size
+	}
+	for _, h := range diffBytes(src, dst) {
+		equal(h.aOff - a)
+		if h.bSize > 0 {
+			action(bpsTargetRead, h.bSize)
+			out = append(out, dst[h.bOff:h.bOff+h.bSize]...)
+		}
+		a, b = h.aOff+h.aSize, h.bOff+h.bSize
+	}
+	equal(int64(len(src)) - a)
+
+	out = bpsPutCRC(out, src)
+	out = bpsPutCRC(out, dst)
+	return bpsPutCRC(out, out), nil
+}
+
+//append the CRC-32 of data, little endian
+func bpsPutCRC(out, data []byte) []byte {
+	var crc [4]byte
+	binary.LittleEndian.PutUint32(crc[:], crc32.ChecksumIEEE(data))
+	return append(out, crc[:]...)
+}
+
+func applyBPS(patch, src []byte) ([]byte, error) {
+	if len(patch) < len("BPS1")+12 {
+		return nil, fmt.Errorf("the patch is cut short")
+	}
+	footer := patch[len(patch)-12:]
+	if crc32.ChecksumIEEE(patch[:len(patch)-4]) != binary.LittleEndian.Uint32(footer[8:]) {
+		return nil, fmt.Errorf("the patch file is damaged (bad checksum)")
+	}
+	if crc32.ChecksumIEEE(src) != binary.LittleEndian.Uint32(footer) {
+		return nil, fmt.Errorf("the patch is for another file (source checksum differs)")
+	}
+
+	p := patch[len("BPS1") : len(patch)-12]
+	var sizes [3]uint64 //source, target, metadata
+	for i := range sizes {
+		var err error
+		if sizes[i], p, err = bpsNumber(p); err != nil {
+			return nil, err
+		}
+	}
+	if sizes[0] != uint64(len(src)) {
+		return nil, fmt.Errorf("the patch is for a file of %d bytes", sizes[0])
+	}
+	if sizes[1] > maxPatchedSize || sizes[2] > uint64(len(p)) {
+		return nil, fmt.Errorf("bad header")
+	}
+	p = p[sizes[2]:]
+
+	dst := make([]byte, 0, sizes[1])
+	var sourceRel, targetRel int64
+	relative := func() (int64, error) {
+		n, rest, err := bpsNumber(p)
+		p = rest
+		if n&1 != 0 {
+			return -int64(n >> 1), err
+		}
+		return int64(n >> 1), err
+	}
+	for len(p) > 0 {
+		n, rest, err := bpsNumber(p)
+		if err != nil {
+			return nil, err
+		}
+		p = rest
+		cmd, size := int(n&3), int64(n>>2)+1
+		if uint64(len(dst))+uint64(size) > sizes[1] {
+			return nil, fmt.Errorf("the patch writes past the end of the target")
+		}
+		out := int64(len(dst))
+		switch cmd {
+		case bpsSourceRead:
+			if out+size > int64(len(src)) {
+				return nil, fmt.Errorf("source read past the end of the source")
+			}
+			dst = append(dst, src[out:out+size]...)
+		case bpsTargetRead:
+			if size > int64(len(p)) {
+				return nil, fmt.Errorf("the patch is cut short")
+			}
+			dst = append(dst, p[:size]...)
+			p = p[size:]
+		case bpsSourceCopy:
+			d, err := relative()
+			if err != nil {
+				return nil, err
+			}
+			sourceRel += d
+			if sourceRel < 0 || sourceRel+size > int64(len(src)) {
+				return nil, fmt.Errorf("source copy outside of the source")
+			}
+			dst = append(dst, src[sourceRel:sourceRel+size]...)
+			sourceRel += size
+		case bpsTargetCopy:
+			d, err := relative()
+			if err != nil {
+				return nil, err
+			}
+			targetRel += d
+			if targetRel < 0 || targetRel >= out {
+				return nil, fmt.Errorf("target copy outside of the target")
+			}
+			//byte by byte, the copy can overlap what it writes
+			for k := int64(0); k < size; k++ {
+				dst = append(dst, dst[targetRel])
+				targetRel++
+			}
+		}
+	}
+	if uint64(len(dst)) != sizes[1] {
+		return nil, fmt.Errorf("the target is %d bytes instead of %d", len(dst), sizes[1])
+	}
+	if crc32.ChecksumIEEE(dst) != binary.LittleEndian.Uint32(footer[4:]) {
+		return nil, fmt.Errorf("the result has the wrong checksum")
+	}
+	return dst, nil
+}
+
+/*
+ * JSON: a list of replaced ranges of the source, with sizes and checksums of the source
+ * and the target (the checksums are optional when applying)
+ */
+
+type jsonPatch struct {
+	Format      string            `json:"format"`
+	SourceSize  int64             `json:"source_size"`
+	SourceCRC32 string            `json:"source_crc32,omitempty"`
+	TargetSize  int64             `json:"target_size"`
+	TargetCRC32 string            `json:"target_crc32,omitempty"`
+	Records     []jsonPatchRecord `json:"records"`
+}
+
+//size bytes at offset (in the source) are replaced by data (hex)
+type jsonPatchRecord struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Data   string `json:"data"`
+}
+
+const jsonPatchFormat = "hexdunk-patch"
+
+func crcString(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.ChecksumIEEE(data))
+}
+
+func makeJSONPatch(src, dst []byte) ([]byte, error) {
+	jp := jsonPatch{
+		Format:      jsonPatchFormat,
+		SourceSize:  int64(len(src)),
+		SourceCRC32: crcString(src),
+		TargetSize:  int64(len(dst)),
+		TargetCRC32: crcString(dst),
+		Records:     []jsonPatchRecord{},
+	}
+	for _, h := range diffBytes(src, dst) {
+		jp.Records = append(jp.Records, jsonPatchRecord{
+			Offset: h.aOff,
+			Size:   h.aSize,
+			Data:   hex.EncodeToString(dst[h.bOff : h.bOff+h.bSize]),
+		})
+	}
+	out, err := json.MarshalIndent(jp, "", "  ")
+	return append(out, '\n'), err
+}
+
+func applyJSONPatch(patch, src []byte) ([]byte, error) {
+	var jp jsonPatch
+	if err := json.Unmarshal(patch, &jp); err != nil {
+		return nil, err
+	}
+	if jp.Format != jsonPatchFormat {
+		return nil, fmt.Errorf("unknown format %q", jp.Format)
+	}
+	if jp.SourceSize != int64(len(src)) {
+		return nil, fmt.Errorf("the patch is for a file of %d bytes", jp.SourceSize)
+	}
+	if jp.SourceCRC32 != "" && !strings.EqualFold(jp.SourceCRC32, crcString(src)) {
+		return nil, fmt.Errorf("the patch is for another file (source checksum differs)")
+	}
+	var dst []byte
+	var at int64
+	for _, r := range jp.Records {
+		if r.Offset < at || r.Size < 0 || r.Offset+r.Size > int64(len(src)) {
+			return nil, fmt.Errorf("bad record at %#x, the records must be sorted and inside the file", r.Offset)
+		}
+		data, err := hex.DecodeString(r.Data)
+		if err != nil {
+			return nil, fmt.Errorf("record at %#x: %v", r.Offset, err)
+		}
+		dst = append(dst, src[at:r.Offset]...)
+		dst = append(dst, data...)
+		at = r.Offset + r.Size
+	}
+	dst = append(dst, src[at:]...)
+	if int64(len(dst)) != jp.TargetSize {
+		return nil, fmt.Errorf("the target is %d bytes instead of %d", len(dst), jp.TargetSize)
+	}
+	if jp.TargetCRC32 != "" && !strings.EqualFold(jp.TargetCRC32, crcString(dst)) {
+		return nil, fmt.Errorf("the result has the wrong checksum")
+	}
+	return dst, nil
+}
+
+/*
+ * the binary patch window
+ */
+
+type binPatchWindow struct {
+	open   bool
+	format int32
+	source int32  //0 is the original of the active file, i is the i-1th other file
+	status string //result of the last action
+}
+
+func (pw *binPatchWindow) Open() {
+	pw.open = true
+}
+
+//the other opened files, that can be the source of a patch
+func (pw *binPatchWindow) sources(hf *HexFile) []string {
+	names := []string{"Original on disk"}
+	for _, n := range openFileNames() {
+		if n != hf.name {
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+//make a patch from the source to the active file
+func (pw *binPatchWindow) makePatch() ([]byte, error) {
+	hf := ActiveFile()
+	if hf == nil {
+		return nil, fmt.Errorf("no file opened")
+	}
+	from := hf.orig
+	if names := pw.sources(hf); pw.source > 0 && int(pw.source) < len(names) {
+		from = HD.Files[names[pw.source]].buf
+	}
+	if from == nil {
+		return nil, fmt.Errorf("%s has no original", hf.name)
+	}
+	src, err := bufBytes(from)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := bufBytes(hf.buf)
+	if err != nil {
+		return nil, err
+	}
+	return patchFormats[pw.format].make(src, dst)
+}
+
+//callback of the save patch dialog
+func (pw *binPatchWindow) writePatch(p string) {
+	if filepath.Ext(p) == "" {
+		p += patchFormats[pw.format].ext
+	}
+	patch, err := pw.makePatch()
+	if err == nil {
+		err = os.WriteFile(p, patch, 0644)
+	}
+	if err != nil {
+		ErrorDialog(fmt.Sprintf("Creating patch <%s>", p), err.Error())
+		return
+	}
+	pw.status = fmt.Sprintf("wrote %s (%s)", p, mkSize(int64(len(patch))))
+}
+
+//callback of the apply patch dialog
+func (pw *binPatchWindow) applyPatchFile(p string) {
+	hf := ActiveFile()
+	if hf == nil {
+		return
+	}
+	patch, err := os.ReadFile(p)
+	if err != nil {
+		ErrorDialog(fmt.Sprintf("Applying patch <%s>", p), err.Error())
+		return
+	}
+	format, n, err := hf.applyPatch(patch)
+	if err != nil {
+		ErrorDialog(fmt.Sprintf("Applying patch <%s>", p), err.Error())
+		return
+	}
+	pw.status = fmt.Sprintf("applied %s patch %s: %d changes", format.name, filepath.Base(p), n)
+}
+
+func drawBinPatchWindow() {
+	pw := &HD.BinPatch
+	if !pw.open {
+		return
+	}
+	hf := ActiveFile()
+	if hf == nil {
+		G.Window("Binary Patch").IsOpen(&pw.open).Layout(G.Label("No file opened"))
+		return
+	}
+	formats := make([]string, len(patchFormats))
+	for i, f := range patchFormats {
+		formats[i] = f.name
+	}
+	sources := pw.sources(hf)
+	if int(pw.source) >= len(sources) {
+		pw.source = 0
+	}
+
+	G.Window("Binary Patch").IsOpen(&pw.open).Pos(150, 150).Size(500, 220).Layout(
+		G.Label(fmt.Sprintf("Patch from a source to %s", hf.name)),
+		G.Combo("Source", sources[pw.source], sources, &pw.source),
+		G.Combo("Format", formats[pw.format], formats, &pw.format),
+		G.Row(
+			G.Button("Create Patch").OnClick(func() { FileDialog(DialogSavePatch) }),
+			G.Condition(!hf.readOnly,
+				G.Layout{G.Button("Apply Patch").OnClick(func() { FileDialog(DialogApplyPatch) })},
+				G.Layout{G.Label("(read only)")}),
+		),
+		G.Label(pw.status),
+	)
+}
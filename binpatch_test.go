@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+//source and target pairs every patch format must reproduce
+func patchSamples() []struct {
+	name     string
+	src, dst []byte
+} {
+	rng := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		rng.Read(b)
+		return b
+	}
+	base := random(4096)
+	mutated := append([]byte(nil), base...)
+	for i := 0; i < 50; i++ {
+		off := rng.Intn(len(mutated))
+		switch rng.Intn(3) {
+		case 0:
+			mutated[off] ^= 0xFF
+		case 1:
+			mutated = append(mutated[:off], append(random(rng.Intn(20)), mutated[off:]...)...)
+		case 2:
+			end := off + rng.Intn(20)
+			if end > len(mutated) {
+				end = len(mutated)
+			}
+			mutated = append(mutated[:off], mutated[end:]...)
+		}
+	}
+	//the only change is at the offset that reads as "EOF" in IPS
+	eof := make([]byte, ipsEOF+16)
+	eofChanged := append([]byte(nil), eof...)
+	eofChanged[ipsEOF] = 1
+
+	return []struct {
+		name     string
+		src, dst []byte
+	}{
+		{"equal", []byte("hexdunk"), []byte("hexdunk")},
+		{"empty source", nil, []byte("hexdunk")},
+		{"empty target", []byte("hexdunk"), nil},
+		{"1 byte", []byte("hexdunk"), []byte("hexDunk")},
+		{"insert", []byte("0123456789"), []byte("01234abc56789")},
+		{"delete", []byte("0123456789"), []byte("01289")},
+		{"grow", []byte("0123"), []byte("0123456789")},
+		{"shrink", []byte("0123456789"), []byte("0123")},
+		{"near changes", []byte("aaaaaaaaaaaaaaaa"), []byte("abaabaaaaaaaaaba")},
+		{"big record", random(1000), append(random(ipsMaxRecord+100), 0)},
+		{"mutated", base, mutated},
+		{"EOF offset", eof, eofChanged},
+	}
+}
+
+func TestPatchRoundTrip(t *testing.T) {
+	for _, tc := range patchSamples() {
+		for _, f := range patchFormats {
+			patch, err := f.make(tc.src, tc.dst)
+			if err != nil {
+				t.Errorf("%s %s: make: %v", f.name, tc.name, err)
+				continue
+			}
+			if format, err := detectPatchFormat(patch); err != nil || format.name != f.name {
+				t.Errorf("%s %s: detected as %v (%v)", f.name, tc.name, format, err)
+			}
+			got, err := f.apply(patch, tc.src)
+			if err != nil {
+				t.Errorf("%s %s: apply: %v", f.name, tc.name, err)
+			} else if !bytes.Equal(got, tc.dst) {
+				t.Errorf("%s %s: the result differs from the target", f.name, tc.name)
+			}
+		}
+	}
+}
+
+func TestIPSEOFOffset(t *testing.T) {
+	src := make([]byte, ipsEOF+16)
+	dst := append([]byte(nil), src...)
+	dst[ipsEOF] = 1
+	patch, _ := makeIPS(src, dst)
+	//the record starts a byte earlier
+	if want := []byte{0x45, 0x4F, 0x45, 0, 2, 0, 1}; !bytes.HasPrefix(patch[len("PATCH"):], want) {
+		t.Errorf("record % X, want % X", patch[len("PATCH"):len("PATCH")+7], want)
+	}
+}
+
+func TestApplyIPS(t *testing.T) {
+	src := []byte("abcdefgh")
+	tests := []struct {
+		name  string
+		patch string
+		want  string //"" is an error
+	}{
+		{"empty", "PATCHEOF", "abcdefgh"},
+		{"record", "PATCH\x00\x00\x01\x00\x02XYEOF", "aXYdefgh"},
+		{"past the end", "PATCH\x00\x00\x0A\x00\x01ZEOF", "abcdefgh\x00\x00Z"},
+		{"rle", "PATCH\x00\x00\x02\x00\x00\x00\x04xEOF", "abxxxxgh"},
+		{"rle past the end", "PATCH\x00\x00\x06\x00\x00\x00\x03-EOF", "abcdef---"},
+		{"rle of 0", "PATCH\x00\x00\x02\x00\x00\x00\x00xEOF", "abcdefgh"},
+		{"truncate", "PATCHEOF\x00\x00\x03", "abc"},
+		{"truncate after a record", "PATCH\x00\x00\x0A\x00\x01ZEOF\x00\x00\x05", "abcde"},
+		{"truncate to more", "PATCHEOF\x00\x01\x00", "abcdefgh"},
+		{"garbage after EOF", "PATCHEOF\x00", "abcdefgh"},
+		{"no EOF", "PATCH\x00\x00\x01\x00\x02XY", ""},
+		{"cut in the offset", "PATCH\x00\x00", ""},
+		{"cut in the size", "PATCH\x00\x00\x01\x00", ""},
+		{"cut in the data", "PATCH\x00\x00\x01\x00\x05XY", ""},
+		{"cut in the rle", "PATCH\x00\x00\x01\x00\x00\x00\x04", ""},
+	}
+	for _, tc := range tests {
+		got, err := applyIPS([]byte(tc.patch), src)
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("%s: no error", tc.name)
+		case tc.want != "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.want != "" && string(got) != tc.want:
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+	if string(src) != "abcdefgh" {
+		t.Errorf("the source was changed: %q", src)
+	}
+}
+
+func TestBPSNumber(t *testing.T) {
+	tests := []struct {
+		n       uint64
+		encoded []byte
+	}{
+		{0, []byte{0x80}},
+		{1, []byte{0x81}},
+		{127, []byte{0xFF}},
+		{128, []byte{0x00, 0x80}},
+		{129, []byte{0x01, 0x80}},
+		{16511, []byte{0x7F, 0xFF}}, //the biggest of 2 bytes
+		{16512, []byte{0x00, 0x00, 0x80}},
+		{16513, []byte{0x01, 0x00, 0x80}},
+	}
+	for _, tc := range tests {
+		if got := bpsPutNumber(nil, tc.n); !bytes.Equal(got, tc.encoded) {
+			t.Errorf("%d encoded as % X, want % X", tc.n, got, tc.encoded)
+		}
+	}
+	for _, n := range []uint64{0, 1, 127, 128, 1000, 1 << 20, 1<<32 + 5, 1 << 56, 1<<63 - 1, 1 << 63} {
+		encoded := append(bpsPutNumber(nil, n), 0xAA)
+		got, rest, err := bpsNumber(encoded)
+		if err != nil || got != n || !bytes.Equal(rest, []byte{0xAA}) {
+			t.Errorf("%d: decoded %d, rest % X, %v", n, got, rest, err)
+		}
+	}
+	for _, bad := range [][]byte{nil, {0x00}, {0x7F, 0x7F}, bytes.Repeat([]byte{0}, 11)} {
+		if _, _, err := bpsNumber(bad); err == nil {
+			t.Errorf("% X: no error", bad)
+		}
+	}
+}
+
+//a BPS patch with the given actions, with the right checksums
+func bpsPatch(src, dst []byte, actions ...[]byte) []byte {
+	out := []byte("BPS1")
+	out = bpsPutNumber(out, uint64(len(src)))
+	out = bpsPutNumber(out, uint64(len(dst)))
+	out = bpsPutNumber(out, 0)
+	for _, a := range actions {
+		out = append(out, a...)
+	}
+	out = bpsPutCRC(out, src)
+	out = bpsPutCRC(out, dst)
+	return bpsPutCRC(out, out)
+}
+
+//an action, followed by its data (target read) or relative offset (copies)
+func bpsAction(cmd, size int, arg interface{}) []byte {
+	out := bpsPutNumber(nil, uint64(size-1)<<2|uint64(cmd))
+	switch a := arg.(type) {
+	case string:
+		out = append(out, a...)
+	case int:
+		if a < 0 {
+			return bpsPutNumber(out, uint64(-a)<<1|1)
+		}
+		return bpsPutNumber(out, uint64(a)<<1)
+	}
+	return out
+}
+
+func TestApplyBPS(t *testing.T) {
+	src := []byte("abcdefgh")
+	tests := []struct {
+		name    string
+		dst     string
+		actions [][]byte
+		ok      bool
+	}{
+		{"source read", "abcd", [][]byte{bpsAction(bpsSourceRead, 4, nil)}, true},
+		{"target read", "xy", [][]byte{bpsAction(bpsTargetRead, 2, "xy")}, true},
+		{"relative copies", "ghabczczczcgh", [][]byte{
+			bpsAction(bpsSourceCopy, 2, 6),  //gh
+			bpsAction(bpsSourceCopy, 3, -8), //abc, back to the start
+			bpsAction(bpsTargetRead, 1, "z"),
+			bpsAction(bpsTargetCopy, 5, 4),  //czczc, overlaps what it writes
+			bpsAction(bpsTargetCopy, 2, -9), //gh, back to the start
+		}, true},
+		{"source read past the source", "abcdefghab", [][]byte{
+			bpsAction(bpsTargetRead, 2, "ab"),
+			bpsAction(bpsSourceRead, 8, nil),
+		}, false},
+		{"source copy before the source", "a", [][]byte{bpsAction(bpsSourceCopy, 1, -1)}, false},
+		{"source copy past the source", "ab", [][]byte{bpsAction(bpsSourceCopy, 2, 7)}, false},
+		{"target copy of nothing", "a", [][]byte{bpsAction(bpsTargetCopy, 1, 0)}, false},
+		{"target copy ahead", "aa", [][]byte{
+			bpsAction(bpsTargetRead, 1, "a"),
+			bpsAction(bpsTargetCopy, 1, 1),
+		}, false},
+		{"past the target", "ab", [][]byte{bpsAction(bpsSourceRead, 3, nil)}, false},
+		{"short target", "abc", [][]byte{bpsAction(bpsSourceRead, 2, nil)}, false},
+		{"cut in the data", "abcd", [][]byte{bpsAction(bpsTargetRead, 4, "ab")}, false},
+	}
+	for _, tc := range tests {
+		got, err := applyBPS(bpsPatch(src, []byte(tc.dst), tc.actions...), src)
+		switch {
+		case !tc.ok && err == nil:
+			t.Errorf("%s: no error", tc.name)
+		case tc.ok && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.ok && string(got) != tc.dst:
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.dst)
+		}
+	}
+}
+
+func TestBPSChecksums(t *testing.T) {
+	src, dst := []byte("0123456789"), []byte("01234abc56789")
+	patch, _ := makeBPS(src, dst)
+	damaged := append([]byte(nil), patch...)
+	damaged[len("BPS1")+3] ^= 1
+
+	//a patch that makes something else, with a valid patch checksum
+	wrong := bpsPatch(src, dst, bpsAction(bpsTargetRead, 13, "0123456789abc"))
+
+	tests := []struct {
+		name       string
+		patch, src []byte
+		err        string
+	}{
+		{"damaged", damaged, src, "damaged"},
+		{"other source", patch, []byte("0123456780"), "another file"},
+		{"other source size", patch, []byte("012345678"), "another file"},
+		{"wrong result", wrong, src, "wrong checksum"},
+		{"cut short", patch[:12], src, "cut short"},
+	}
+	for _, tc := range tests {
+		_, err := applyBPS(tc.patch, tc.src)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	src := []byte("abcdefgh")
+	tests := []struct {
+		name  string
+		patch string
+		want  string //"" is an error
+	}{
+		{"no checksums", `{"format": "hexdunk-patch", "source_size": 8, "target_size": 9,
+			"records": [{"offset": 1, "size": 2, "data": "5859"}, {"offset": 8, "size": 0, "data": "7a"}]}`,
+			"aXYdefghz"},
+		{"checksums", `{"format": "hexdunk-patch", "source_size": 8, "source_crc32": "AEEF2A50",
+			"target_size": 4, "target_crc32": "ed82cd11", "records": [{"offset": 4, "size": 4, "data": ""}]}`,
+			"abcd"},
+		{"wrong format", `{"format": "other", "source_size": 8, "target_size": 8, "records": []}`, ""},
+		{"wrong size", `{"format": "hexdunk-patch", "source_size": 9, "target_size": 9, "records": []}`, ""},
+		{"wrong source", `{"format": "hexdunk-patch", "source_size": 8, "source_crc32": "00000000",
+			"target_size": 8, "records": []}`, ""},
+		{"wrong target", `{"format": "hexdunk-patch", "source_size": 8, "target_size": 8,
+			"target_crc32": "00000000", "records": []}`, ""},
+		{"unsorted", `{"format": "hexdunk-patch", "source_size": 8, "target_size": 8,
+			"records": [{"offset": 4, "size": 1, "data": "00"}, {"offset": 1, "size": 1, "data": "00"}]}`, ""},
+		{"past the end", `{"format": "hexdunk-patch", "source_size": 8, "target_size": 8,
+			"records": [{"offset": 7, "size": 2, "data": "0000"}]}`, ""},
+		{"bad hex", `{"format": "hexdunk-patch", "source_size": 8, "target_size": 8,
+			"records": [{"offset": 0, "size": 1, "data": "0g"}]}`, ""},
+		{"not json", `{"format"`, ""},
+	}
+	for _, tc := range tests {
+		got, err := applyJSONPatch([]byte(tc.patch), src)
+		switch {
+		case tc.want == "" && err == nil:
+			t.Errorf("%s: no error", tc.name)
+		case tc.want != "" && err != nil:
+			t.Errorf("%s: %v", tc.name, err)
+		case tc.want != "" && string(got) != tc.want:
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestDetectPatchFormat(t *testing.T) {
+	tests := []struct {
+		patch, format string
+	}{
+		{"PATCHEOF", "IPS"},
+		{"BPS1...", "BPS"},
+		{"\n  {\"format\": \"hexdunk-patch\"}", "JSON"},
+		{"UPS1", ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		f, err := detectPatchFormat([]byte(tc.patch))
+		switch {
+		case tc.format == "" && err == nil:
+			t.Errorf("%q: detected as %s", tc.patch, f.name)
+		case tc.format != "" && (err != nil || f.name != tc.format):
+			t.Errorf("%q: %v %v, want %s", tc.patch, f, err, tc.format)
+		}
+	}
+}
+
+//the hunks of diffBytes turn the source into the target, and only cover changes
+func TestDiffBytes(t *testing.T) {
+	for _, tc := range patchSamples() {
+		hunks := diffBytes(tc.src, tc.dst)
+		var out []byte
+		var a int64
+		for _, h := range hunks {
+			if h.aOff < a || h.aSize == 0 && h.bSize == 0 {
+				t.Fatalf("%s: bad hunk %+v in %+v", tc.name, h, hunks)
+			}
+			out = append(out, tc.src[a:h.aOff]...)
+			out = append(out, tc.dst[h.bOff:h.bOff+h.bSize]...)
+			a = h.aOff + h.aSize
+		}
+		out = append(out, tc.src[a:]...)
+		if !bytes.Equal(out, tc.dst) {
+			t.Errorf("%s: the hunks don't give the target", tc.name)
+		}
+		if bytes.Equal(tc.src, tc.dst) && len(hunks) > 0 {
+			t.Errorf("%s: %d hunks for equal data", tc.name, len(hunks))
+		}
+	}
+}
+
+//a patch is applied as 1 edit, only the changed ranges are replaced
+func TestApplyPatchToFile(t *testing.T) {
+	src, dst := []byte("0123456789abcdef"), []byte("01X3456789abcdefgh")
+	hf := &HexFile{buf: B.NewMem(append([]byte(nil), src...))}
+	hf.resetOriginal()
+	for _, f := range patchFormats {
+		patch, _ := f.make(src, dst)
+		if _, n, err := hf.applyPatch(patch); err != nil || n != 2 {
+			t.Errorf("%s: %d changes, %v", f.name, n, err)
+		}
+		if got := contents(hf.buf); !bytes.Equal(got, dst) {
+			t.Errorf("%s: %q, want %q", f.name, got, dst)
+		}
+		if len(hf.undo) != 1 {
+			t.Errorf("%s: %d undo steps, want 1", f.name, len(hf.undo))
+		}
+		hf.Undo()
+		if got := contents(hf.buf); !bytes.Equal(got, src) {
+			t.Errorf("%s: undo gives %q", f.name, got)
+		}
+		hf.undo, hf.redo = nil, nil
+	}
+	//a patch for another file is refused
+	patch, _ := makeBPS([]byte("other"), dst)
+	if _, _, err := hf.applyPatch(patch); err == nil {
+		t.Errorf("applied a patch for another file")
+	}
+}
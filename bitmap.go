@@ -0,0 +1,363 @@
+package main
+
+//the bitmap window: the bytes from the cursor shown as raw pixels, for reversing image
+//formats and framebuffer dumps. clicking on a pixel puts the cursor on its bytes.
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"sync"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+	B "github.com/snhmibby/filebuf"
+)
+
+//the biggest image shown
+const maxBitmapSize = 4096
+
+type palette [256][4]uint8
+
+type pixelFormat struct {
+	name    string
+	bits    int  //per pixel
+	palette bool //uses a palette
+	//the colour of the pixel that starts at p (at bit offset bit, for less than 8 bits)
+	pixel func(p []byte, bit int, pal *palette) [4]uint8
+}
+
+func gray(v uint8) [4]uint8 { return [4]uint8{v, v, v, 255} }
+
+//5 or 6 bits to 8
+func expand(v uint16, bits uint) uint8 {
+	return uint8(v<<(8-bits) | v>>(2*bits-8))
+}
+
+var pixelFormats = []pixelFormat{
+	{"1 bpp Mono", 1, false, func(p []byte, bit int, _ *palette) [4]uint8 {
+		return gray(uint8(int(p[0]>>uint(7-bit)&1) * 255))
+	}},
+	{"4 bpp Palette", 4, true, func(p []byte, bit int, pal *palette) [4]uint8 {
+		return pal[p[0]>>uint(4-bit)&0xF]
+	}},
+	{"8 bpp Grayscale", 8, false, func(p []byte, _ int, _ *palette) [4]uint8 { return gray(p[0]) }},
+	{"8 bpp Palette", 8, true, func(p []byte, _ int, pal *palette) [4]uint8 { return pal[p[0]] }},
+	{"16 bpp Grayscale (LE)", 16, false, func(p []byte, _ int, _ *palette) [4]uint8 { return gray(p[1]) }},
+	{"RGB565 (LE)", 16, false, func(p []byte, _ int, _ *palette) [4]uint8 {
+		v := uint16(p[0]) | uint16(p[1])<<8
+		return [4]uint8{expand(v>>11, 5), expand(v>>5&0x3F, 6), expand(v&0x1F, 5), 255}
+	}},
+	{"RGB555 (LE)", 16, false, func(p []byte, _ int, _ *palette) [4]uint8 {
+		v := uint16(p[0]) | uint16(p[1])<<8
+		return [4]uint8{expand(v>>10&0x1F, 5), expand(v>>5&0x1F, 5), expand(v&0x1F, 5), 255}
+	}},
+	{"24 bpp RGB", 24, false, func(p []byte, _ int, _ *palette) [4]uint8 { return [4]uint8{p[0], p[1], p[2], 255} }},
+	{"24 bpp BGR", 24, false, func(p []byte, _ int, _ *palette) [4]uint8 { return [4]uint8{p[2], p[1], p[0], 255} }},
+	{"32 bpp RGBA", 32, false, func(p []byte, _ int, _ *palette) [4]uint8 { return [4]uint8{p[0], p[1], p[2], p[3]} }},
+	{"32 bpp BGRA", 32, false, func(p []byte, _ int, _ *palette) [4]uint8 { return [4]uint8{p[2], p[1], p[0], p[3]} }},
+	{"32 bpp ARGB", 32, false, func(p []byte, _ int, _ *palette) [4]uint8 { return [4]uint8{p[1], p[2], p[3], p[0]} }},
+}
+
+const (
+	PaletteGray = iota
+	PaletteFileRGB
+	PaletteFileBGRA
+)
+
+var paletteSources = []string{"Grayscale", "File: RGB (768 bytes)", "File: BGRA (1024 bytes, BMP)"}
+
+var bitmapZooms = []string{"1x", "2x", "4x", "8x"}
+
+//a palette read from data (the bytes at the palette offset)
+func makePalette(source int, data []byte) *palette {
+	var pal palette
+	for i := range pal {
+		pal[i] = gray(uint8(i))
+	}
+	switch source {
+	case PaletteFileRGB:
+		for i := 0; i < 256 && 3*i+3 <= len(data); i++ {
+			pal[i] = [4]uint8{data[3*i], data[3*i+1], data[3*i+2], 255}
+		}
+	case PaletteFileBGRA:
+		for i := 0; i < 256 && 4*i+4 <= len(data); i++ {
+			pal[i] = [4]uint8{data[4*i+2], data[4*i+1], data[4*i], 255}
+		}
+	}
+	return &pal
+}
+
+//the input of the image, when it changes it is made again
+type bitmapKey struct {
+	name          string
+	version       int
+	start         int64
+	format        int32
+	width, height int32
+	stride        int64
+	palette       int32
+	paletteOff    int64
+	alpha         bool
+	bottomUp      bool
+}
+
+//bytes per row without padding
+func (k *bitmapKey) rowSize() int64 {
+	return (int64(k.width)*int64(pixelFormats[k.format].bits) + 7) / 8
+}
+
+//the pixels of data, pixels past the end of data are left transparent
+func bitmapImage(k bitmapKey, data []byte, pal *palette) *image.RGBA {
+	f := &pixelFormats[k.format]
+	img := image.NewRGBA(image.Rect(0, 0, int(k.width), int(k.height)))
+	for y := 0; y < int(k.height); y++ {
+		row := int64(y) * k.stride
+		if row >= int64(len(data)) {
+			break
+		}
+		iy := y
+		if k.bottomUp {
+			iy = int(k.height) - 1 - y
+		}
+		for x := 0; x < int(k.width); x++ {
+			bit := int64(x) * int64(f.bits)
+			off := row + bit/8
+			if off+int64(f.bits+7)/8 > int64(len(data)) {
+				break
+			}
+			c := f.pixel(data[off:], int(bit%8), pal)
+			if !k.alpha {
+				c[3] = 255
+			}
+			o := img.PixOffset(x, iy)
+			copy(img.Pix[o:o+4], c[:])
+		}
+	}
+	return img
+}
+
+type bitmapWindow struct {
+	open       bool
+	follow     bool  //the image starts at the cursor
+	start      int64 //when not following the cursor
+	offset     int32 //added to the start
+	format     int32
+	width      int32
+	height     int32
+	stride     int32 //bytes per row, 0 for no padding
+	palette    int32
+	paletteOff int32
+	alpha      bool
+	bottomUp   bool
+	zoom       int32 //index in bitmapZooms
+
+	//made in the background
+	mu      sync.Mutex
+	gen     int
+	key     bitmapKey
+	texture *G.Texture
+	shown   bitmapKey //input of texture
+}
+
+func (bw *bitmapWindow) Open() {
+	if !bw.open && bw.width == 0 {
+		bw.follow = true
+		bw.format = 7 //24 bpp RGB
+		bw.width, bw.height = 256, 256
+	}
+	bw.open = true
+}
+
+//the settings as a key, clamped to sane values
+func (bw *bitmapWindow) makeKey(tab *HexTab, hf *HexFile) bitmapKey {
+	clamp := func(v *int32, min, max int32) {
+		if *v < min {
+			*v = min
+		}
+		if *v > max {
+			*v = max
+		}
+	}
+	clamp(&bw.width, 1, maxBitmapSize)
+	clamp(&bw.height, 1, maxBitmapSize)
+	if bw.follow {
+		bw.start = tab.view.cursor
+	}
+	k := bitmapKey{
+		name:     hf.name,
+		version:  hf.version,
+		start:    bw.start + int64(bw.offset),
+		format:   bw.format,
+		width:    bw.width,
+		height:   bw.height,
+		alpha:    bw.alpha,
+		bottomUp: bw.bottomUp,
+	}
+	if k.start < 0 {
+		k.start = 0
+	}
+	if size := hf.buf.Size(); k.start > size {
+		k.start = size
+	}
+	if pixelFormats[bw.format].palette {
+		k.palette, k.paletteOff = bw.palette, int64(bw.paletteOff)
+	}
+	k.stride = int64(bw.stride)
+	if k.stride < k.rowSize() {
+		k.stride = k.rowSize()
+	}
+	return k
+}
+
+//(re)make the image if the settings or the file changed
+func (bw *bitmapWindow) update(hf *HexFile, k bitmapKey) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	if k == bw.key {
+		return
+	}
+	bw.key = k
+	bw.gen++
+	gen := bw.gen
+	size := k.stride * int64(k.height)
+	if rest := hf.buf.Size() - k.start; size > rest {
+		size = rest
+	}
+	data := B.NewMem(nil)
+	if size > 0 {
+		data = hf.buf.Copy(k.start, size)
+	}
+	var palBytes []byte
+	if k.palette != PaletteGray && k.paletteOff >= 0 && k.paletteOff < hf.buf.Size() {
+		palBytes = make([]byte, 1024)
+		hf.buf.Seek(k.paletteOff, io.SeekStart)
+		n, _ := io.ReadFull(hf.buf, palBytes)
+		palBytes = palBytes[:n]
+	}
+	go func() {
+		pixels := make([]byte, data.Size())
+		data.Seek(0, io.SeekStart)
+		io.ReadFull(data, pixels)
+		img := bitmapImage(k, pixels, makePalette(int(k.palette), palBytes))
+		G.NewTextureFromRgba(img, func(t *G.Texture) {
+			bw.mu.Lock()
+			if gen == bw.gen {
+				bw.texture = t
+				bw.shown = k
+			}
+			bw.mu.Unlock()
+			G.Update()
+		})
+	}()
+}
+
+//the image, clicking on a pixel puts the cursor on it
+func (bw *bitmapWindow) image(tab *HexTab, hf *HexFile, texture *G.Texture, k bitmapKey) {
+	zoom := 1 << uint(bw.zoom)
+	w, h := int(k.width)*zoom, int(k.height)*zoom
+	pos := G.GetCursorScreenPos()
+	canvas := G.GetCanvas()
+	canvas.AddRectFilled(pos, pos.Add(image.Pt(w, h)), graphBG, 0, 0)
+	canvas.AddImage(texture, pos, pos.Add(image.Pt(w, h)))
+
+	bits := int64(pixelFormats[k.format].bits)
+	//the pixel of the byte at addr
+	toXY := func(addr int64) (int, int, bool) {
+		rel := addr - k.start
+		if rel < 0 || rel >= k.stride*int64(k.height) {
+			return 0, 0, false
+		}
+		x, y := int(rel%k.stride*8/bits), int(rel/k.stride)
+		if k.bottomUp {
+			y = int(k.height) - 1 - y
+		}
+		return x, y, x < int(k.width)
+	}
+	if x, y, ok := toXY(tab.view.cursor); ok {
+		p := pos.Add(image.Pt(x*zoom, y*zoom))
+		canvas.AddRect(p.Sub(image.Pt(1, 1)), p.Add(image.Pt(zoom+1, zoom+1)), graphCursor, 0, 0, 1)
+	}
+
+	G.InvisibleButton().Size(float32(w), float32(h)).Build()
+	mouse := G.GetMousePos().Sub(pos)
+	x, y := mouse.X/zoom, mouse.Y/zoom
+	if mouse.X < 0 || mouse.Y < 0 || x >= int(k.width) || y >= int(k.height) {
+		return
+	}
+	if k.bottomUp {
+		y = int(k.height) - 1 - y
+	}
+	addr := k.start + int64(y)*k.stride + int64(x)*bits/8
+	if addr >= hf.buf.Size() {
+		return
+	}
+	if I.IsItemHovered() {
+		I.SetTooltip(fmt.Sprintf("%d,%d: %X", x, y, addr+hf.fw.base))
+	}
+	if I.IsItemClicked(int(G.MouseButtonLeft)) {
+		//the image stays where it is
+		bw.follow = false
+		bw.start = k.start - int64(bw.offset)
+		tab.setCursor(addr)
+		tab.view.SetSelection(addr, (bits+7)/8)
+	}
+}
+
+func drawBitmapWindow() {
+	bw := &HD.Bitmap
+	if !bw.open {
+		return
+	}
+	tab := ActiveTab()
+	hf := ActiveFile()
+	if tab == nil || hf == nil {
+		G.Window("Bitmap").IsOpen(&bw.open).Layout(G.Label("No file opened"))
+		return
+	}
+	k := bw.makeKey(tab, hf)
+	bw.update(hf, k)
+	bw.mu.Lock()
+	texture, shown := bw.texture, bw.shown
+	bw.mu.Unlock()
+
+	names := make([]string, len(pixelFormats))
+	for i, f := range pixelFormats {
+		names[i] = f.name
+	}
+	var paletteSettings G.Widget = G.Layout{}
+	if pixelFormats[bw.format].palette {
+		paletteSettings = G.Row(
+			G.Combo("Palette", paletteSources[bw.palette], paletteSources, &bw.palette).Size(220),
+			G.Condition(bw.palette != PaletteGray,
+				G.Layout{G.InputInt(&bw.paletteOff).Label("Palette Offset").Size(100)}, G.Layout{}),
+		)
+	}
+
+	G.Window("Bitmap").IsOpen(&bw.open).Pos(160, 160).Size(600, 550).Layout(
+		G.Row(
+			G.Combo("Format", names[bw.format], names, &bw.format).Size(180),
+			G.Combo("Zoom", bitmapZooms[bw.zoom], bitmapZooms, &bw.zoom).Size(60),
+		),
+		G.Row(
+			G.InputInt(&bw.width).Label("Width").Size(80),
+			G.InputInt(&bw.height).Label("Height").Size(80),
+			G.InputInt(&bw.stride).Label("Stride").Size(80),
+		),
+		G.Row(
+			G.InputInt(&bw.offset).Label("Offset").Size(80),
+			G.Checkbox("Follow Cursor", &bw.follow),
+			G.Checkbox("Bottom Up", &bw.bottomUp),
+			G.Checkbox("Alpha", &bw.alpha),
+		),
+		paletteSettings,
+		G.Labelf("Start %X, %d bytes per row", k.start+hf.fw.base, k.stride),
+		G.Child().Border(false).Flags(G.WindowFlagsHorizontalScrollbar).Layout(
+			G.Custom(func() {
+				if texture != nil && shown.name == hf.name {
+					bw.image(tab, hf, texture, shown)
+				}
+			}),
+		),
+	)
+}
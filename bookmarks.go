@@ -0,0 +1,228 @@
+package main
+
+//vi marks and named bookmarks: offsets in a file that follow its edits
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+)
+
+var (
+	bookmarkBG = color.RGBA{R: 0, G: 150, B: 200, A: 140}
+)
+
+type bookmark struct {
+	name    string
+	comment string
+	off     int64
+}
+
+type fileMarks struct {
+	marks     map[byte]int64 //vi marks 'a'-'z'
+	bookmarks []*bookmark    //sorted by offset
+}
+
+//keep offset a on the same byte after [off, off+oldSize) is replaced by newSize bytes
+func adjustOffset(a, off, oldSize, newSize int64) int64 {
+	switch {
+	case a < off:
+		return a
+	case a >= off+oldSize:
+		return a + newSize - oldSize
+	case a-off >= newSize:
+		//the byte is gone, point to the byte after the replacement
+		return off + newSize
+	}
+	return a
+}
+
+//a mark or bookmark as it was before an edit replaced its byte
+type markState struct {
+	mark     byte      //0 for a bookmark
+	bookmark *bookmark //nil for a mark
+	off      int64
+}
+
+//returns the marks and bookmarks on replaced bytes, undo puts them back with restore
+func (fm *fileMarks) adjust(off, oldSize, newSize int64) []markState {
+	var moved []markState
+	replaced := func(a int64) bool { return oldSize > 0 && a >= off && a <= off+oldSize }
+	for c, a := range fm.marks {
+		if replaced(a) {
+			moved = append(moved, markState{mark: c, off: a})
+		}
+		fm.marks[c] = adjustOffset(a, off, oldSize, newSize)
+	}
+	for _, b := range fm.bookmarks {
+		if replaced(b.off) {
+			moved = append(moved, markState{bookmark: b, off: b.off})
+		}
+		b.off = adjustOffset(b.off, off, oldSize, newSize)
+	}
+	return moved
+}
+
+func (fm *fileMarks) restore(moved []markState) {
+	for _, m := range moved {
+		if m.bookmark != nil {
+			m.bookmark.off = m.off
+		} else if _, ok := fm.marks[m.mark]; ok {
+			fm.marks[m.mark] = m.off
+		}
+	}
+	sort.SliceStable(fm.bookmarks, func(i, j int) bool { return fm.bookmarks[i].off < fm.bookmarks[j].off })
+}
+
+func (fm *fileMarks) setMark(c byte, off int64) {
+	if fm.marks == nil {
+		fm.marks = make(map[byte]int64)
+	}
+	fm.marks[c] = off
+}
+
+func (fm *fileMarks) mark(c byte) (int64, bool) {
+	off, ok := fm.marks[c]
+	return off, ok
+}
+
+func (fm *fileMarks) deleteMark(c byte) {
+	delete(fm.marks, c)
+}
+
+//the set vi marks, in alphabetical order
+func (fm *fileMarks) markNames() []byte {
+	var names []byte
+	for c := byte('a'); c <= 'z'; c++ {
+		if _, ok := fm.marks[c]; ok {
+			names = append(names, c)
+		}
+	}
+	return names
+}
+
+func (fm *fileMarks) addBookmark(name, comment string, off int64) *bookmark {
+	b := &bookmark{name: name, comment: comment, off: off}
+	fm.bookmarks = append(fm.bookmarks, b)
+	sort.SliceStable(fm.bookmarks, func(i, j int) bool { return fm.bookmarks[i].off < fm.bookmarks[j].off })
+	return b
+}
+
+func (fm *fileMarks) deleteBookmark(b *bookmark) {
+	for i := range fm.bookmarks {
+		if fm.bookmarks[i] == b {
+			fm.bookmarks = append(fm.bookmarks[:i], fm.bookmarks[i+1:]...)
+			return
+		}
+	}
+}
+
+//colour the bytes with a mark or bookmark
+func (fm *fileMarks) highlighter() highlighter {
+	set := make(map[int64]bool)
+	for _, a := range fm.marks {
+		set[a] = true
+	}
+	for _, b := range fm.bookmarks {
+		set[b.off] = true
+	}
+	return func(addr int64) (color.RGBA, bool) {
+		if set[addr] {
+			return bookmarkBG, true
+		}
+		return color.RGBA{}, false
+	}
+}
+
+/*
+ * Bookmarks window
+ */
+
+type bookmarksWindow struct {
+	open bool
+}
+
+func (bw *bookmarksWindow) jump(off int64) {
+	if tab := ActiveTab(); tab != nil {
+		tab.setCursor(off)
+		tab.view.SetSelection(0, 0)
+	}
+}
+
+//a full width text input in a table cell
+func cellInput(label string, text *string) {
+	I.PushItemWidth(-1)
+	I.InputText(label, text)
+	I.PopItemWidth()
+}
+
+func (bw *bookmarksWindow) markTable(hf *HexFile) {
+	fm := &hf.marks
+	flags := I.TableFlags_ScrollY | I.TableFlags_RowBg | I.TableFlags_Resizable
+	if I.BeginTable("BookmarksTable", 4, flags, I.ContentRegionAvail(), 0) {
+		defer I.EndTable()
+		I.TableSetupColumn("Name", 0, 0, 0)
+		I.TableSetupColumn("Offset", 0, 0, 0)
+		I.TableSetupColumn("Comment", 0, 0, 0)
+		I.TableSetupColumn("", 0, 0, 0)
+		I.TableSetupScrollFreeze(0, 1)
+		I.TableHeadersRow()
+
+		for _, c := range fm.markNames() {
+			off, _ := fm.mark(c)
+			I.TableNextRow(0, 0)
+			I.TableNextColumn()
+			if I.SelectableV(fmt.Sprintf("'%c##mark", c), false, selectFlags, I.Vec2{}) {
+				bw.jump(off)
+			}
+			I.TableNextColumn()
+			I.Text(fmt.Sprintf("%X", hf.fw.base+off))
+			I.TableNextColumn()
+			I.Text("vi mark")
+			I.TableNextColumn()
+			if I.SmallButton(fmt.Sprintf("Delete##markdel%c", c)) {
+				fm.deleteMark(c)
+			}
+		}
+
+		for i, b := range fm.bookmarks {
+			I.TableNextRow(0, 0)
+			I.TableNextColumn()
+			cellInput(fmt.Sprint("##bookmarkname", i), &b.name)
+			I.TableNextColumn()
+			if I.SelectableV(fmt.Sprintf("%X##bookmark%d", hf.fw.base+b.off, i), false, selectFlags, I.Vec2{}) {
+				bw.jump(b.off)
+			}
+			I.TableNextColumn()
+			cellInput(fmt.Sprint("##bookmarkcomment", i), &b.comment)
+			I.TableNextColumn()
+			if I.SmallButton(fmt.Sprint("Delete##bookmarkdel", i)) {
+				fm.deleteBookmark(b)
+			}
+		}
+	}
+}
+
+func drawBookmarksWindow() {
+	bw := &HD.Bookmarks
+	if !bw.open {
+		return
+	}
+	var layout G.Layout
+	hf := ActiveFile()
+	if hf == nil {
+		layout = G.Layout{G.Label("No file opened.")}
+	} else {
+		layout = G.Layout{
+			G.Row(
+				G.Button("Add at Cursor").OnClick(actionAddBookmark),
+				G.Label("vi marks: m<letter> sets, '<letter> jumps"),
+			),
+			G.Custom(func() { bw.markTable(hf) }),
+		}
+	}
+	G.Window("Bookmarks").IsOpen(&bw.open).Pos(620, 440).Size(400, 300).Layout(layout...)
+}
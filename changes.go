@@ -0,0 +1,265 @@
+package main
+
+//differences between an opened file and the original on disk
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"sort"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+	B "github.com/snhmibby/filebuf"
+)
+
+var (
+	changeShiftedBG = color.RGBA{R: 90, G: 90, B: 140, A: 60}
+)
+
+//equal bytes at the ends of a changed range are looked for up to this far, so that
+//typing the same byte again (or undoing by hand) isn't a change
+const changeTrimMax = 1 << 20
+
+//the edits of a file, as a diff with the on-disk original (a-side is the original).
+//every edit updates the hunks, see trackChange
+type fileChanges struct {
+	gen   int        //incremented when the original is reset
+	hunks []diffHunk //sorted, a new slice after every edit (so copies stay valid)
+}
+
+//the buffer is the file as it is on disk, i.e. after loading or saving it.
+//a copy of it reads the same bytes, so it is the original
+func (hf *HexFile) resetOriginal() {
+	hf.orig = hf.buf.Copy(0, hf.buf.Size())
+	hf.savedVersion = hf.version
+	hf.changes.gen++
+	hf.changes.hunks = nil
+}
+
+//every edit must call this, it keeps track of the changes made
+func (hf *HexFile) touch() {
+	hf.version++
+}
+
+//[off, off+oldSize) of the buffer was replaced by newSize bytes: merge it with the
+//hunks it overlaps or touches into 1 hunk
+func (hf *HexFile) trackChange(off, oldSize, newSize int64) {
+	hunks := hf.changes.hunks
+	i := sort.Search(len(hunks), func(i int) bool { return hunks[i].bOff+hunks[i].bSize >= off })
+	j := i
+	for j < len(hunks) && hunks[j].bOff <= off+oldSize {
+		j++
+	}
+	//outside of the hunks the addresses map 1 to 1
+	bStart, bEnd := off, off+oldSize
+	aStart, aEnd := mapAddr(hunks, bStart, 1), mapAddr(hunks, bEnd, 1)
+	if i < j && hunks[i].bOff <= bStart {
+		bStart, aStart = hunks[i].bOff, hunks[i].aOff
+	}
+	if i < j {
+		if last := hunks[j-1]; last.bOff+last.bSize >= bEnd {
+			bEnd, aEnd = last.bOff+last.bSize, last.aOff+last.aSize
+		}
+	}
+	delta := newSize - oldSize
+	h := hf.trimHunk(diffHunk{aOff: aStart, aSize: aEnd - aStart, bOff: bStart, bSize: bEnd - bStart + delta})
+
+	changed := make([]diffHunk, 0, len(hunks)+1)
+	changed = append(changed, hunks[:i]...)
+	if h.aSize > 0 || h.bSize > 0 {
+		changed = append(changed, h)
+	}
+	for _, k := range hunks[j:] {
+		k.bOff += delta
+		changed = append(changed, k)
+	}
+	hf.changes.hunks = changed
+}
+
+//remove the equal bytes (up to changeTrimMax) from the front and back of h
+func (hf *HexFile) trimHunk(h diffHunk) diffHunk {
+	n := h.aSize
+	if h.bSize < n {
+		n = h.bSize
+	}
+	if n > changeTrimMax {
+		n = changeTrimMax
+	}
+	if n == 0 {
+		return h
+	}
+	a, b := make([]byte, n), make([]byte, n)
+	read := func(buf *B.Buffer, p []byte, off int64) {
+		buf.Seek(off, io.SeekStart)
+		io.ReadFull(buf, p)
+	}
+	read(hf.orig, a, h.aOff)
+	read(hf.buf, b, h.bOff)
+	k := int64(0)
+	for k < n && a[k] == b[k] {
+		k++
+	}
+	h.aOff, h.aSize, h.bOff, h.bSize = h.aOff+k, h.aSize-k, h.bOff+k, h.bSize-k
+	if n = h.aSize; h.bSize < n {
+		n = h.bSize
+	}
+	if n > changeTrimMax {
+		n = changeTrimMax
+	}
+	if n == 0 {
+		return h
+	}
+	read(hf.orig, a[:n], h.aOff+h.aSize-n)
+	read(hf.buf, b[:n], h.bOff+h.bSize-n)
+	k = 0
+	for k < n && a[n-1-k] == b[n-1-k] {
+		k++
+	}
+	h.aSize, h.bSize = h.aSize-k, h.bSize-k
+	return h
+}
+
+//remove the hunks inside h, after its original bytes were put back
+func (fc *fileChanges) dropReverted(h diffHunk) {
+	var kept []diffHunk
+	for _, k := range fc.hunks {
+		inside := k.aOff >= h.aOff && k.aOff+k.aSize <= h.aOff+h.aSize &&
+			k.bOff >= h.bOff && k.bOff+k.bSize <= h.bOff+h.aSize
+		if !inside {
+			kept = append(kept, k)
+		}
+	}
+	fc.hunks = kept
+}
+
+//the list of changes
+func (hf *HexFile) Changes() []diffHunk {
+	return hf.changes.hunks
+}
+
+//colour inserted, overwritten and shifted bytes, and the place of deletions
+func (hf *HexFile) changeHighlighter() highlighter {
+	hunks := hf.Changes()
+	return func(addr int64) (color.RGBA, bool) {
+		if len(hunks) == 0 {
+			return color.RGBA{}, false
+		}
+		if i := findHunk(hunks, addr, 1); i >= 0 {
+			if hunks[i].aSize == 0 {
+				return diffInsertedBG, true
+			}
+			return diffChangedBG, true
+		}
+		orig := mapAddr(hunks, addr, 1)
+		if i := findHunk(hunks, orig-1, 0); i >= 0 && hunks[i].bSize == 0 {
+			//bytes were deleted in front of addr
+			return diffDeletedBG, true
+		}
+		if orig != addr {
+			return changeShiftedBG, true
+		}
+		return color.RGBA{}, false
+	}
+}
+
+//put back the original bytes of the changes (indices in hunks) as 1 undo-able edit
+func (hf *HexFile) Revert(hunks []diffHunk, which []int) error {
+	//go from back to front, so the offsets of earlier hunks stay valid
+	n := 0
+	for k := len(which) - 1; k >= 0; k-- {
+		h := hunks[which[k]]
+		orig := hf.orig.Copy(h.aOff, h.aSize)
+		if err := hf.Replace(h.bOff, h.bSize, orig); err != nil {
+			hf.groupUndo(n)
+			return err
+		}
+		//trimming the hunk only compares its ends
+		hf.changes.dropReverted(h)
+		n++
+	}
+	hf.groupUndo(n)
+	return nil
+}
+
+//indices of the hunks (on the current buffer side) overlapping [off, off+size)
+func hunksIn(hunks []diffHunk, off, size int64) []int {
+	var which []int
+	for i, h := range hunks {
+		//deletions have size 0, count them if they are inside the range
+		if h.bOff < off+size && h.bOff+h.bSize > off || h.bSize == 0 && h.bOff >= off && h.bOff < off+size {
+			which = append(which, i)
+		}
+	}
+	return which
+}
+
+type changesWindow struct {
+	open     bool
+	selected int
+}
+
+func (cw *changesWindow) changeTable(hf *HexFile, hunks []diffHunk) {
+	flags := I.TableFlags_ScrollY | I.TableFlags_RowBg | I.TableFlags_Resizable
+	if I.BeginTable("ChangesTable", 5, flags, I.ContentRegionAvail(), 0) {
+		defer I.EndTable()
+		I.TableSetupColumn("Offset", 0, 0, 0)
+		I.TableSetupColumn("Size", 0, 0, 0)
+		I.TableSetupColumn("Original", 0, 0, 0)
+		I.TableSetupColumn("Kind", 0, 0, 0)
+		I.TableSetupColumn("", 0, 0, 0)
+		I.TableSetupScrollFreeze(0, 1)
+		I.TableHeadersRow()
+
+		var clip I.ListClipper
+		clip.Begin(len(hunks))
+		defer clip.End()
+		for clip.Step() {
+			for i := clip.DisplayStart; i < clip.DisplayEnd; i++ {
+				h := hunks[i]
+				I.TableNextRow(0, 0)
+				I.TableNextColumn()
+				label := fmt.Sprintf("%X##change%d", h.bOff, i)
+				if I.SelectableV(label, i == cw.selected, 0, I.Vec2{}) {
+					cw.selected = i
+					if tab := ActiveTab(); tab != nil {
+						tab.setCursor(h.bOff)
+						tab.view.SetSelection(h.bOff, h.bSize)
+					}
+				}
+				I.TableNextColumn()
+				I.Text(fmt.Sprintf("%d", h.bSize))
+				I.TableNextColumn()
+				I.Text(fmt.Sprintf("%X (%d)", h.aOff, h.aSize))
+				I.TableNextColumn()
+				I.Text(hunkKind(h))
+				I.TableNextColumn()
+				if I.SmallButton(fmt.Sprintf("Revert##revert%d", i)) {
+					if err := hf.Revert(hunks, []int{i}); err != nil {
+						ErrorDialog("Revert", err.Error())
+					}
+				}
+			}
+		}
+	}
+}
+
+func drawChangesWindow() {
+	cw := &HD.Changes
+	if !cw.open {
+		return
+	}
+	var layout G.Layout
+	hf := ActiveFile()
+	if hf == nil {
+		layout = G.Layout{G.Label("No file opened.")}
+	} else {
+		hunks := hf.Changes()
+		status := diffSummary(hunks)
+		layout = G.Layout{
+			G.Label(status),
+			G.Custom(func() { cw.changeTable(hf, hunks) }),
+		}
+	}
+	G.Window("Changes").IsOpen(&cw.open).Pos(620, 30).Size(400, 400).Layout(layout...)
+}
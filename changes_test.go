@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+func contents(b *B.Buffer) []byte {
+	data, _ := bufBytes(b)
+	return data
+}
+
+//the original with the hunks applied must be the buffer, and the bytes outside of the
+//hunks must be unchanged
+func checkHunks(t *testing.T, hf *HexFile) {
+	t.Helper()
+	orig, buf := contents(hf.orig), contents(hf.buf)
+	var out []byte
+	var a int64
+	for i, h := range hf.changes.hunks {
+		if h.aSize == 0 && h.bSize == 0 || h.aOff < a || i > 0 && h.bOff <= hf.changes.hunks[i-1].bOff+hf.changes.hunks[i-1].bSize-1 {
+			t.Fatalf("bad hunk %d %+v in %+v", i, h, hf.changes.hunks)
+		}
+		out = append(out, orig[a:h.aOff]...)
+		out = append(out, buf[h.bOff:h.bOff+h.bSize]...)
+		a = h.aOff + h.aSize
+	}
+	out = append(out, orig[a:]...)
+	if !bytes.Equal(out, buf) {
+		t.Fatalf("hunks %+v don't give the buffer", hf.changes.hunks)
+	}
+}
+
+func TestTrackChanges(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	orig := make([]byte, 4096)
+	rng.Read(orig)
+	hf := &HexFile{buf: B.NewMem(append([]byte(nil), orig...))}
+	hf.resetOriginal()
+	for i := 0; i < 2000; i++ {
+		size := hf.buf.Size()
+		off := rng.Int63n(size + 1)
+		n := rng.Int63n(64)
+		if off+n > size {
+			n = size - off
+		}
+		data := make([]byte, rng.Intn(64))
+		switch rng.Intn(4) {
+		case 0:
+			rng.Read(data)
+		case 1:
+			//the same bytes again
+			hf.buf.Seek(off, io.SeekStart)
+			data = make([]byte, n)
+			io.ReadFull(hf.buf, data)
+		case 2:
+			data = nil
+		case 3:
+			hf.Undo()
+			checkHunks(t, hf)
+			continue
+		}
+		if err := hf.Replace(off, n, B.NewMem(data)); err != nil {
+			t.Fatal(err)
+		}
+		checkHunks(t, hf)
+	}
+	for len(hf.undo) > 0 {
+		hf.Undo()
+		checkHunks(t, hf)
+	}
+	if len(hf.changes.hunks) != 0 {
+		t.Errorf("all edits undone, but %d changes left", len(hf.changes.hunks))
+	}
+}
+
+func TestRevertChanges(t *testing.T) {
+	hf := &HexFile{buf: B.NewMem([]byte("0123456789abcdef"))}
+	hf.resetOriginal()
+	hf.Replace(2, 2, B.NewMem([]byte("xyz")))
+	hf.Replace(10, 0, B.NewMem([]byte("++")))
+	if n := len(hf.Changes()); n != 2 {
+		t.Fatalf("got %d changes, want 2: %+v", n, hf.Changes())
+	}
+	//a paste bigger than any look ahead: the bytes after it are still unchanged
+	big := make([]byte, 256<<10)
+	hf.Replace(0, 0, B.NewMem(big))
+	checkHunks(t, hf)
+	hunks := hf.Changes()
+	if err := hf.Revert(hunks, []int{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(contents(hf.buf)); got != "0123456789abcdef" || len(hf.Changes()) != 0 {
+		t.Errorf("after revert: %q, %+v", got, hf.Changes())
+	}
+	hf.Undo()
+	checkHunks(t, hf)
+	if len(hf.Changes()) != 3 {
+		t.Errorf("undo revert: %+v", hf.Changes())
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+//decoding and encoding the selection (base64, hex, compression...), in place or into a
+//new tab. the data is streamed through a temp file, so big blobs aren't kept in memory.
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	G "github.com/AllenDang/giu"
+	B "github.com/snhmibby/filebuf"
+)
+
+type codec struct {
+	name   string
+	decode func(r io.Reader) (io.Reader, error)
+	encode func(w io.Writer) io.WriteCloser //nil if it can only decode
+}
+
+var codecs = []codec{
+	{"Base64",
+		func(r io.Reader) (io.Reader, error) { return base64.NewDecoder(base64.StdEncoding, r), nil },
+		func(w io.Writer) io.WriteCloser { return base64.NewEncoder(base64.StdEncoding, w) }},
+	{"Hex Text",
+		func(r io.Reader) (io.Reader, error) { return hex.NewDecoder(spaceFilter{bufio.NewReader(r)}), nil },
+		func(w io.Writer) io.WriteCloser { return nopWriteCloser{hex.NewEncoder(w)} }},
+	{"URL (%XX)",
+		func(r io.Reader) (io.Reader, error) { return &urlDecoder{r: bufio.NewReader(r)}, nil },
+		func(w io.Writer) io.WriteCloser { return nopWriteCloser{urlEncoder{w}} }},
+	{"zlib",
+		func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+		func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }},
+	{"gzip",
+		func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+	{"Deflate (raw)",
+		func(r io.Reader) (io.Reader, error) { return flate.NewReader(r), nil },
+		func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression) //only fails on a bad level
+			return fw
+		}},
+	{"LZ4",
+		func(r io.Reader) (io.Reader, error) { return newLZ4Reader(r), nil },
+		func(w io.Writer) io.WriteCloser { return newLZ4Writer(w) }},
+	{"bzip2",
+		func(r io.Reader) (io.Reader, error) { return bzip2.NewReader(r), nil },
+		nil},
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+//hex text without the white space
+type spaceFilter struct {
+	r io.ByteReader
+}
+
+func (f spaceFilter) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c, err := f.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		switch c {
+		case ' ', '\t', '\n', '\r':
+		default:
+			p[n] = c
+			n++
+		}
+	}
+	return n, nil
+}
+
+//%XX escapes, other bytes are copied
+type urlDecoder struct {
+	r io.ByteReader
+}
+
+func (d *urlDecoder) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+		if c == '%' {
+			var x [2]byte
+			for i := range x {
+				if x[i], err = d.r.ReadByte(); err != nil {
+					return n, fmt.Errorf("url: %% at the end of the data")
+				}
+			}
+			if _, err := hex.Decode(x[:1], x[:]); err != nil {
+				return n, fmt.Errorf("url: bad escape %%%s", x[:])
+			}
+			c = x[0]
+		}
+		p[n] = c
+		n++
+	}
+	return n, nil
+}
+
+//escapes all bytes except the unreserved characters of URLs
+type urlEncoder struct {
+	w io.Writer
+}
+
+func (e urlEncoder) Write(p []byte) (int, error) {
+	out := make([]byte, 0, 3*len(p))
+	for _, c := range p {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			out = append(out, c)
+		default:
+			out = append(out, fmt.Sprintf("%%%02X", c)...)
+		}
+	}
+	if _, err := e.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//decode or encode data into a new temp file, returns its path
+func (c *codec) run(data *B.Buffer, encode bool, pattern string) (path string, err error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	data.Seek(0, io.SeekStart)
+	w := bufio.NewWriter(f)
+	if encode {
+		enc := c.encode(w)
+		if _, err = io.Copy(enc, data); err == nil {
+			err = enc.Close()
+		}
+	} else {
+		var dec io.Reader
+		if dec, err = c.decode(data); err == nil {
+			_, err = io.Copy(w, dec)
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	return f.Name(), err
+}
+
+//replace size bytes at off with the decoded (or encoded) bytes as 1 undo-able edit,
+//returns the new size
+func (hf *HexFile) Recode(off, size int64, c *codec, encode bool) (int64, error) {
+	path, err := c.run(hf.buf.Copy(off, size), encode, "hexdunk-recode*")
+	if err != nil {
+		return 0, err
+	}
+	//the buffer keeps the file open, so it can go (except on windows)
+	defer os.Remove(path)
+	b, err := B.OpenFile(path)
+	if err != nil {
+		return 0, err
+	}
+	if b.Size() == 0 {
+		b = B.NewMem(nil)
+	}
+	return b.Size(), hf.Replace(off, size, b)
+}
+
+//decode (or encode) size bytes at off and open the result in a new tab. It isn't a
+//file on disk (it has to be saved as one), its temp file goes when the tab is closed
+func (hf *HexFile) RecodeToTab(off, size int64, c *codec, encode bool) error {
+	what := "decoded"
+	if encode {
+		what = "encoded"
+	}
+	path, err := c.run(hf.buf.Copy(off, size), encode, "hexdunk-"+what+"*")
+	if err != nil {
+		return err
+	}
+	b, err := B.OpenFile(path)
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	if b.Size() == 0 {
+		b = B.NewMem(nil)
+	}
+	//the result of the last run replaces the previous one, unless that was edited
+	base := fmt.Sprintf("%s (%s %s", hf.name, what, c.name)
+	name := base + ")"
+	rf, ok := HD.Files[name]
+	for n := 2; ok && rf.version != rf.savedVersion; n++ {
+		name = fmt.Sprintf("%s %d)", base, n)
+		rf, ok = HD.Files[name]
+	}
+	if !ok {
+		rf = new(HexFile)
+		rf.name = name
+		rf.kind = KindSnapshot
+		HD.Files[name] = rf
+		OpenTab(rf)
+	}
+	rf.removeTemp()
+	rf.buf, rf.temp = b, []string{path}
+	rf.stats = memInfo{name: filepath.Base(name), size: b.Size()}
+	rf.undo, rf.redo = nil, nil
+	rf.touch()
+	rf.resetOriginal()
+	for _, tab := range HD.Tabs {
+		if tab.name == name {
+			rf.ClampAddr(&tab.view.cursor)
+			tab.view.SetSelection(0, 0)
+		}
+	}
+	return nil
+}
+
+/*
+ * the encode/decode dialog
+ */
+
+type codecDialog struct {
+	id   string
+	open bool
+
+	codec  int32
+	encode bool
+	newTab bool //open the result in a new tab instead of replacing the selection
+}
+
+func (d *codecDialog) Dispose() {}
+
+func (d *codecDialog) saveState() {
+	G.Context.SetState(d.id, d)
+}
+
+func (d *codecDialog) close() {
+	d.saveState()
+	G.CloseCurrentPopup()
+}
+
+func (d *codecDialog) run() {
+	tab := ActiveTab()
+	hf := ActiveFile()
+	if tab == nil || hf == nil {
+		d.close()
+		return
+	}
+	c := &codecs[d.codec]
+	encode := d.encode && c.encode != nil
+	title := fmt.Sprintf("Decode %s", c.name)
+	if encode {
+		title = fmt.Sprintf("Encode %s", c.name)
+	}
+	off, size := tab.view.Selection()
+	if d.newTab {
+		if err := hf.RecodeToTab(off, size, c, encode); err != nil {
+			ErrorDialog(title, err.Error())
+			return
+		}
+	} else {
+		if !hf.writable(title) {
+			return
+		}
+		n, err := hf.Recode(off, size, c, encode)
+		if err != nil {
+			ErrorDialog(title, err.Error())
+			return
+		}
+		tab.view.SetSelection(off, n)
+	}
+	d.close()
+}
+
+func getCodecDialog(id string) *codecDialog {
+	r := G.Context.GetState(id)
+	if r == nil {
+		d := &codecDialog{id: id}
+		d.saveState()
+		return d
+	}
+	return r.(*codecDialog)
+}
+
+func prepareCodecDialog(id string) G.Widget {
+	d := getCodecDialog(id)
+	return G.Custom(func() {
+		if d.open {
+			G.OpenPopup(id)
+			d.open = false
+		}
+		names := make([]string, len(codecs))
+		for i, c := range codecs {
+			names[i] = c.name
+		}
+		canEncode := codecs[d.codec].encode != nil
+		if !canEncode {
+			d.encode = false
+		}
+		hf := ActiveFile()
+		if hf != nil && hf.readOnly {
+			d.newTab = true
+		}
+		run := "Decode"
+		if d.encode {
+			run = "Encode"
+		}
+
+		G.SetNextWindowSizeV(350, 0, G.ConditionOnce)
+		G.PopupModal(id).Layout(
+			G.Combo("Format", names[d.codec], names, &d.codec),
+			G.Condition(canEncode,
+				G.Layout{G.Checkbox("Encode", &d.encode)},
+				G.Layout{G.Label("(decode only)")}),
+			G.Condition(hf != nil && !hf.readOnly,
+				G.Layout{G.Checkbox("Open in a New Tab", &d.newTab)},
+				G.Layout{G.Label("Opens in a new tab (read only file)")}),
+			G.Row(
+				G.Button("Cancel").OnClick(d.close),
+				G.Button(run).OnClick(d.run),
+			),
+			G.Custom(func() {
+				if G.IsKeyPressed(G.KeyEscape) {
+					d.close()
+				}
+			}),
+		).Build()
+	})
+}
+
+/*
+ *Public:
+ */
+
+func CodecDialog(id string) {
+	d := getCodecDialog(id)
+	d.open = true
+	d.saveState()
+}
+
+func PrepareCodecDialog(id string) G.Widget {
+	return prepareCodecDialog(id)
+}
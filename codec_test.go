@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+//decoding again replaces the result tab, but not one with edits
+func TestRecodeToTab(t *testing.T) {
+	files, tabs := HD.Files, HD.Tabs
+	t.Cleanup(func() {
+		for _, hf := range HD.Files {
+			hf.removeTemp()
+		}
+		HD.Files, HD.Tabs = files, tabs
+	})
+	HD.Files, HD.Tabs = make(map[string]*HexFile), nil
+	hf := &HexFile{name: "data", buf: B.NewMem([]byte("aGV4ZHVuaw=="))}
+	HD.Files[hf.name] = hf
+	c := &codecs[0] //Base64
+	size := hf.buf.Size()
+
+	if err := hf.RecodeToTab(0, size, c, false); err != nil {
+		t.Fatal(err)
+	}
+	const name = "data (decoded Base64)"
+	rf := HD.Files[name]
+	if rf == nil || string(contents(rf.buf)) != "hexdunk" {
+		t.Fatalf("no result tab: %v", HD.Tabs)
+	}
+	if err := hf.RecodeToTab(0, size, c, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(HD.Tabs) != 1 || HD.Files[name] != rf {
+		t.Errorf("the result tab wasn't reused: %v", HD.Tabs)
+	}
+
+	rf.Replace(0, 3, B.NewMem([]byte("HEX")))
+	if err := hf.RecodeToTab(0, size, c, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(contents(rf.buf)); got != "HEXdunk" {
+		t.Errorf("the edits were dropped: %q", got)
+	}
+	if rf2 := HD.Files["data (decoded Base64 2)"]; rf2 == nil || len(HD.Tabs) != 2 || string(contents(rf2.buf)) != "hexdunk" {
+		t.Errorf("no second tab: %v", HD.Tabs)
+	}
+}
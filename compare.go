@@ -0,0 +1,354 @@
+package main
+
+//compare window: 2 opened files side by side with their differences highlighted
+
+import (
+	"fmt"
+	"image/color"
+	"sort"
+	"sync"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+)
+
+var (
+	diffChangedBG  = color.RGBA{R: 200, G: 150, B: 0, A: 120}
+	diffInsertedBG = color.RGBA{R: 0, G: 180, B: 0, A: 120}
+	diffDeletedBG  = color.RGBA{R: 200, G: 0, B: 0, A: 120}
+)
+
+type compareWindow struct {
+	open bool
+
+	//the compared files (file-path) and a view on each of them
+	files    [2]string
+	views    [2]*ViewState
+	tops     [2]int64 //last seen topAddr, to notice scrolling
+	cursors  [2]int64 //last seen cursor, to notice clicks
+	selected [2]int32 //file selection combo boxes
+	current  int      //index of the current hunk for navigation
+
+	//the diff is computed in the background
+	mu       sync.Mutex
+	gen      int //generation of the running diff, to ignore canceled results
+	busy     bool
+	progress float32
+	hunks    []diffHunk
+}
+
+//names of all opened files, sorted
+func openFileNames() []string {
+	names := make([]string, 0, len(HD.Files))
+	for name := range HD.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//open the compare window, the first file is the active one
+func (c *compareWindow) Open() {
+	names := openFileNames()
+	if hf := ActiveFile(); hf != nil {
+		for i, n := range names {
+			if n == hf.name {
+				c.selected[0] = int32(i)
+				c.selected[1] = int32((i + 1) % len(names))
+			}
+		}
+	}
+	c.open = true
+}
+
+//compare 2 opened files
+func (c *compareWindow) compare(a, b string) {
+	for i, n := range openFileNames() {
+		if n == a {
+			c.selected[0] = int32(i)
+		}
+		if n == b {
+			c.selected[1] = int32(i)
+		}
+	}
+	c.open = true
+	c.start()
+}
+
+//start diffing the selected files in the background
+func (c *compareWindow) start() {
+	names := openFileNames()
+	for s := 0; s < 2; s++ {
+		if int(c.selected[s]) >= len(names) {
+			return
+		}
+		c.files[s] = names[c.selected[s]]
+		c.views[s] = new(ViewState)
+		c.tops[s], c.cursors[s] = 0, 0
+	}
+	a, b := HD.Files[c.files[0]], HD.Files[c.files[1]]
+
+	//diff copies, the originals are used by the gui
+	ca := a.buf.Copy(0, a.buf.Size())
+	cb := b.buf.Copy(0, b.buf.Size())
+
+	c.mu.Lock()
+	c.gen++
+	gen := c.gen
+	c.busy = true
+	c.progress = 0
+	c.hunks = nil
+	c.current = -1
+	c.mu.Unlock()
+
+	go func() {
+		var shown float32
+		hunks := diffBuffers(ca, cb, func(f float32) bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.progress = f
+			if f-shown > 0.01 {
+				shown = f
+				G.Update()
+			}
+			return gen == c.gen
+		})
+		c.mu.Lock()
+		if gen == c.gen {
+			c.hunks = hunks
+			c.busy = false
+		}
+		c.mu.Unlock()
+		G.Update()
+	}()
+}
+
+//cancel a running diff
+func (c *compareWindow) cancel() {
+	c.mu.Lock()
+	c.gen++
+	c.busy = false
+	c.mu.Unlock()
+}
+
+//compared files still open?
+func (c *compareWindow) valid() bool {
+	for s := 0; s < 2; s++ {
+		if _, ok := HD.Files[c.files[s]]; !ok || c.views[s] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+//jump both views to hunk i
+func (c *compareWindow) jump(hunks []diffHunk, i int) {
+	if i < 0 || i >= len(hunks) {
+		return
+	}
+	c.current = i
+	for s := 0; s < 2; s++ {
+		off, size := hunks[i].side(s)
+		v := c.views[s]
+		v.cursor = off
+		v.SetSelection(off, size)
+		v.ScrollTo(off)
+		c.cursors[s] = off
+	}
+}
+
+//next difference after the cursor in the first file
+func (c *compareWindow) next(hunks []diffHunk) {
+	cur := c.views[0].cursor
+	i := sort.Search(len(hunks), func(i int) bool { return hunks[i].aOff > cur })
+	//hunks with aSize 0 can share aOff with the cursor
+	if c.current >= 0 && c.current+1 < len(hunks) && hunks[c.current].aOff == cur {
+		i = c.current + 1
+	}
+	c.jump(hunks, i)
+}
+
+//previous difference before the cursor in the first file
+func (c *compareWindow) prev(hunks []diffHunk) {
+	cur := c.views[0].cursor
+	i := sort.Search(len(hunks), func(i int) bool { return hunks[i].aOff >= cur }) - 1
+	if c.current > 0 && c.current < len(hunks) && hunks[c.current].aOff == cur {
+		i = c.current - 1
+	}
+	c.jump(hunks, i)
+}
+
+//keep the views scrolled to the same (aligned) position and cursor
+func (c *compareWindow) syncViews(hunks []diffHunk) {
+	for s := 0; s < 2; s++ {
+		v, other := c.views[s], c.views[1-s]
+		if v.cursor != c.cursors[s] {
+			other.cursor = mapAddr(hunks, v.cursor, s)
+			c.cursors[s], c.cursors[1-s] = v.cursor, other.cursor
+		}
+		if v.topAddr != c.tops[s] {
+			top := mapAddr(hunks, v.topAddr, s)
+			other.ScrollTop(top)
+			c.tops[s] = v.topAddr
+			if other.bytesPerLine > 0 {
+				c.tops[1-s] = (top / other.bytesPerLine) * other.bytesPerLine
+			}
+			return
+		}
+	}
+}
+
+func diffSummary(hunks []diffHunk) string {
+	var changed, inserted, deleted int64
+	for _, h := range hunks {
+		switch {
+		case h.aSize == 0:
+			inserted += h.bSize
+		case h.bSize == 0:
+			deleted += h.aSize
+		default:
+			changed += h.aSize
+		}
+	}
+	return fmt.Sprintf("%d differences: %s changed, %s inserted, %s deleted",
+		len(hunks), mkSize(changed), mkSize(inserted), mkSize(deleted))
+}
+
+func hunkKind(h diffHunk) string {
+	switch {
+	case h.aSize == 0:
+		return "inserted"
+	case h.bSize == 0:
+		return "deleted"
+	}
+	return "changed"
+}
+
+//list of changed ranges
+func (c *compareWindow) hunkTable(hunks []diffHunk) {
+	flags := I.TableFlags_ScrollY | I.TableFlags_RowBg | I.TableFlags_Resizable
+	if I.BeginTable("DiffTable", 4, flags, I.ContentRegionAvail(), 0) {
+		defer I.EndTable()
+		I.TableSetupColumn("A", 0, 0, 0)
+		I.TableSetupColumn("B", 0, 0, 0)
+		I.TableSetupColumn("Size", 0, 0, 0)
+		I.TableSetupColumn("Kind", 0, 0, 0)
+		I.TableSetupScrollFreeze(0, 1)
+		I.TableHeadersRow()
+
+		var clip I.ListClipper
+		clip.Begin(len(hunks))
+		defer clip.End()
+		for clip.Step() {
+			for i := clip.DisplayStart; i < clip.DisplayEnd; i++ {
+				h := hunks[i]
+				I.TableNextRow(0, 0)
+				I.TableNextColumn()
+				label := fmt.Sprintf("%X##hunk%d", h.aOff, i)
+				if I.SelectableV(label, i == c.current, selectFlags, I.Vec2{}) {
+					c.jump(hunks, i)
+				}
+				I.TableNextColumn()
+				I.Text(fmt.Sprintf("%X", h.bOff))
+				I.TableNextColumn()
+				I.Text(fmt.Sprintf("%d/%d", h.aSize, h.bSize))
+				I.TableNextColumn()
+				I.Text(hunkKind(h))
+			}
+		}
+	}
+}
+
+//highlight the hunks of one side
+func diffHighlighter(hunks []diffHunk, side int) highlighter {
+	return func(addr int64) (color.RGBA, bool) {
+		i := findHunk(hunks, addr, side)
+		if i < 0 {
+			return color.RGBA{}, false
+		}
+		switch {
+		case hunks[i].aSize == 0:
+			return diffInsertedBG, true
+		case hunks[i].bSize == 0:
+			return diffDeletedBG, true
+		}
+		return diffChangedBG, true
+	}
+}
+
+func (c *compareWindow) hexViews(hunks []diffHunk) G.Widget {
+	view := func(s int) G.Widget {
+		return G.Custom(func() {
+			hf := HD.Files[c.files[s]]
+			I.Text(hf.name)
+			id := fmt.Sprint("compare", s, ".hexview##", hf.name)
+			HexView(id, hf.buf, c.views[s]).ViewOnly().Highlight(diffHighlighter(hunks, s)).Build()
+		})
+	}
+	return G.Custom(func() {
+		w, _ := G.GetAvailableRegion()
+		G.SplitLayout(G.DirectionHorizontal, true, w/2, view(0), view(1)).Build()
+		c.syncViews(hunks)
+	})
+}
+
+func (c *compareWindow) fileCombo(s int) G.Widget {
+	names := openFileNames()
+	preview := ""
+	if int(c.selected[s]) < len(names) {
+		preview = names[c.selected[s]]
+	}
+	return G.Combo(fmt.Sprint("##comparefile", s), preview, names, &c.selected[s]).Size(250)
+}
+
+func drawCompareWindow() {
+	c := &HD.Compare
+	if !c.open {
+		c.mu.Lock()
+		busy := c.busy
+		c.mu.Unlock()
+		if busy {
+			c.cancel()
+		}
+		return
+	}
+
+	c.mu.Lock()
+	busy, progress, hunks := c.busy, c.progress, c.hunks
+	c.mu.Unlock()
+
+	var status G.Widget
+	switch {
+	case busy:
+		status = G.Row(
+			G.ProgressBar(progress).Size(200, 0),
+			G.Button("Cancel").OnClick(c.cancel),
+		)
+	case c.valid():
+		status = G.Row(
+			G.Button("< Prev").OnClick(func() { c.prev(hunks) }),
+			G.Button("Next >").OnClick(func() { c.next(hunks) }),
+			G.Label(diffSummary(hunks)),
+		)
+	default:
+		status = G.Label("Select 2 files to compare.")
+	}
+
+	var body G.Widget = G.Layout{}
+	if !busy && c.valid() {
+		body = G.SplitLayout(G.DirectionHorizontal, true, 250,
+			G.Custom(func() { c.hunkTable(hunks) }),
+			c.hexViews(hunks),
+		)
+	}
+
+	G.Window("Compare").IsOpen(&c.open).Pos(40, 60).Size(1000, 600).Layout(
+		G.Row(
+			c.fileCombo(0),
+			c.fileCombo(1),
+			G.Button("Compare").OnClick(c.start),
+		),
+		status,
+		body,
+	)
+}
@@ -0,0 +1,202 @@
+package main
+
+//special files: block devices are edited in place, streams (pipes, character devices,
+//files in /proc and /sys) are read once.
+//block devices are read when needed, like regular files. streams can't be read twice,
+//they are loaded in memory up to maxStreamSize bytes.
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+type fileKind int
+
+const (
+	KindRegular     fileKind = iota
+	KindBlockDevice          //fixed size, saved in place
+	KindStream               //read until EOF, can't be saved in place
+	KindProcess              //a memory region of a process, see process.go
+	KindSnapshot             //a copy of a file as it is on disk (see watch.go) or decoded data
+)
+
+const maxStreamSize = 64 << 20 //endless ones like /dev/zero are cut off here
+
+//fs.FileInfo for buffers that aren't a file (process memory, snapshots)
+type memInfo struct {
+	name string
+	size int64
+}
+
+func (mi memInfo) Name() string       { return mi.name }
+func (mi memInfo) Size() int64        { return mi.size }
+func (mi memInfo) Mode() fs.FileMode  { return 0600 }
+func (mi memInfo) ModTime() time.Time { return time.Now() }
+func (mi memInfo) IsDir() bool        { return false }
+func (mi memInfo) Sys() interface{}   { return nil }
+
+//the file is on disk under its name: it has a project file and is kept in the session
+func (hf *HexFile) onDisk() bool {
+	return hf.kind != KindProcess && hf.kind != KindSnapshot
+}
+
+//files in pseudo file systems say they are empty, but they have contents when read
+func isPseudoFile(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(abs, "/proc/") || strings.HasPrefix(abs, "/sys/")
+}
+
+func fileKindOf(path string, stats fs.FileInfo) (fileKind, error) {
+	m := stats.Mode()
+	switch {
+	case m.IsDir():
+		return KindRegular, fmt.Errorf("%s is a directory", path)
+	case m.IsRegular() && stats.Size() == 0 && isPseudoFile(path):
+		return KindStream, nil
+	case m.IsRegular():
+		return KindRegular, nil
+	case m&fs.ModeDevice != 0 && m&fs.ModeCharDevice == 0:
+		return KindBlockDevice, nil
+	case m&(fs.ModeCharDevice|fs.ModeNamedPipe) != 0:
+		return KindStream, nil
+	}
+	return KindRegular, fmt.Errorf("%s: can't open a file of type %v", path, m.Type())
+}
+
+//open a block device or load a stream in memory. The buffer on a device reads from
+//dev, it must be closed with the file. truncated is set if a stream was longer than
+//maxStreamSize
+func loadSpecial(path string, kind fileKind) (buf *B.Buffer, dev *os.File, truncated bool, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if kind == KindBlockDevice {
+		//stat says a device is empty, but it can seek to its end
+		size, err := f.Seek(0, io.SeekEnd)
+		if err != nil {
+			f.Close()
+			return nil, nil, false, err
+		}
+		return B.OpenReaderAt(f, size), f, false, nil
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxStreamSize+1))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if len(data) > maxStreamSize {
+		data, truncated = data[:maxStreamSize], true
+	}
+	return B.NewMem(data), nil, truncated, nil
+}
+
+//write the changed sectors of a block device in place
+func writeDevice(hf *HexFile) error {
+	f, err := os.OpenFile(hf.name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := writeChanged(hf, f, 0, sectorSize(f)); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+//the ranges of the buffer that can differ from the original at the same offset: the
+//changes, and the bytes between them that an insert or delete before them moved
+func movedRanges(hunks []diffHunk, size int64) [][2]int64 {
+	var ranges [][2]int64
+	add := func(s, e int64) {
+		n := len(ranges)
+		switch {
+		case e <= s:
+		case n > 0 && ranges[n-1][1] >= s:
+			ranges[n-1][1] = e
+		default:
+			ranges = append(ranges, [2]int64{s, e})
+		}
+	}
+	var end, shift int64 //of the last hunk
+	for _, h := range hunks {
+		if shift != 0 {
+			add(end, h.bOff)
+		}
+		add(h.bOff, h.bOff+h.bSize)
+		end = h.bOff + h.bSize
+		shift = end - (h.aOff + h.aSize)
+	}
+	if shift != 0 {
+		add(end, size)
+	}
+	return ranges
+}
+
+//write the changed bytes of hf at base in f, in blocks of align bytes. Only the
+//changes (and what they moved) are read, the size of hf can't change.
+func writeChanged(hf *HexFile, f io.WriterAt, base, align int64) error {
+	size := hf.buf.Size()
+	if size != hf.orig.Size() {
+		return fmt.Errorf("the size can't change (it is %d bytes, was %d)", size, hf.orig.Size())
+	}
+	chunk := align * 2048
+	cur := make([]byte, chunk)
+	old := make([]byte, chunk)
+	var done int64 //written up to here
+	for _, r := range movedRanges(hf.Changes(), size) {
+		start, end := r[0]/align*align, (r[1]+align-1)/align*align
+		if start < done {
+			start = done
+		}
+		if end > size {
+			end = size
+		}
+		for off := start; off < end; off += chunk {
+			n := chunk
+			if end-off < n {
+				n = end - off
+			}
+			hf.buf.Seek(off, io.SeekStart)
+			if _, err := io.ReadFull(hf.buf, cur[:n]); err != nil {
+				return err
+			}
+			hf.orig.Seek(off, io.SeekStart)
+			if _, err := io.ReadFull(hf.orig, old[:n]); err != nil {
+				return err
+			}
+			for i := int64(0); i < n; {
+				if cur[i] == old[i] {
+					i++
+					continue
+				}
+				j := i
+				for j < n && cur[j] != old[j] {
+					j++
+				}
+				s, e := i/align*align, (j+align-1)/align*align
+				if e > n {
+					e = n
+				}
+				if _, err := f.WriteAt(cur[s:e], base+off+s); err != nil {
+					return err
+				}
+				i = e
+			}
+		}
+		done = end
+	}
+	return nil
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const blkSSZGet = 0x1268 //ioctl: logical sector size of a block device
+
+//the logical sector size of a block device, 512 if it can't be found
+func sectorSize(f *os.File) int64 {
+	var size int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkSSZGet, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 || size <= 0 {
+		return 512
+	}
+	return int64(size)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+//the sector size of a block device
+func sectorSize(f *os.File) int64 {
+	return 512
+}
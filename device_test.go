@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+func TestMovedRanges(t *testing.T) {
+	tests := []struct {
+		name  string
+		hunks []diffHunk
+		want  [][2]int64
+	}{
+		{"overwrite", []diffHunk{{aOff: 10, aSize: 2, bOff: 10, bSize: 2}}, [][2]int64{{10, 12}}},
+		{"insert, delete", []diffHunk{{aOff: 10, bOff: 10, bSize: 2}, {aOff: 50, aSize: 2, bOff: 52}},
+			[][2]int64{{10, 52}}},
+		{"insert at the end", []diffHunk{{aOff: 95, aSize: 5, bOff: 95, bSize: 4}, {aOff: 100, bOff: 99, bSize: 1}},
+			[][2]int64{{95, 100}}},
+		{"apart", []diffHunk{{aOff: 1, aSize: 1, bOff: 1, bSize: 1}, {aOff: 60, aSize: 3, bOff: 60, bSize: 3}},
+			[][2]int64{{1, 2}, {60, 63}}},
+	}
+	for _, tc := range tests {
+		if got := movedRanges(tc.hunks, 100); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+//records the writes to it on a copy of the original
+type writeRecorder struct {
+	data    []byte
+	written int64
+}
+
+func (w *writeRecorder) WriteAt(p []byte, off int64) (int, error) {
+	copy(w.data[off:], p)
+	w.written += int64(len(p))
+	return len(p), nil
+}
+
+func TestWriteChanged(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	orig := make([]byte, 1<<20)
+	rng.Read(orig)
+	hf := &HexFile{buf: B.NewMem(append([]byte(nil), orig...))}
+	hf.resetOriginal()
+
+	hf.Replace(5000, 1, B.NewMem([]byte{^orig[5000]}))
+	//moves the bytes between them by 1
+	hf.Replace(100000, 0, B.NewMem([]byte{1}))
+	hf.Replace(100600, 1, B.NewMem(nil))
+	w := &writeRecorder{data: append([]byte(nil), orig...)}
+	if err := writeChanged(hf, w, 0, 512); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.data, contents(hf.buf)) {
+		t.Errorf("written data isn't the buffer")
+	}
+	if w.written > 3*512 {
+		t.Errorf("wrote %d bytes", w.written)
+	}
+
+	hf.Replace(0, 0, B.NewMem([]byte{1}))
+	if err := writeChanged(hf, w, 0, 512); err == nil {
+		t.Errorf("the size changed without an error")
+	}
+}
@@ -27,6 +27,8 @@ type fileDialog struct {
 	statCache       map[string]fs.FileInfo
 	dirCache        map[string][]fs.FileInfo
 	showHiddenFiles bool
+	readOnlyOption  bool //show a read-only checkbox (open dialog)
+	readOnly        bool
 	selectedFile    string //full path of file selected in fileTable
 	currentDir      string //full path of directory selected in dirTree
 	startDir        string //starting directory arg or cwd()
@@ -223,8 +225,10 @@ func (fd *fileDialog) selectFile() {
 	I.CloseCurrentPopup()
 	fd.statCache = make(map[string]fs.FileInfo)
 	fd.dirCache = make(map[string][]fs.FileInfo)
-	fd.startDir, _ = filepath.Abs(".")
+	//start in the same directory next time
+	fd.startDir = filepath.Dir(file)
 	fd.currentDir = fd.startDir
+	HD.DialogDirs[fd.id] = fd.startDir
 	fd.selectedFile = ""
 	fd.saveState()
 }
@@ -247,6 +251,9 @@ func prepareFileDialog(id string, cb func(string)) G.Widget {
 	dialogRaw := G.Context.GetState(id)
 	if dialogRaw == nil {
 		start, _ := filepath.Abs(".")
+		if dir, ok := HD.DialogDirs[id]; ok {
+			start = dir
+		}
 		fd = &fileDialog{
 			id:         id,
 			statCache:  make(map[string]fs.FileInfo),
@@ -286,6 +293,7 @@ func prepareFileDialog(id string, cb func(string)) G.Widget {
 			}),
 			G.Row(
 				G.Checkbox("Show Hidden", &fd.showHiddenFiles),
+				G.Condition(fd.readOnlyOption, G.Layout{G.Checkbox("Read Only", &fd.readOnly)}, nil),
 				G.Button("Cancel").OnClick(fd.close),
 				G.Button(id).OnClick(fd.selectFile),
 			),
@@ -388,6 +396,15 @@ func ErrorDialog(title, msg string) {
 	G.Msgbox("ERROR!", "When: "+title+"\n\nError: "+msg).Buttons(G.MsgboxButtonsOk)
 }
 
+//yes is called if the answer is yes
+func YesNoDialog(title, msg string, yes func()) {
+	G.Msgbox(title, msg).Buttons(G.MsgboxButtonsYesNo).ResultCallback(func(r G.DialogResult) {
+		if r == G.DialogResultYes {
+			yes()
+		}
+	})
+}
+
 func FileDialog(id string) {
 	fdRaw := G.Context.GetState(id)
 	if fdRaw == nil {
@@ -402,6 +419,15 @@ func PrepareFileDialog(id string, cb func(string)) G.Widget {
 	return prepareFileDialog(id, cb)
 }
 
+//a file dialog with a read-only option, for opening files
+func PrepareOpenFileDialog(id string, cb func(path string, readOnly bool)) G.Widget {
+	var fd *fileDialog
+	w := prepareFileDialog(id, func(p string) { cb(p, fd.readOnly) })
+	fd = G.Context.GetState(id).(*fileDialog)
+	fd.readOnlyOption = true
+	return w
+}
+
 func IntDialog(id string) {
 	r := G.Context.GetState(id)
 	if r == nil {
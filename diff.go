@@ -0,0 +1,224 @@
+package main
+
+//binary diff: align two buffers, allowing for inserted and deleted runs of bytes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+//a diffHunk is a range in file a that is replaced by a range in file b
+//aSize == 0 means bytes were inserted, bSize == 0 means bytes were deleted
+type diffHunk struct {
+	aOff, aSize int64
+	bOff, bSize int64
+}
+
+const (
+	diffBlock   = 64 * 1024 //bytes compared per step in the equal-run scan
+	diffGram    = 8         //a resync needs at least this many equal bytes
+	diffMaxSkip = 64 * 1024 //max distance to look ahead for a resync
+)
+
+//bufReaderAt makes a Buffer an io.ReaderAt
+//only use it on a buffer that isn't touched by anyone else (i.e. a copy)
+type bufReaderAt struct {
+	buf *B.Buffer
+}
+
+func (r bufReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.buf.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r.buf, p)
+}
+
+//diffSource reads pieces of one side of the diff
+type diffSource struct {
+	r    io.ReaderAt
+	size int64
+	tmp  []byte
+}
+
+//read up to n bytes at off, the slice is only valid until the next read
+func (s *diffSource) read(off int64, n int64) []byte {
+	if off+n > s.size {
+		n = s.size - off
+	}
+	if n <= 0 {
+		return nil
+	}
+	if int64(cap(s.tmp)) < n {
+		s.tmp = make([]byte, n)
+	}
+	b := s.tmp[:n]
+	k, _ := s.r.ReadAt(b, off)
+	return b[:k]
+}
+
+func firstDiff(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	if bytes.Equal(a[:n], b[:n]) {
+		return n
+	}
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
+
+//find the closest point after a mismatch where both sides are equal again.
+//returns the number of bytes to skip in a and in b
+func resync(a, b []byte) (int64, int64, bool) {
+	//index all grams of b by their value, keep only the first position
+	grams := make(map[uint64]int, len(b))
+	for j := 0; j+diffGram <= len(b); j++ {
+		g := binary.LittleEndian.Uint64(b[j:])
+		if _, ok := grams[g]; !ok {
+			grams[g] = j
+		}
+	}
+	best, bx, by := -1, 0, 0
+	for x := 0; x+diffGram <= len(a); x++ {
+		if best >= 0 && x >= best {
+			break //can't find a closer one
+		}
+		y, ok := grams[binary.LittleEndian.Uint64(a[x:])]
+		if ok && (best < 0 || x+y < best) {
+			best, bx, by = x+y, x, y
+		}
+	}
+	return int64(bx), int64(by), best > 0
+}
+
+//compute the differences between a and b.
+//progress is called now and then with the fraction of work done.
+//returning false from progress cancels the diff (it returns nil)
+func diffReaders(a, b io.ReaderAt, aSize, bSize int64, progress func(float32) bool) []diffHunk {
+	sa := &diffSource{r: a, size: aSize}
+	sb := &diffSource{r: b, size: bSize}
+	var hunks []diffHunk
+	addHunk := func(h diffHunk) {
+		//merge with the previous hunk if they touch
+		if n := len(hunks); n > 0 {
+			last := &hunks[n-1]
+			if last.aOff+last.aSize == h.aOff && last.bOff+last.bSize == h.bOff {
+				last.aSize += h.aSize
+				last.bSize += h.bSize
+				return
+			}
+		}
+		hunks = append(hunks, h)
+	}
+
+	var i, j int64
+	total := float32(aSize + bSize)
+	for i < aSize && j < bSize {
+		if progress != nil && !progress(float32(i+j)/total) {
+			return nil
+		}
+
+		//skip the equal run
+		pa := sa.read(i, diffBlock)
+		pb := sb.read(j, diffBlock)
+		k := int64(firstDiff(pa, pb))
+		i += k
+		j += k
+		if k == int64(len(pa)) && k == int64(len(pb)) {
+			continue
+		}
+		if i >= aSize || j >= bSize {
+			break
+		}
+
+		//mismatch, look in gradually bigger windows for a resync
+		var x, y int64
+		found := false
+		for win := int64(256); win <= diffMaxSkip && !found; win *= 16 {
+			wa := sa.read(i, win+diffGram)
+			wb := sb.read(j, win+diffGram)
+			x, y, found = resync(wa, wb)
+		}
+		if !found {
+			//big unrelated region, call it changed and try again after it
+			x, y = diffMaxSkip, diffMaxSkip
+			if i+x > aSize {
+				x = aSize - i
+			}
+			if j+y > bSize {
+				y = bSize - j
+			}
+		}
+		addHunk(diffHunk{aOff: i, aSize: x, bOff: j, bSize: y})
+		i += x
+		j += y
+	}
+	if i < aSize || j < bSize {
+		addHunk(diffHunk{aOff: i, aSize: aSize - i, bOff: j, bSize: bSize - j})
+	}
+	return hunks
+}
+
+//diff 2 buffers, this is safe to run in the background (it works on copies)
+//the copies must be made by the caller in the gui thread with buf.Copy()
+func diffBuffers(a, b *B.Buffer, progress func(float32) bool) []diffHunk {
+	return diffReaders(bufReaderAt{a}, bufReaderAt{b}, a.Size(), b.Size(), progress)
+}
+
+/* helpers to look up things in a list of hunks (sorted by offset) */
+
+//index of the hunk with range [off, off+size) containing addr, or -1
+func findHunk(hunks []diffHunk, addr int64, side int) int {
+	i := sort.Search(len(hunks), func(i int) bool {
+		off, size := hunks[i].side(side)
+		return off+size > addr
+	})
+	if i < len(hunks) {
+		off, size := hunks[i].side(side)
+		if addr >= off && addr < off+size {
+			return i
+		}
+	}
+	return -1
+}
+
+//return the hunk range for side 0 (a) or 1 (b)
+func (h diffHunk) side(side int) (int64, int64) {
+	if side == 0 {
+		return h.aOff, h.aSize
+	}
+	return h.bOff, h.bSize
+}
+
+//map an address in one side to the corresponding address in the other side
+func mapAddr(hunks []diffHunk, addr int64, side int) int64 {
+	//last hunk that starts at or before addr
+	i := sort.Search(len(hunks), func(i int) bool {
+		off, _ := hunks[i].side(side)
+		return off > addr
+	}) - 1
+	if i < 0 {
+		return addr
+	}
+	h := hunks[i]
+	off, size := h.side(side)
+	other, otherSize := h.side(1 - side)
+	if addr < off+size {
+		//inside the hunk, clamp to the other side of the hunk
+		d := addr - off
+		if d >= otherSize {
+			d = otherSize
+		}
+		return other + d
+	}
+	return other + otherSize + (addr - off - size)
+}
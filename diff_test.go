@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+//the hunks turn a into b
+func applyHunks(a, b []byte, hunks []diffHunk) []byte {
+	var out []byte
+	var at int64
+	for _, h := range hunks {
+		out = append(out, a[at:h.aOff]...)
+		out = append(out, b[h.bOff:h.bOff+h.bSize]...)
+		at = h.aOff + h.aSize
+	}
+	return append(out, a[at:]...)
+}
+
+func TestDiffBuffers(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		p := make([]byte, n)
+		rng.Read(p)
+		return p
+	}
+	a := random(300 << 10)
+	edit := func(edits ...func([]byte) []byte) []byte {
+		b := append([]byte(nil), a...)
+		for _, e := range edits {
+			b = e(b)
+		}
+		return b
+	}
+	insert := func(off int, data []byte) func([]byte) []byte {
+		return func(b []byte) []byte { return append(b[:off], append(data, b[off:]...)...) }
+	}
+	remove := func(off, n int) func([]byte) []byte {
+		return func(b []byte) []byte { return append(b[:off], b[off+n:]...) }
+	}
+	set := func(off int, data []byte) func([]byte) []byte {
+		return func(b []byte) []byte { copy(b[off:], data); return b }
+	}
+
+	tests := []struct {
+		name  string
+		b     []byte
+		hunks int //-1 is don't care
+	}{
+		{"equal", edit(), 0},
+		{"1 byte", edit(set(1000, []byte{^a[1000]})), 1},
+		{"across a block", edit(set(diffBlock-2, random(4))), 1},
+		{"insert", edit(insert(5000, random(10))), 1},
+		{"delete", edit(remove(200<<10, 1000)), 1},
+		{"far apart", edit(set(10, []byte{^a[10]}), insert(150<<10, random(100)), remove(250<<10, 3)), 3},
+		{"insert bigger than a skip", edit(insert(1000, random(diffMaxSkip*2))), -1},
+		{"grow at the end", edit(insert(len(a), random(10))), 1},
+		{"cut at the end", a[:100<<10], 1},
+		{"empty", nil, 1},
+	}
+	for _, tc := range tests {
+		hunks := diffBuffers(B.NewMem(a), B.NewMem(tc.b), nil)
+		if got := applyHunks(a, tc.b, hunks); !bytes.Equal(got, tc.b) {
+			t.Errorf("%s: the hunks don't give b", tc.name)
+		}
+		if tc.hunks >= 0 && len(hunks) != tc.hunks {
+			t.Errorf("%s: %d hunks, want %d: %+v", tc.name, len(hunks), tc.hunks, hunks)
+		}
+	}
+
+	//canceled
+	if hunks := diffBuffers(B.NewMem(a), B.NewMem(tests[1].b), func(float32) bool { return false }); hunks != nil {
+		t.Errorf("canceled diff gave %+v", hunks)
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+//entropy graph of the active file and a histogram of the byte values of the selection.
+//compressed and encrypted parts of a file have an entropy close to 8 bits per byte.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+	B "github.com/snhmibby/filebuf"
+)
+
+var entropyBlockSizes = []int64{256, 1024, 4096, 16384, 65536}
+var entropyBlockNames = []string{"256", "1K", "4K", "16K", "64K"}
+
+var (
+	graphBG     = color.RGBA{R: 30, G: 30, B: 40, A: 255}
+	graphView   = color.RGBA{R: 255, G: 255, B: 255, A: 120}
+	graphCursor = color.RGBA{R: 255, G: 100, B: 0, A: 255}
+	histogramFG = color.RGBA{R: 90, G: 140, B: 230, A: 255}
+)
+
+//Shannon entropy in bits per byte
+func entropy(counts *[256]int64, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	var h float64
+	for _, n := range counts {
+		if n > 0 {
+			p := float64(n) / float64(total)
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+//entropy of every block of b, progress is called for every chunk and can cancel
+func blockEntropy(b *B.Buffer, blockSize int64, progress func(done int64) bool) ([]float32, bool) {
+	var counts [256]int64
+	var n, done int64
+	result := make([]float32, 0, b.Size()/blockSize+1)
+	canceled := false
+	b.Iter(func(p []byte) bool {
+		for len(p) > 0 {
+			k := blockSize - n
+			if k > int64(len(p)) {
+				k = int64(len(p))
+			}
+			for _, c := range p[:k] {
+				counts[c]++
+			}
+			p = p[k:]
+			n += k
+			done += k
+			if n == blockSize {
+				result = append(result, float32(entropy(&counts, n)))
+				counts = [256]int64{}
+				n = 0
+			}
+		}
+		canceled = !progress(done)
+		return canceled
+	})
+	if n > 0 {
+		result = append(result, float32(entropy(&counts, n)))
+	}
+	return result, !canceled
+}
+
+//count the byte values of b
+func byteHistogram(b *B.Buffer, progress func(done int64) bool) (*[256]int64, bool) {
+	var counts [256]int64
+	var done int64
+	canceled := false
+	b.Iter(func(p []byte) bool {
+		for _, c := range p {
+			counts[c]++
+		}
+		done += int64(len(p))
+		canceled = !progress(done)
+		return canceled
+	})
+	return &counts, !canceled
+}
+
+//green for low entropy, red for (close to) random data
+func entropyColor(e float32) color.RGBA {
+	f := e / 8
+	if f > 1 {
+		f = 1
+	}
+	return color.RGBA{R: uint8(60 + 195*f), G: uint8(200 - 160*f), B: 60, A: 255}
+}
+
+/*
+ * Entropy window
+ */
+
+//the input of a computation, when it changes it is started again
+type entropyKey struct {
+	name      string
+	version   int
+	blockSize int64
+}
+
+type histogramKey struct {
+	name      string
+	version   int
+	off, size int64
+}
+
+type entropyWindow struct {
+	open      bool
+	blockSize int32 //index in entropyBlockSizes
+	logScale  bool  //histogram
+
+	//computed in the background
+	mu          sync.Mutex
+	entGen      int
+	entKey      entropyKey
+	entBusy     bool
+	entProgress float32
+	entropies   []float32
+	entShown    entropyKey //input of entropies
+	histGen     int
+	histKey     histogramKey
+	histBusy    bool
+	histogram   *[256]int64
+	histTotal   int64
+}
+
+func (ew *entropyWindow) Open() {
+	if !ew.open && ew.blockSize == 0 {
+		ew.blockSize = 2 //4K
+	}
+	ew.open = true
+}
+
+//(re)start the computations if the file, its contents or the selection changed
+func (ew *entropyWindow) update(tab *HexTab, hf *HexFile) {
+	ek := entropyKey{hf.name, hf.version, entropyBlockSizes[ew.blockSize]}
+	off, size := tab.view.Selection()
+	if size == 0 {
+		off, size = 0, hf.buf.Size()
+	}
+	hk := histogramKey{hf.name, hf.version, off, size}
+
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if ek != ew.entKey {
+		ew.entKey = ek
+		ew.entGen++
+		ew.entBusy = true
+		ew.entProgress = 0
+		gen, data, total := ew.entGen, hf.buf.Copy(0, hf.buf.Size()), hf.buf.Size()
+		go func() {
+			var shown float32
+			result, ok := blockEntropy(data, ek.blockSize, func(done int64) bool {
+				ew.mu.Lock()
+				defer ew.mu.Unlock()
+				if total > 0 {
+					ew.entProgress = float32(done) / float32(total)
+				}
+				if ew.entProgress-shown > 0.01 {
+					shown = ew.entProgress
+					G.Update()
+				}
+				return gen == ew.entGen
+			})
+			ew.mu.Lock()
+			if ok && gen == ew.entGen {
+				ew.entropies = result
+				ew.entShown = ek
+				ew.entBusy = false
+			}
+			ew.mu.Unlock()
+			G.Update()
+		}()
+	}
+	if hk != ew.histKey {
+		ew.histKey = hk
+		ew.histGen++
+		ew.histBusy = true
+		gen, data := ew.histGen, hf.buf.Copy(off, size)
+		go func() {
+			counts, ok := byteHistogram(data, func(int64) bool {
+				ew.mu.Lock()
+				defer ew.mu.Unlock()
+				return gen == ew.histGen
+			})
+			ew.mu.Lock()
+			if ok && gen == ew.histGen {
+				ew.histogram = counts
+				ew.histTotal = size
+				ew.histBusy = false
+			}
+			ew.mu.Unlock()
+			G.Update()
+		}()
+	}
+}
+
+//stop the computations, i.e. when the window is closed
+func (ew *entropyWindow) cancel() {
+	ew.mu.Lock()
+	ew.entGen++
+	ew.histGen++
+	ew.entKey = entropyKey{}
+	ew.histKey = histogramKey{}
+	ew.entBusy, ew.histBusy = false, false
+	ew.mu.Unlock()
+}
+
+//the entropy graph, clicking (or dragging) on it jumps to that part of the file
+func (ew *entropyWindow) graph(tab *HexTab, hf *HexFile, entropies []float32, blockSize int64) {
+	w, _ := G.GetAvailableRegion()
+	width, height := int(w), 120
+	if width < 16 || len(entropies) == 0 {
+		return
+	}
+	pos := G.GetCursorScreenPos()
+	canvas := G.GetCanvas()
+	canvas.AddRectFilled(pos, pos.Add(image.Pt(width, height)), graphBG, 0, 0)
+
+	//every pixel column shows the highest entropy of the blocks it covers
+	perX := float64(len(entropies)) / float64(width)
+	for x := 0; x < width; x++ {
+		from, to := int(float64(x)*perX), int(float64(x+1)*perX)
+		if to <= from {
+			to = from + 1
+		}
+		if from >= len(entropies) {
+			break
+		}
+		if to > len(entropies) {
+			to = len(entropies)
+		}
+		var e float32
+		for _, v := range entropies[from:to] {
+			if v > e {
+				e = v
+			}
+		}
+		h := int(e / 8 * float32(height))
+		canvas.AddLine(pos.Add(image.Pt(x, height)), pos.Add(image.Pt(x, height-h)), entropyColor(e), 1)
+	}
+
+	//the part of the file on screen and the cursor
+	size := float64(hf.buf.Size())
+	if size > 0 {
+		toX := func(addr int64) int { return int(float64(addr) / size * float64(width)) }
+		view := tab.view
+		x0 := toX(view.topAddr)
+		x1 := toX(view.topAddr + view.bytesPerLine*view.linesPerScreen)
+		if x1 <= x0 {
+			x1 = x0 + 1
+		}
+		canvas.AddRectFilled(pos.Add(image.Pt(x0, 0)), pos.Add(image.Pt(x1, 4)), graphView, 0, 0)
+		cx := toX(view.cursor)
+		canvas.AddLine(pos.Add(image.Pt(cx, 0)), pos.Add(image.Pt(cx, height)), graphCursor, 1)
+	}
+
+	G.InvisibleButton().Size(float32(width), float32(height)).Build()
+	mouseX := G.GetMousePos().X - pos.X
+	if mouseX < 0 || mouseX >= width {
+		return
+	}
+	block := int(float64(mouseX) * perX)
+	if block >= len(entropies) {
+		return
+	}
+	addr := int64(block) * blockSize
+	if I.IsItemHovered() {
+		I.SetTooltip(fmt.Sprintf("%X: %.2f bits/byte", addr+hf.fw.base, entropies[block]))
+	}
+	if I.IsItemActive() && I.IsMouseDown(int(G.MouseButtonLeft)) {
+		tab.setCursor(addr)
+		tab.view.ScrollTop(addr)
+	}
+}
+
+//the byte histogram, hover shows the counts
+func (ew *entropyWindow) histogramGraph(counts *[256]int64, total int64) {
+	if counts == nil {
+		return
+	}
+	w, _ := G.GetAvailableRegion()
+	width, height := int(w), 120
+	if width < 256 {
+		width = 256
+	}
+	pos := G.GetCursorScreenPos()
+	canvas := G.GetCanvas()
+	canvas.AddRectFilled(pos, pos.Add(image.Pt(width, height)), graphBG, 0, 0)
+
+	scale := func(n int64) float64 {
+		if ew.logScale {
+			return math.Log1p(float64(n))
+		}
+		return float64(n)
+	}
+	var max float64
+	for _, n := range counts {
+		if s := scale(n); s > max {
+			max = s
+		}
+	}
+	if max > 0 {
+		barW := float64(width) / 256
+		for c, n := range counts {
+			if n == 0 {
+				continue
+			}
+			x0 := int(float64(c) * barW)
+			x1 := int(float64(c+1) * barW)
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			h := int(scale(n) / max * float64(height))
+			if h < 1 {
+				h = 1
+			}
+			canvas.AddRectFilled(pos.Add(image.Pt(x0, height-h)), pos.Add(image.Pt(x1, height)), histogramFG, 0, 0)
+		}
+	}
+
+	G.InvisibleButton().Size(float32(width), float32(height)).Build()
+	if I.IsItemHovered() {
+		c := (G.GetMousePos().X - pos.X) * 256 / width
+		if c >= 0 && c < 256 && total > 0 {
+			I.SetTooltip(fmt.Sprintf("%02X: %d (%.2f%%)", c, counts[c], 100*float64(counts[c])/float64(total)))
+		}
+	}
+}
+
+func drawEntropyWindow() {
+	ew := &HD.Entropy
+	if !ew.open {
+		ew.mu.Lock()
+		busy := ew.entBusy || ew.histBusy
+		ew.mu.Unlock()
+		if busy {
+			ew.cancel()
+		}
+		return
+	}
+	tab := ActiveTab()
+	hf := ActiveFile()
+	if tab == nil || hf == nil {
+		G.Window("Entropy").IsOpen(&ew.open).Layout(G.Label("No file opened"))
+		return
+	}
+	ew.update(tab, hf)
+
+	ew.mu.Lock()
+	entBusy, entProgress, entropies, shown := ew.entBusy, ew.entProgress, ew.entropies, ew.entShown
+	histBusy, histogram, histTotal := ew.histBusy, ew.histogram, ew.histTotal
+	ew.mu.Unlock()
+
+	var entStatus G.Widget = G.Labelf("%d blocks", len(entropies))
+	if entBusy {
+		entStatus = G.ProgressBar(entProgress).Size(200, 0)
+	}
+	what := "file"
+	if _, size := tab.view.Selection(); size > 0 {
+		what = "selection"
+	}
+	histStatus := fmt.Sprintf("Byte values of the %s", what)
+	if histogram != nil {
+		histStatus += fmt.Sprintf(", entropy %.3f bits/byte", entropy(histogram, histTotal))
+	}
+	if histBusy {
+		histStatus += " (updating)"
+	}
+
+	G.Window("Entropy").IsOpen(&ew.open).Pos(120, 120).Size(600, 400).Layout(
+		G.Row(
+			G.Label("Block Size"),
+			G.Combo("##entropyblock", entropyBlockNames[ew.blockSize], entropyBlockNames, &ew.blockSize).Size(80),
+			entStatus,
+		),
+		G.Custom(func() {
+			if shown.name == hf.name {
+				ew.graph(tab, hf, entropies, shown.blockSize)
+			}
+		}),
+		G.Separator(),
+		G.Row(
+			G.Label(histStatus),
+			G.Checkbox("Log Scale", &ew.logScale),
+		),
+		G.Custom(func() { ew.histogramGraph(histogram, histTotal) }),
+	)
+}
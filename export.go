@@ -0,0 +1,441 @@
+package main
+
+//exporting (a part of) a file as source code or text
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/ascii85"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	G "github.com/AllenDang/giu"
+	B "github.com/snhmibby/filebuf"
+)
+
+type exportOptions struct {
+	lineWidth int    //bytes per line (base64/85: 0 means no wrapping)
+	varName   string //variable name for source code
+	offset    int64  //address of the first byte, for the hexdump
+}
+
+type exportFormat struct {
+	name  string
+	write func(w io.Writer, b *B.Buffer, opt exportOptions) error
+}
+
+var exportFormats = []exportFormat{
+	{"C array (xxd -i)", exportC},
+	{"Go []byte", exportGo},
+	{"Python bytes", exportPython},
+	{"Hexdump (hexdump -C)", exportHexdump},
+	{"Base64", exportBase64},
+	{"Base85 (ascii85)", exportBase85},
+}
+
+//call f for each line of width bytes of b, last is true for the last line
+func exportLines(b *B.Buffer, width int, f func(line []byte, last bool) error) error {
+	if width <= 0 {
+		width = 16
+	}
+	var err error
+	var done int64
+	size := b.Size()
+	line := make([]byte, 0, width)
+	b.Iter(func(p []byte) bool {
+		for len(p) > 0 && err == nil {
+			n := width - len(line)
+			if n > len(p) {
+				n = len(p)
+			}
+			line = append(line, p[:n]...)
+			p = p[n:]
+			done += int64(n)
+			if len(line) == width {
+				err = f(line, done == size)
+				line = line[:0]
+			}
+		}
+		return err != nil
+	})
+	if err == nil && len(line) > 0 {
+		err = f(line, true)
+	}
+	return err
+}
+
+//write a list of comma separated hex bytes, 1 line of them per call
+func hexList(w io.Writer, indent string, line []byte, last bool) error {
+	var sb strings.Builder
+	sb.WriteString(indent)
+	for i, c := range line {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		fmt.Fprintf(&sb, "0x%02x", c)
+		if i < len(line)-1 || !last {
+			sb.WriteString(",")
+		}
+	}
+	sb.WriteString("\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func exportC(w io.Writer, b *B.Buffer, opt exportOptions) error {
+	fmt.Fprintf(w, "unsigned char %s[] = {\n", opt.varName)
+	err := exportLines(b, opt.lineWidth, func(line []byte, last bool) error {
+		return hexList(w, "  ", line, last)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "};\nunsigned int %s_len = %d;\n", opt.varName, b.Size())
+	return err
+}
+
+func exportGo(w io.Writer, b *B.Buffer, opt exportOptions) error {
+	fmt.Fprintf(w, "var %s = []byte{\n", opt.varName)
+	err := exportLines(b, opt.lineWidth, func(line []byte, last bool) error {
+		//gofmt style: trailing comma on the last line also
+		return hexList(w, "\t", line, false)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "}\n")
+	return err
+}
+
+func exportPython(w io.Writer, b *B.Buffer, opt exportOptions) error {
+	fmt.Fprintf(w, "%s = (\n", opt.varName)
+	err := exportLines(b, opt.lineWidth, func(line []byte, last bool) error {
+		var sb strings.Builder
+		sb.WriteString("    b\"")
+		for _, c := range line {
+			fmt.Fprintf(&sb, "\\x%02x", c)
+		}
+		sb.WriteString("\"\n")
+		_, err := io.WriteString(w, sb.String())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, ")\n")
+	return err
+}
+
+//canonical hexdump, identical lines are collapsed into a '*'
+func exportHexdump(w io.Writer, b *B.Buffer, opt exportOptions) error {
+	width := opt.lineWidth
+	if width <= 0 {
+		width = 16
+	}
+	addr := opt.offset
+	var prev []byte
+	star := false
+	err := exportLines(b, width, func(line []byte, last bool) error {
+		defer func() { addr += int64(len(line)) }()
+		if !last && bytes.Equal(line, prev) {
+			if !star {
+				star = true
+				_, err := io.WriteString(w, "*\n")
+				return err
+			}
+			return nil
+		}
+		star = false
+		prev = append(prev[:0], line...)
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%08x ", addr)
+		for i := 0; i < width; i++ {
+			if i%8 == 0 {
+				sb.WriteString(" ")
+			}
+			if i < len(line) {
+				fmt.Fprintf(&sb, "%02x ", line[i])
+			} else {
+				sb.WriteString("   ")
+			}
+		}
+		sb.WriteString(" |")
+		for _, c := range line {
+			if c >= 0x20 && c < 0x7f {
+				sb.WriteByte(c)
+			} else {
+				sb.WriteByte('.')
+			}
+		}
+		sb.WriteString("|\n")
+		_, err := io.WriteString(w, sb.String())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%08x\n", opt.offset+b.Size())
+	return err
+}
+
+//lineWriter breaks its output into lines of width characters
+type lineWriter struct {
+	w     io.Writer
+	width int
+	col   int
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	if lw.width <= 0 {
+		return lw.w.Write(p)
+	}
+	written := 0
+	for len(p) > 0 {
+		n := lw.width - lw.col
+		if n > len(p) {
+			n = len(p)
+		}
+		k, err := lw.w.Write(p[:n])
+		written += k
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+		lw.col += n
+		if lw.col == lw.width {
+			if _, err := io.WriteString(lw.w, "\n"); err != nil {
+				return written, err
+			}
+			lw.col = 0
+		}
+	}
+	return written, nil
+}
+
+//finish the last line
+func (lw *lineWriter) Close() error {
+	if lw.col > 0 || lw.width <= 0 {
+		_, err := io.WriteString(lw.w, "\n")
+		return err
+	}
+	return nil
+}
+
+//stream b through an encoder
+func exportEncoded(w io.Writer, b *B.Buffer, width int, mkEncoder func(io.Writer) io.WriteCloser) error {
+	lw := &lineWriter{w: w, width: width}
+	enc := mkEncoder(lw)
+	var err error
+	b.Iter(func(p []byte) bool {
+		_, err = enc.Write(p)
+		return err != nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = enc.Close(); err != nil {
+		return err
+	}
+	return lw.Close()
+}
+
+//encode lineWidth bytes per line (rounded up to a full group of 3)
+func exportBase64(w io.Writer, b *B.Buffer, opt exportOptions) error {
+	width := (opt.lineWidth + 2) / 3 * 4
+	return exportEncoded(w, b, width, func(w io.Writer) io.WriteCloser {
+		return base64.NewEncoder(base64.StdEncoding, w)
+	})
+}
+
+//encode lineWidth bytes per line (rounded up to a full group of 4)
+func exportBase85(w io.Writer, b *B.Buffer, opt exportOptions) error {
+	width := (opt.lineWidth + 3) / 4 * 5
+	return exportEncoded(w, b, width, ascii85.NewEncoder)
+}
+
+/*
+ * Export dialog
+ */
+
+type exportDialog struct {
+	id   string
+	open bool
+
+	format        int32
+	lineWidth     int32
+	varName       string
+	selectionOnly bool
+
+	//what to write when the file dialog returns
+	pending    *B.Buffer
+	pendingOpt exportOptions
+}
+
+func (d *exportDialog) Dispose() {}
+
+func (d *exportDialog) saveState() {
+	G.Context.SetState(d.id, d)
+}
+
+//is name an identifier in C, Go and Python
+func validVarName(name string) bool {
+	for i, c := range name {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+//copy of the data to export (selection or entire file) and the options
+func (d *exportDialog) source(what string) (*B.Buffer, exportOptions, bool) {
+	tab := ActiveTab()
+	hf := ActiveFile()
+	if tab == nil || hf == nil {
+		return nil, exportOptions{}, false
+	}
+	off, size := int64(0), hf.buf.Size()
+	if s, n := tab.view.Selection(); d.selectionOnly && n > 0 {
+		off, size = s, n
+	}
+	if size == 0 {
+		//an empty array isn't valid C
+		ErrorDialog(what, "Nothing to export")
+		return nil, exportOptions{}, false
+	}
+	name := d.varName
+	if name == "" {
+		name = "data"
+	}
+	if !validVarName(name) {
+		ErrorDialog(what, fmt.Sprintf("<%s> is not a valid variable name", name))
+		return nil, exportOptions{}, false
+	}
+	opt := exportOptions{lineWidth: int(d.lineWidth), varName: name, offset: off}
+	return hf.buf.Copy(off, size), opt, true
+}
+
+func (d *exportDialog) write(w io.Writer, b *B.Buffer, opt exportOptions) error {
+	bw := bufio.NewWriter(w)
+	if err := exportFormats[d.format].write(bw, b, opt); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (d *exportDialog) toClipboard() {
+	b, opt, ok := d.source("Export to clipboard")
+	if !ok {
+		return
+	}
+	var sb strings.Builder
+	if err := d.write(&sb, b, opt); err != nil {
+		ErrorDialog("Export to clipboard", err.Error())
+	} else {
+		G.Context.GetPlatform().SetClipboard(sb.String())
+	}
+	d.close()
+}
+
+func (d *exportDialog) toFile() {
+	b, opt, ok := d.source("Export")
+	if !ok {
+		return
+	}
+	d.pending, d.pendingOpt = b, opt
+	d.close()
+	FileDialog(DialogExportFile)
+}
+
+//callback of the export file dialog
+func (d *exportDialog) writeFile(p string) {
+	if d.pending == nil {
+		return
+	}
+	b := d.pending
+	d.pending = nil
+	f, err := os.Create(p)
+	if err != nil {
+		ErrorDialog(fmt.Sprintf("Exporting to <%s>", p), err.Error())
+		return
+	}
+	err = d.write(f, b, d.pendingOpt)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		ErrorDialog(fmt.Sprintf("Exporting to <%s>", p), err.Error())
+	}
+}
+
+func (d *exportDialog) close() {
+	d.saveState()
+	G.CloseCurrentPopup()
+}
+
+func getExportDialog(id string) *exportDialog {
+	r := G.Context.GetState(id)
+	if r == nil {
+		d := &exportDialog{id: id, lineWidth: 16, varName: "data", selectionOnly: true}
+		d.saveState()
+		return d
+	}
+	return r.(*exportDialog)
+}
+
+func prepareExportDialog(id string) G.Widget {
+	d := getExportDialog(id)
+	return G.Custom(func() {
+		if d.open {
+			G.OpenPopup(id)
+			d.open = false
+		}
+		names := make([]string, len(exportFormats))
+		for i, f := range exportFormats {
+			names[i] = f.name
+		}
+		G.SetNextWindowSizeV(400, 0, G.ConditionOnce)
+		G.PopupModal(id).Layout(
+			G.Combo("Format", names[d.format], names, &d.format),
+			G.InputInt(&d.lineWidth).Label("Line Width"),
+			G.InputText(&d.varName).Label("Variable Name"),
+			G.Checkbox("Selection Only", &d.selectionOnly),
+			G.Row(
+				G.Button("Cancel").OnClick(d.close),
+				G.Button("Copy to Clipboard").OnClick(d.toClipboard),
+				G.Button("Save to File").OnClick(d.toFile),
+			),
+			G.Custom(func() {
+				if G.IsKeyPressed(G.KeyEscape) {
+					d.close()
+				}
+			}),
+		).Build()
+	})
+}
+
+/*
+ *Public:
+ */
+
+func ExportDialog(id string) {
+	d := getExportDialog(id)
+	d.open = true
+	d.saveState()
+}
+
+func PrepareExportDialog(id string) G.Widget {
+	return prepareExportDialog(id)
+}
+
+//export to the file chosen in the file dialog
+func PrepareExportFileDialog(id, exportId string) G.Widget {
+	return PrepareFileDialog(id, func(p string) {
+		getExportDialog(exportId).writeFile(p)
+	})
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 
 	B "github.com/snhmibby/filebuf"
 )
@@ -15,8 +19,39 @@ func (hf *HexFile) addRedo(f Undo) {
 	hf.redo = append(hf.redo, f)
 }
 
+//add the undo entry of the edits just made (or just redone)
 func (hf *HexFile) addUndo(f Undo) {
+	f.lost = hf.takeLoss()
 	hf.undo = append(hf.undo, f)
+	hf.touch()
+}
+
+//merge the last n undo entries into 1
+func (hf *HexFile) groupUndo(n int) {
+	if n <= 1 || n > len(hf.undo) {
+		return
+	}
+	group := make([]Undo, n)
+	copy(group, hf.undo[len(hf.undo)-n:])
+	hf.undo = hf.undo[:len(hf.undo)-n]
+	//the entries keep their own loss, it is restored in between them
+	hf.undo = append(hf.undo, Undo{
+		undo: func() (off, size int64) {
+			for i := len(group) - 1; i >= 0; i-- {
+				off, size = group[i].undo()
+				hf.takeLoss()
+				hf.restoreLoss(group[i].lost)
+			}
+			return off, size
+		},
+		redo: func() (off, size int64) {
+			for i := range group {
+				off, size = group[i].redo()
+				group[i].lost = hf.takeLoss()
+			}
+			return off, size
+		},
+	})
 }
 
 func (hf *HexFile) Redo() {
@@ -43,7 +78,10 @@ func (hf *HexFile) Undo() {
 	f := hf.undo[sz-1]
 	hf.undo = hf.undo[:sz-1]
 	off, size := f.undo()
+	hf.takeLoss()
+	hf.restoreLoss(f.lost)
 	hf.addRedo(f)
+	hf.touch()
 	if tab := ActiveTab(); tab != nil && tab.name == hf.name {
 		tab.setCursor(off)
 		tab.view.SetSelection(off, size)
@@ -53,29 +91,215 @@ func (hf *HexFile) Undo() {
 func OpenHexFile(path string) (*HexFile, error) {
 	hf, ok := HD.Files[path]
 	if !ok {
-		//open & stat file
-		stats, err := os.Stat(path)
+		var truncated bool
+		var err error
+		hf, truncated, err = loadHexFile(path)
 		if err != nil {
 			return nil, mkErr("OpenHexFile", err)
 		}
-		//XXX this check shouldn't even be here - we want to be able to edit ANY file
-		if !stats.Mode().IsRegular() {
-			return nil, mkErr("OpenHexFile", fmt.Errorf("%s is not a regular file", path))
+		if truncated {
+			InfoDialog(path, fmt.Sprintf("Only the first %s were read.", mkSize(maxStreamSize)))
 		}
-		buf, err := B.OpenFile(path)
-		if err != nil {
-			return nil, mkErr("OpenHexFile", err)
+		if err := hf.loadProject(path); err != nil {
+			ErrorDialog("Loading Project", err.Error())
 		}
-		hf = new(HexFile)
-		hf.buf = buf
-		hf.name = path
-		hf.stats = stats
 		HD.Files[path] = hf
 	}
+	if !isTempFile(path) {
+		addRecentFile(path)
+	}
 	OpenTab(hf)
 	return hf, nil
 }
 
+//load a file, without the gui. truncated is set if a stream was longer than maxStreamSize
+func loadHexFile(path string) (hf *HexFile, truncated bool, err error) {
+	stats, err := os.Stat(path)
+	if err != nil {
+		return nil, false, err
+	}
+	kind, err := fileKindOf(path, stats)
+	if err != nil {
+		return nil, false, err
+	}
+	var buf *B.Buffer
+	var dev *os.File
+	var info fwInfo
+	format := FormatRaw
+	if kind == KindRegular {
+		format, _ = formatFromPath(path)
+		buf, info, err = loadBuffer(path, format)
+		if err != nil && format != FormatRaw {
+			//not a valid firmware image after all, just look at the bytes
+			format = FormatRaw
+			buf, info, err = loadBuffer(path, format)
+		}
+	} else {
+		buf, dev, truncated, err = loadSpecial(path, kind)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	hf = new(HexFile)
+	hf.buf = buf
+	hf.name = path
+	hf.stats = stats
+	hf.format = format
+	hf.fw = info
+	hf.kind = kind
+	hf.device = dev
+	//streams can't be written back
+	hf.readOnly = kind == KindStream
+	hf.resetOriginal()
+	return hf, truncated, nil
+}
+
+//write the file to p, devices and process memory are written in place
+func (hf *HexFile) save(p string) error {
+	if hf.kind == KindBlockDevice && p == hf.name {
+		//devices are written in place, the file dance below won't work
+		if err := writeDevice(hf); err != nil {
+			return fmt.Errorf("writing device: %v", err)
+		}
+		hf.resetOriginal()
+		return nil
+	}
+	if hf.kind == KindProcess && p == hf.name {
+		if err := writeProcess(hf); err != nil {
+			return fmt.Errorf("writing process memory: %v", err)
+		}
+		hf.resetOriginal()
+		return nil
+	}
+
+	//write a temporary file next to p, and rename it when it is complete
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	//temporary files are private, keep the permissions of the file we replace
+	perm := os.FileMode(0644)
+	if stats, err := os.Stat(p); err == nil {
+		perm = stats.Mode().Perm()
+	}
+	f.Chmod(perm)
+	//write in the format it was loaded in. a new name can ask for another one,
+	//the name of a .hex file that wasn't valid Intel HEX can't.
+	format := hf.format
+	if f, ok := formatFromPath(p); ok && p != hf.name {
+		format = f
+	}
+	w := bufio.NewWriter(f)
+	err = writeFormat(w, hf.buf, format, hf.fw, HD.FillByte, p)
+	if err == nil {
+		err = w.Flush()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("couldn't rename tmp file <%s> to <%s>: %v", f.Name(), p, err)
+	}
+
+	//the buffer refers to the replaced file, open the new one
+	buf, info, err := loadBuffer(p, format)
+	if err != nil {
+		return fmt.Errorf("reopening after saving: %v", err)
+	}
+	hf.buf, hf.fw, hf.format = buf, info, format
+	hf.setSnapshot("")
+	hf.resetOriginal()
+	if stats, err := os.Stat(p); err == nil && p == hf.name {
+		hf.stats, hf.seen = stats, nil
+	}
+	return nil
+}
+
+//load the contents of path in a buffer, firmware images are parsed into memory
+func loadBuffer(path string, format fileFormat) (*B.Buffer, fwInfo, error) {
+	if format == FormatRaw {
+		buf, err := B.OpenFile(path)
+		return buf, fwInfo{}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fwInfo{}, err
+	}
+	defer f.Close()
+	return loadFirmware(f, format, HD.FillByte)
+}
+
+//the file was written in place by another program: the buffer reads its new bytes
+//where it isn't edited. Make it read from a private copy of the file as it is now,
+//with the edits in memory, so later writes don't change it anymore.
+func (hf *HexFile) detach() error {
+	if hf.snapshot != "" || hf.format != FormatRaw {
+		return nil
+	}
+	src, err := os.Open(hf.name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.CreateTemp("", "hexdunk-snapshot-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		//cut what was appended, pad what was truncated: the original keeps its size
+		err = dst.Truncate(hf.orig.Size())
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	var orig *B.Buffer
+	if err == nil {
+		orig, err = B.OpenFile(dst.Name())
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return err
+	}
+
+	//the unchanged parts from the copy, the changes in memory
+	buf := B.NewEmpty()
+	var a int64
+	for _, h := range hf.Changes() {
+		if h.aOff > a {
+			buf.Paste(buf.Size(), orig.Copy(a, h.aOff-a))
+		}
+		if h.bSize > 0 {
+			data := make([]byte, h.bSize)
+			hf.buf.Seek(h.bOff, io.SeekStart)
+			if _, err := io.ReadFull(hf.buf, data); err != nil {
+				os.Remove(dst.Name())
+				return err
+			}
+			buf.Paste(buf.Size(), B.NewMem(data))
+		}
+		a = h.aOff + h.aSize
+	}
+	if orig.Size() > a {
+		buf.Paste(buf.Size(), orig.Copy(a, orig.Size()-a))
+	}
+	hf.buf, hf.orig = buf, orig
+	hf.setSnapshot(dst.Name())
+	return nil
+}
+
+//the buffer reads from the private copy p now (or from the file again if p is ""),
+//the previous one can go (on unix the buffers that still read from it keep it open)
+func (hf *HexFile) setSnapshot(p string) {
+	if hf.snapshot != "" && hf.snapshot != p {
+		os.Remove(hf.snapshot)
+	}
+	hf.snapshot = p
+}
+
 //should only called when the last view (tab) on this file is closed
 func CloseHexFile(path string) error {
 	hf, ok := HD.Files[path]
@@ -92,10 +316,28 @@ func CloseHexFile(path string) error {
 			panic("shouldn't happen")
 		}
 	}
+	if err := hf.closeProject(); err != nil {
+		ErrorDialog("Saving Project", err.Error())
+	}
+	hf.removeTemp()
 	delete(HD.Files, path)
 	return nil
 }
 
+//remove the temporary files of hf, the buffer may still read from them (on unix).
+//a device is closed, the buffer can't be used anymore.
+func (hf *HexFile) removeTemp() {
+	for _, p := range hf.temp {
+		os.Remove(p)
+	}
+	hf.temp = nil
+	hf.setSnapshot("")
+	if hf.device != nil {
+		hf.device.Close()
+		hf.device = nil
+	}
+}
+
 func (hf *HexFile) Copy(off, size int64) (*B.Buffer, error) {
 	if size <= 0 {
 		return nil, fmt.Errorf("Cut: size <= 0")
@@ -109,6 +351,7 @@ func (hf *HexFile) Copy(off, size int64) (*B.Buffer, error) {
 
 func (hf *HexFile) Paste(off int64, b *B.Buffer) {
 	hf.buf.Paste(off, b)
+	hf.edited(off, 0, b.Size())
 }
 
 func (hf *HexFile) Cut(off, size int64) (*B.Buffer, error) {
@@ -119,7 +362,92 @@ func (hf *HexFile) Cut(off, size int64) (*B.Buffer, error) {
 		e := fmt.Errorf("Cut: 0 < off (%d) < off + size (%d) < file.Size() (%d)", off, size, hf.buf.Size())
 		return nil, e
 	}
-	return hf.buf.Cut(off, size), nil
+	cut := hf.buf.Cut(off, size)
+	hf.edited(off, size, 0)
+	return cut, nil
+}
+
+//edits of read-only files are refused, with an error dialog
+func (hf *HexFile) writable(what string) bool {
+	if hf.readOnly {
+		ErrorDialog(what, fmt.Sprintf("%s is opened read-only", hf.name))
+		return false
+	}
+	return true
+}
+
+//replace size bytes at off with the contents of b, as 1 undo-able edit
+func (hf *HexFile) Replace(off, size int64, b *B.Buffer) error {
+	if hf.readOnly {
+		return fmt.Errorf("Replace: %s is opened read-only", hf.name)
+	}
+	if size < 0 || off < 0 || off+size > hf.buf.Size() {
+		e := fmt.Errorf("Replace: 0 < off (%d) < off + size (%d) < file.Size() (%d)", off, size, hf.buf.Size())
+		return e
+	}
+	old := hf.buf.Cut(off, size)
+	hf.buf.Paste(off, b)
+	hf.edited(off, size, b.Size())
+	hf.emptyRedo()
+	hf.addUndo(Undo{
+		undo: func() (int64, int64) {
+			hf.buf.Cut(off, b.Size())
+			hf.buf.Paste(off, old)
+			hf.edited(off, b.Size(), old.Size())
+			return off, old.Size()
+		},
+		redo: func() (int64, int64) {
+			hf.buf.Cut(off, old.Size())
+			hf.buf.Paste(off, b)
+			hf.edited(off, old.Size(), b.Size())
+			return off, b.Size()
+		},
+	})
+	return nil
+}
+
+//marks and annotations on bytes that an edit replaced, they can't be moved back by
+//the reverse edit so undo restores them. The same for the list of changes.
+type editLoss struct {
+	marks       []markState
+	annotations []annotationState
+
+	hasChanges bool
+	changes    []diffHunk //before the edits
+	changesGen int        //of the original they belong to
+}
+
+//every change of the buffer must be reported, to keep offsets into the file valid:
+//the bytes [off, off+oldSize) were replaced by newSize bytes
+func (hf *HexFile) edited(off, oldSize, newSize int64) {
+	hf.lost.marks = append(hf.lost.marks, hf.marks.adjust(off, oldSize, newSize)...)
+	hf.lost.annotations = append(hf.lost.annotations, hf.annotations.adjust(off, oldSize, newSize)...)
+	if !hf.lost.hasChanges {
+		hf.lost.hasChanges = true
+		hf.lost.changes, hf.lost.changesGen = hf.changes.hunks, hf.changes.gen
+	}
+	hf.trackChange(off, oldSize, newSize)
+}
+
+//the loss of the edits since the last call
+func (hf *HexFile) takeLoss() editLoss {
+	l := hf.lost
+	hf.lost = editLoss{}
+	return l
+}
+
+//put back what the undone edits lost, the last edit first
+func (hf *HexFile) restoreLoss(l editLoss) {
+	for i := len(l.annotations) - 1; i >= 0; i-- {
+		hf.annotations.restore(l.annotations[i : i+1])
+	}
+	for i := len(l.marks) - 1; i >= 0; i-- {
+		hf.marks.restore(l.marks[i : i+1])
+	}
+	//not after a save, the original changed
+	if l.hasChanges && l.changesGen == hf.changes.gen {
+		hf.changes.hunks = l.changes
+	}
 }
 
 func (h *HexFile) ClampAddr(a *int64) {
@@ -131,3 +459,25 @@ func (h *HexFile) ClampAddr(a *int64) {
 		*a = h.buf.Size()
 	}
 }
+
+//offset of the first pat in b from start, -1 if there is none
+func findBytes(b *B.Buffer, pat []byte, start int64) int64 {
+	const chunk = 1 << 20
+	size := b.Size()
+	if len(pat) == 0 || start < 0 || start >= size {
+		return -1
+	}
+	data := make([]byte, chunk+len(pat)-1)
+	for off := start; off < size; off += chunk {
+		n := int64(len(data))
+		if size-off < n {
+			n = size - off
+		}
+		b.Seek(off, io.SeekStart)
+		io.ReadFull(b, data[:n])
+		if i := bytes.Index(data[:n], pat); i >= 0 {
+			return off + int64(i)
+		}
+	}
+	return -1
+}
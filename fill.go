@@ -0,0 +1,211 @@
+package main
+
+//filling the selection (or inserting bytes at the cursor) with a constant byte, a
+//repeating pattern, an incrementing sequence or random bytes
+
+import (
+	"fmt"
+	"math/rand"
+
+	G "github.com/AllenDang/giu"
+	B "github.com/snhmibby/filebuf"
+)
+
+//fills are made in memory
+const maxFillSize = 256 << 20
+
+const (
+	FillConstant = iota
+	FillPattern
+	FillSequence
+	FillRandom
+)
+
+var fillKinds = []string{"Byte", "Pattern", "Sequence", "Random"}
+
+type fillOptions struct {
+	kind    int
+	pattern []byte //the byte (constant), the pattern, or the first byte (sequence)
+	step    int    //sequence
+	seed    int64  //random
+}
+
+//n bytes of fill data
+func fillData(opt fillOptions, n int64) ([]byte, error) {
+	if n > maxFillSize {
+		return nil, fmt.Errorf("can't fill more than %s", mkSize(maxFillSize))
+	}
+	if opt.kind != FillRandom && len(opt.pattern) == 0 {
+		return nil, fmt.Errorf("no bytes given")
+	}
+	data := make([]byte, n)
+	switch opt.kind {
+	case FillConstant:
+		for i := range data {
+			data[i] = opt.pattern[0]
+		}
+	case FillPattern:
+		for i := range data {
+			data[i] = opt.pattern[i%len(opt.pattern)]
+		}
+	case FillSequence:
+		b := int(opt.pattern[0])
+		for i := range data {
+			data[i] = byte(b)
+			b += opt.step
+		}
+	case FillRandom:
+		rand.New(rand.NewSource(opt.seed)).Read(data)
+	}
+	return data, nil
+}
+
+//replace size bytes at off with fill data as 1 undo-able edit, size 0 inserts count bytes
+func (hf *HexFile) Fill(off, size, count int64, opt fillOptions) error {
+	n := size
+	if n == 0 {
+		n = count
+	}
+	if n <= 0 {
+		return fmt.Errorf("nothing to fill")
+	}
+	data, err := fillData(opt, n)
+	if err != nil {
+		return err
+	}
+	return hf.Replace(off, size, B.NewMem(data))
+}
+
+/*
+ * the fill dialog
+ */
+
+type fillDialog struct {
+	id   string
+	open bool
+
+	kind  int32
+	value string //hex byte(s)
+	step  int32
+	seed  int32
+	count int32 //bytes to insert without a selection
+}
+
+func (d *fillDialog) Dispose() {}
+
+func (d *fillDialog) saveState() {
+	G.Context.SetState(d.id, d)
+}
+
+func (d *fillDialog) close() {
+	d.saveState()
+	G.CloseCurrentPopup()
+}
+
+func (d *fillDialog) options() (fillOptions, error) {
+	opt := fillOptions{kind: int(d.kind), step: int(d.step), seed: int64(d.seed)}
+	if opt.kind == FillRandom {
+		return opt, nil
+	}
+	var err error
+	opt.pattern, err = parseHexBytes(d.value)
+	return opt, err
+}
+
+func (d *fillDialog) fill() {
+	tab := ActiveTab()
+	hf := ActiveFile()
+	if tab == nil || hf == nil || !hf.writable("Fill") {
+		d.close()
+		return
+	}
+	opt, err := d.options()
+	if err != nil {
+		ErrorDialog("Fill", err.Error())
+		return
+	}
+	off, size := tab.view.Selection()
+	if size == 0 {
+		off = tab.view.cursor
+	}
+	if err := hf.Fill(off, size, int64(d.count), opt); err != nil {
+		ErrorDialog("Fill", err.Error())
+		return
+	}
+	if size == 0 {
+		size = int64(d.count)
+	}
+	tab.view.SetSelection(off, size)
+	d.close()
+}
+
+func getFillDialog(id string) *fillDialog {
+	r := G.Context.GetState(id)
+	if r == nil {
+		d := &fillDialog{id: id, value: "00", step: 1, count: 16}
+		d.saveState()
+		return d
+	}
+	return r.(*fillDialog)
+}
+
+func prepareFillDialog(id string) G.Widget {
+	d := getFillDialog(id)
+	return G.Custom(func() {
+		if d.open {
+			G.OpenPopup(id)
+			d.open = false
+		}
+		tab := ActiveTab()
+		selected := tab != nil && tab.view.selectionSize > 0
+
+		var what G.Widget
+		switch d.kind {
+		case FillConstant:
+			what = G.InputText(&d.value).Label("Byte (hex)")
+		case FillPattern:
+			what = G.InputText(&d.value).Label("Pattern (hex)")
+		case FillSequence:
+			what = G.Layout{
+				G.InputText(&d.value).Label("First Byte (hex)"),
+				G.InputInt(&d.step).Label("Step"),
+			}
+		case FillRandom:
+			what = G.InputInt(&d.seed).Label("Seed")
+		}
+		var where G.Widget = G.Label("Fills the selection")
+		if !selected {
+			where = G.InputInt(&d.count).Label("Bytes to Insert")
+		}
+
+		G.SetNextWindowSizeV(350, 0, G.ConditionOnce)
+		G.PopupModal(id).Layout(
+			G.Combo("Fill With", fillKinds[d.kind], fillKinds, &d.kind),
+			what,
+			where,
+			G.Row(
+				G.Button("Cancel").OnClick(d.close),
+				G.Button("Fill").OnClick(d.fill),
+			),
+			G.Custom(func() {
+				if G.IsKeyPressed(G.KeyEscape) {
+					d.close()
+				}
+			}),
+		).Build()
+	})
+}
+
+/*
+ *Public:
+ */
+
+func FillDialog(id string) {
+	d := getFillDialog(id)
+	d.open = true
+	d.saveState()
+}
+
+func PrepareFillDialog(id string) G.Widget {
+	return prepareFillDialog(id)
+}
@@ -0,0 +1,393 @@
+package main
+
+//loading and saving firmware images: Intel HEX and Motorola S-records
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+type fileFormat int
+
+const (
+	FormatRaw fileFormat = iota
+	FormatIHex
+	FormatSRec
+)
+
+const (
+	fwRecordLen = 16      //data bytes per written record
+	fwMaxImage  = 1 << 30 //refuse images (including gaps) bigger than this
+)
+
+//firmware image information, for files that aren't raw
+type fwInfo struct {
+	base     int64 //load address of the first byte in the buffer
+	start    int64 //start (entry) address record, if hasStart
+	hasStart bool
+}
+
+//a piece of data at a load address
+type fwSegment struct {
+	addr int64
+	data []byte
+}
+
+func formatFromPath(path string) (fileFormat, bool) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".hex", ".ihex", ".ihx":
+		return FormatIHex, true
+	case ".srec", ".s19", ".s28", ".s37", ".mot":
+		return FormatSRec, true
+	case ".bin", ".img", ".raw":
+		return FormatRaw, true
+	}
+	return FormatRaw, false
+}
+
+//decode the hex digits of a record and check the length
+func fwRecordBytes(s string, line int) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %v", line, err)
+	}
+	if len(b) < 1 || int(b[0])+1 != len(b) {
+		return nil, fmt.Errorf("line %d: bad record length", line)
+	}
+	return b, nil
+}
+
+//parse an Intel HEX file into segments
+func parseIHex(r io.Reader) ([]fwSegment, fwInfo, error) {
+	var segs []fwSegment
+	var info fwInfo
+	var upper int64 //extended segment/linear address
+	scan := bufio.NewScanner(r)
+	for line := 1; scan.Scan(); line++ {
+		s := strings.TrimSpace(scan.Text())
+		if s == "" {
+			continue
+		}
+		if s[0] != ':' {
+			return nil, info, fmt.Errorf("line %d: record doesn't start with ':'", line)
+		}
+		b, err := hex.DecodeString(s[1:])
+		if err != nil {
+			return nil, info, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(b) < 5 || int(b[0])+5 != len(b) {
+			return nil, info, fmt.Errorf("line %d: bad record length", line)
+		}
+		var sum byte
+		for _, c := range b {
+			sum += c
+		}
+		if sum != 0 {
+			return nil, info, fmt.Errorf("line %d: checksum error", line)
+		}
+		addr := int64(b[1])<<8 | int64(b[2])
+		data := b[4 : len(b)-1]
+		switch b[3] {
+		case 0x00:
+			segs = append(segs, fwSegment{addr: upper + addr, data: data})
+		case 0x01:
+			return segs, info, nil
+		case 0x02:
+			if len(data) != 2 {
+				return nil, info, fmt.Errorf("line %d: bad extended segment address", line)
+			}
+			upper = (int64(data[0])<<8 | int64(data[1])) << 4
+		case 0x03:
+			if len(data) != 4 {
+				return nil, info, fmt.Errorf("line %d: bad start segment address", line)
+			}
+			cs := int64(data[0])<<8 | int64(data[1])
+			ip := int64(data[2])<<8 | int64(data[3])
+			info.start = cs<<4 + ip
+			info.hasStart = true
+		case 0x04:
+			if len(data) != 2 {
+				return nil, info, fmt.Errorf("line %d: bad extended linear address", line)
+			}
+			upper = int64(data[0])<<24 | int64(data[1])<<16
+		case 0x05:
+			if len(data) != 4 {
+				return nil, info, fmt.Errorf("line %d: bad start linear address", line)
+			}
+			info.start = int64(data[0])<<24 | int64(data[1])<<16 | int64(data[2])<<8 | int64(data[3])
+			info.hasStart = true
+		default:
+			return nil, info, fmt.Errorf("line %d: unknown record type %02X", line, b[3])
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, info, err
+	}
+	return segs, info, nil
+}
+
+//parse a Motorola S-record file into segments
+func parseSRec(r io.Reader) ([]fwSegment, fwInfo, error) {
+	var segs []fwSegment
+	var info fwInfo
+	scan := bufio.NewScanner(r)
+	for line := 1; scan.Scan(); line++ {
+		s := strings.TrimSpace(scan.Text())
+		if s == "" {
+			continue
+		}
+		if len(s) < 4 || s[0] != 'S' {
+			return nil, info, fmt.Errorf("line %d: record doesn't start with 'S'", line)
+		}
+		b, err := fwRecordBytes(s[2:], line)
+		if err != nil {
+			return nil, info, err
+		}
+		var sum byte
+		for _, c := range b {
+			sum += c
+		}
+		if sum != 0xFF {
+			return nil, info, fmt.Errorf("line %d: checksum error", line)
+		}
+		addrLen := map[byte]int{'0': 2, '1': 2, '2': 3, '3': 4, '5': 2, '6': 3, '7': 4, '8': 3, '9': 2}
+		n, ok := addrLen[s[1]]
+		if !ok {
+			return nil, info, fmt.Errorf("line %d: unknown record type S%c", line, s[1])
+		}
+		if len(b) < 1+n+1 {
+			return nil, info, fmt.Errorf("line %d: record too short", line)
+		}
+		var addr int64
+		for _, c := range b[1 : 1+n] {
+			addr = addr<<8 | int64(c)
+		}
+		data := b[1+n : len(b)-1]
+		switch s[1] {
+		case '1', '2', '3':
+			segs = append(segs, fwSegment{addr: addr, data: data})
+		case '7', '8', '9':
+			info.start, info.hasStart = addr, true
+		}
+	}
+	if err := scan.Err(); err != nil {
+		return nil, info, err
+	}
+	return segs, info, nil
+}
+
+//put the segments in 1 flat image, gaps are filled with the fill byte
+func flattenSegments(segs []fwSegment, fill byte) ([]byte, int64, error) {
+	if len(segs) == 0 {
+		return []byte{}, 0, nil
+	}
+	//stable: when records overlap, the last one wins
+	sort.SliceStable(segs, func(i, j int) bool { return segs[i].addr < segs[j].addr })
+	base := segs[0].addr
+	var end int64
+	for _, s := range segs {
+		if e := s.addr + int64(len(s.data)); e > end {
+			end = e
+		}
+	}
+	if end-base > fwMaxImage {
+		return nil, 0, fmt.Errorf("image from %X to %X is too big", base, end)
+	}
+	img := make([]byte, end-base)
+	for i := range img {
+		img[i] = fill
+	}
+	for _, s := range segs {
+		copy(img[s.addr-base:], s.data)
+	}
+	return img, base, nil
+}
+
+//load a firmware image file into a new (memory) buffer
+func loadFirmware(r io.Reader, format fileFormat, fill byte) (*B.Buffer, fwInfo, error) {
+	var segs []fwSegment
+	var info fwInfo
+	var err error
+	switch format {
+	case FormatIHex:
+		segs, info, err = parseIHex(r)
+	case FormatSRec:
+		segs, info, err = parseSRec(r)
+	default:
+		panic("loadFirmware: not a firmware format")
+	}
+	if err != nil {
+		return nil, info, err
+	}
+	img, base, err := flattenSegments(segs, fill)
+	if err != nil {
+		return nil, info, err
+	}
+	info.base = base
+	return B.NewMem(img), info, nil
+}
+
+//call f for each record-sized piece of b that isn't entirely the fill byte.
+//the first and last piece are always written, to keep the image size on reloading
+func fwRecords(b *B.Buffer, base int64, fill byte, f func(addr int64, data []byte) error) error {
+	var err error
+	var off int64
+	size := b.Size()
+	rec := make([]byte, 0, fwRecordLen)
+	flush := func() {
+		skip := off > 0 && off+int64(len(rec)) < size
+		for _, c := range rec {
+			skip = skip && c == fill
+		}
+		if !skip {
+			err = f(base+off, rec)
+		}
+		off += int64(len(rec))
+		rec = rec[:0]
+	}
+	b.Iter(func(p []byte) bool {
+		for len(p) > 0 && err == nil {
+			n := fwRecordLen - len(rec)
+			if n > len(p) {
+				n = len(p)
+			}
+			rec = append(rec, p[:n]...)
+			p = p[n:]
+			if len(rec) == fwRecordLen {
+				flush()
+			}
+		}
+		return err != nil
+	})
+	if err == nil && len(rec) > 0 {
+		flush()
+	}
+	return err
+}
+
+//write 1 record of the given bytes, as hex with a checksum
+func writeRecord(w io.Writer, prefix string, b []byte, checksum func(sum byte) byte) error {
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	_, err := fmt.Fprintf(w, "%s%X%02X\n", prefix, b, checksum(sum))
+	return err
+}
+
+func ihexRecord(w io.Writer, typ byte, addr uint16, data []byte) error {
+	b := append([]byte{byte(len(data)), byte(addr >> 8), byte(addr), typ}, data...)
+	return writeRecord(w, ":", b, func(sum byte) byte { return -sum })
+}
+
+//write b as Intel HEX, data records of fill bytes are left out
+func writeIHex(w io.Writer, b *B.Buffer, info fwInfo, fill byte) error {
+	if info.base+b.Size() > 1<<32 {
+		return fmt.Errorf("Intel HEX: image ends beyond 4GB (%X)", info.base+b.Size())
+	}
+	upper := int64(-1)
+	err := fwRecords(b, info.base, fill, func(addr int64, data []byte) error {
+		//don't let a record cross a 64K boundary
+		for len(data) > 0 {
+			if addr>>16 != upper {
+				upper = addr >> 16
+				if err := ihexRecord(w, 0x04, 0, []byte{byte(upper >> 8), byte(upper)}); err != nil {
+					return err
+				}
+			}
+			n := int64(len(data))
+			if lim := (upper+1)<<16 - addr; n > lim {
+				n = lim
+			}
+			if err := ihexRecord(w, 0x00, uint16(addr), data[:n]); err != nil {
+				return err
+			}
+			addr += n
+			data = data[n:]
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if info.hasStart {
+		s := info.start
+		if err := ihexRecord(w, 0x05, 0, []byte{byte(s >> 24), byte(s >> 16), byte(s >> 8), byte(s)}); err != nil {
+			return err
+		}
+	}
+	return ihexRecord(w, 0x01, 0, nil)
+}
+
+func srecRecord(w io.Writer, typ byte, addrLen int, addr int64, data []byte) error {
+	b := []byte{byte(addrLen + len(data) + 1)}
+	for i := addrLen - 1; i >= 0; i-- {
+		b = append(b, byte(addr>>(8*uint(i))))
+	}
+	b = append(b, data...)
+	return writeRecord(w, "S"+strconv.Itoa(int(typ)), b, func(sum byte) byte { return ^sum })
+}
+
+//write b as Motorola S-records, data records of fill bytes are left out
+func writeSRec(w io.Writer, b *B.Buffer, info fwInfo, fill byte, header string) error {
+	end := info.base + b.Size()
+	//pick the smallest address size that fits
+	var dataType, endType byte
+	var addrLen int
+	switch {
+	case end <= 1<<16:
+		dataType, endType, addrLen = 1, 9, 2
+	case end <= 1<<24:
+		dataType, endType, addrLen = 2, 8, 3
+	case end <= 1<<32:
+		dataType, endType, addrLen = 3, 7, 4
+	default:
+		return fmt.Errorf("S-record: image ends beyond 4GB (%X)", end)
+	}
+	if err := srecRecord(w, 0, 2, 0, []byte(header)); err != nil {
+		return err
+	}
+	count := 0
+	err := fwRecords(b, info.base, fill, func(addr int64, data []byte) error {
+		count++
+		return srecRecord(w, dataType, addrLen, addr, data)
+	})
+	if err != nil {
+		return err
+	}
+	switch {
+	case count <= 0xFFFF:
+		err = srecRecord(w, 5, 2, int64(count), nil)
+	case count <= 0xFFFFFF:
+		err = srecRecord(w, 6, 3, int64(count), nil)
+	}
+	if err != nil {
+		return err
+	}
+	return srecRecord(w, endType, addrLen, info.start, nil)
+}
+
+//write the buffer in the given format
+func writeFormat(w io.Writer, b *B.Buffer, format fileFormat, info fwInfo, fill byte, name string) error {
+	switch format {
+	case FormatIHex:
+		return writeIHex(w, b, info, fill)
+	case FormatSRec:
+		return writeSRec(w, b, info, fill, filepath.Base(name))
+	}
+	var err error
+	b.Iter(func(slice []byte) bool {
+		var n int
+		n, err = w.Write(slice)
+		return n != len(slice) || err != nil
+	})
+	return err
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+//the examples on wikipedia
+const (
+	ihexSample = `:10010000214601360121470136007EFE09D2190140
+:100110002146017E17C20001FF5F16002148011928
+:10012000194E79234623965778239EDA3F01B2CAA7
+:100130003F0156702B5E712B722B732146013421C7
+:00000001FF
+`
+	srecSample = `S00F000068656C6C6F202020202000003C
+S11F00007C0802A6900100049421FFF07C6C1B787C8C23783C6000003863000026
+S11F001C4BFFFFE5398000007D83637880010014382100107C0803A64E800020E9
+S111003848656C6C6F20776F726C642E0A0042
+S5030003F9
+S9030000FC
+`
+)
+
+func TestLoadFirmwareSamples(t *testing.T) {
+	tests := []struct {
+		name   string
+		format fileFormat
+		file   string
+		base   int64
+		size   int64
+		head   []byte
+		tail   []byte
+	}{
+		{"ihex", FormatIHex, ihexSample, 0x100, 64, []byte{0x21, 0x46, 0x01, 0x36}, []byte{0x46, 0x01, 0x34, 0x21}},
+		{"srec", FormatSRec, srecSample, 0, 70, []byte{0x7C, 0x08, 0x02, 0xA6}, []byte("world.\n\x00")},
+	}
+	for _, tc := range tests {
+		b, info, err := loadFirmware(strings.NewReader(tc.file), tc.format, 0xFF)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		img := contents(b)
+		if info.base != tc.base || int64(len(img)) != tc.size {
+			t.Errorf("%s: %d bytes at %X, want %d at %X", tc.name, len(img), info.base, tc.size, tc.base)
+			continue
+		}
+		if !bytes.HasPrefix(img, tc.head) || !bytes.HasSuffix(img, tc.tail) {
+			t.Errorf("%s: image % X", tc.name, img)
+		}
+	}
+}
+
+func TestParseIHex(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		base  int64
+		img   string
+		start int64 //-1 is none
+		err   string
+	}{
+		{"segment address", ":020000021000EC\n:03000000616263D7\n:00000001FF\n", 0x10000, "abc", -1, ""},
+		{"linear address", ":020000040800F2\n:03001000616263C7\n:00000001FF\n", 0x08000010, "abc", -1, ""},
+		{"start segment", ":0400000312345678E5\n:01000000619E\n:00000001FF\n", 0, "a", 0x12340 + 0x5678, ""},
+		{"start linear", ":0400000508000123CB\n:01000000619E\n:00000001FF\n", 0, "a", 0x08000123, ""},
+		{"gap", ":01000000619E\n:010004006299\n:00000001FF\n", 0, "a\xFF\xFF\xFFb", -1, ""},
+		{"overlap, the last wins", ":0200000061623B\n:010001007886\n:00000001FF\n", 0, "ax", -1, ""},
+		{"after the end", ":01000000619E\n:00000001FF\n:01000100629C\n", 0, "a", -1, ""},
+		{"blank lines", "\n:01000000619E\n\n  \n:00000001FF\n", 0, "a", -1, ""},
+		{"no colon", "01000000619E\n", 0, "", -1, "doesn't start with ':'"},
+		{"checksum", ":01000000619F\n", 0, "", -1, "checksum"},
+		{"length", ":02000000619E\n", 0, "", -1, "length"},
+		{"not hex", ":01000000zz9E\n", 0, "", -1, "line 1"},
+		{"record type", ":00000007F9\n", 0, "", -1, "unknown record type"},
+		{"bad extended address", ":0100000400FB\n", 0, "", -1, "extended linear"},
+	}
+	for _, tc := range tests {
+		b, info, err := loadFirmware(strings.NewReader(tc.file), FormatIHex, 0xFF)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := string(contents(b)); got != tc.img || info.base != tc.base {
+			t.Errorf("%s: %q at %X, want %q at %X", tc.name, got, info.base, tc.img, tc.base)
+		}
+		if info.hasStart != (tc.start >= 0) || info.hasStart && info.start != tc.start {
+			t.Errorf("%s: start %X (%v), want %X", tc.name, info.start, info.hasStart, tc.start)
+		}
+	}
+}
+
+func TestParseSRec(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		base  int64
+		img   string
+		start int64
+		err   string
+	}{
+		{"S2", "S207010000616263D1\nS804010000FA\n", 0x10000, "abc", 0x10000, ""},
+		{"S3", "S30808000010616263B9\nS70508000000F2\n", 0x08000010, "abc", 0x08000000, ""},
+		{"no start", "S1060000616263D3\n", 0, "abc", -1, ""},
+		{"no S", "1060000616263D3\n", 0, "", -1, "doesn't start with 'S'"},
+		{"checksum", "S1060000616263D4\n", 0, "", -1, "checksum"},
+		{"length", "S1070000616263D3\n", 0, "", -1, "length"},
+		{"record type", "S4060000616263D3\n", 0, "", -1, "unknown record type"},
+		{"too short", "S2030000FC\n", 0, "", -1, "too short"},
+	}
+	for _, tc := range tests {
+		b, info, err := loadFirmware(strings.NewReader(tc.file), FormatSRec, 0xFF)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := string(contents(b)); got != tc.img || info.base != tc.base {
+			t.Errorf("%s: %q at %X, want %q at %X", tc.name, got, info.base, tc.img, tc.base)
+		}
+		if info.hasStart != (tc.start >= 0) || info.hasStart && info.start != tc.start {
+			t.Errorf("%s: start %X (%v), want %X", tc.name, info.start, info.hasStart, tc.start)
+		}
+	}
+}
+
+func TestFirmwareRoundTrip(t *testing.T) {
+	counting := make([]byte, 1000)
+	for i := range counting {
+		counting[i] = byte(i)
+	}
+	//fill bytes in the middle aren't written, but come back as fill bytes
+	gappy := append(append([]byte("head"), bytes.Repeat([]byte{0xFF}, 100)...), "tail"...)
+	tests := []struct {
+		name string
+		img  []byte
+		info fwInfo
+	}{
+		{"small", []byte("hexdunk"), fwInfo{}},
+		{"counting", counting, fwInfo{base: 0x400, start: 0x404, hasStart: true}},
+		{"64K boundary", counting, fwInfo{base: 0xFF00}},
+		{"24 bit", counting, fwInfo{base: 0x123456, start: 0x123456, hasStart: true}},
+		{"32 bit", counting, fwInfo{base: 0x80000000, start: 0x80000001, hasStart: true}},
+		{"gap", gappy, fwInfo{base: 0x100}},
+		{"fill at the ends", []byte("\xFF\xFFmiddle\xFF\xFF"), fwInfo{base: 0x20}},
+		{"empty", []byte{}, fwInfo{}},
+	}
+	for _, tc := range tests {
+		for _, format := range []fileFormat{FormatIHex, FormatSRec} {
+			var out bytes.Buffer
+			if err := writeFormat(&out, B.NewMem(tc.img), format, tc.info, 0xFF, "test"); err != nil {
+				t.Errorf("%s %d: write: %v", tc.name, format, err)
+				continue
+			}
+			b, info, err := loadFirmware(&out, format, 0xFF)
+			if err != nil {
+				t.Errorf("%s %d: load: %v", tc.name, format, err)
+				continue
+			}
+			if got := contents(b); !bytes.Equal(got, tc.img) || len(tc.img) > 0 && info.base != tc.info.base {
+				t.Errorf("%s %d: % X at %X", tc.name, format, got, info.base)
+			}
+			if tc.info.hasStart && (!info.hasStart || info.start != tc.info.start) {
+				t.Errorf("%s %d: start %X (%v), want %X", tc.name, format, info.start, info.hasStart, tc.info.start)
+			}
+		}
+	}
+}
+
+func TestWriteIHexRecords(t *testing.T) {
+	var out bytes.Buffer
+	img := bytes.Repeat([]byte{0xAA}, 40)
+	writeIHex(&out, B.NewMem(img), fwInfo{base: 0xFFF0}, 0xFF)
+	//records don't cross a 64K boundary, the address is set again after it
+	want := ":020000040000FA\n" +
+		":10FFF000AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA61\n" +
+		":020000040001F9\n" +
+		":10000000AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA50\n" +
+		":08001000AAAAAAAAAAAAAAAA98\n" +
+		":00000001FF\n"
+	if out.String() != want {
+		t.Errorf("got\n%swant\n%s", out.String(), want)
+	}
+	if err := writeIHex(&out, B.NewMem(img), fwInfo{base: 1<<32 - 8}, 0xFF); err == nil {
+		t.Errorf("an image beyond 4GB was written")
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		format fileFormat
+		known  bool
+	}{
+		{"a.hex", FormatIHex, true},
+		{"dir.x/A.IHX", FormatIHex, true},
+		{"a.s19", FormatSRec, true},
+		{"a.mot", FormatSRec, true},
+		{"a.bin", FormatRaw, true},
+		{"a.txt", FormatRaw, false},
+		{"hex", FormatRaw, false},
+	}
+	for _, tc := range tests {
+		if format, known := formatFromPath(tc.path); format != tc.format || known != tc.known {
+			t.Errorf("%s: %d %v, want %d %v", tc.path, format, known, tc.format, tc.known)
+		}
+	}
+}
+
+//a .hex file that isn't Intel HEX is saved as it was loaded, a new name picks the format
+func TestSaveFormat(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.hex")
+	os.WriteFile(path, []byte("not intel hex"), 0644)
+	hf, _, err := loadHexFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hf.format != FormatRaw {
+		t.Fatalf("loaded as %d", hf.format)
+	}
+	hf.Replace(0, 3, B.NewMem([]byte("NOT")))
+	if err := hf.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "NOT intel hex" {
+		t.Errorf("saved %q", data)
+	}
+	srec := filepath.Join(dir, "notes.s19")
+	if err := hf.save(srec); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(srec); !strings.HasPrefix(string(data), "S0") {
+		t.Errorf("saved as %q", data)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+//follow mode (like tail -f): bytes appended to the file on disk are appended to the
+//buffer. Views that show the end of the file keep showing it, the cursor stays on the
+//EOF cell if it was there.
+
+import (
+	"io/fs"
+	"os"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+//only raw files on disk can grow
+func (hf *HexFile) canFollow() bool {
+	return hf.kind == KindRegular && hf.format == FormatRaw
+}
+
+//the end of the file (the EOF cell) is on screen
+func (view *ViewState) atEnd(size int64) bool {
+	return size >= view.topAddr && size < view.topAddr+view.bytesPerLine*view.linesPerScreen
+}
+
+//stats of a file that was only read up to size
+type grownInfo struct {
+	fs.FileInfo
+	size int64
+}
+
+func (gi grownInfo) Size() int64 { return gi.size }
+
+//append what was added to the file on disk, returns false if it didn't grow
+func (hf *HexFile) followGrowth() bool {
+	stats, err := os.Stat(hf.name)
+	if err != nil || stats.Size() <= hf.stats.Size() {
+		return false
+	}
+	//the new bytes are read from the file too, a buffer on it only reads what the file
+	//had when it was opened
+	grown, err := B.OpenFile(hf.name)
+	if err != nil {
+		return false
+	}
+	at := hf.stats.Size()
+	n := grown.Size() - at
+	if n <= 0 {
+		return false
+	}
+
+	end := hf.buf.Size()
+	var following []*ViewState
+	for _, tab := range HD.Tabs {
+		if tab.name == hf.name && tab.view.atEnd(end) {
+			following = append(following, tab.view)
+		}
+	}
+
+	//appended to the original too, new bytes aren't edits
+	saved := hf.version == hf.savedVersion
+	if saved && hf.snapshot == "" {
+		//the buffer is the file, start over on it: every append opens the file
+		//again, this way only the last one stays open
+		hf.buf, hf.orig = grown, grown.Copy(0, grown.Size())
+	} else {
+		hf.buf.Paste(end, grown.Copy(at, n))
+		hf.orig.Paste(hf.orig.Size(), grown.Copy(at, n))
+	}
+	hf.touch()
+	if saved {
+		hf.savedVersion = hf.version
+	}
+	hf.stats = grownInfo{stats, at + n}
+
+	size := hf.buf.Size()
+	for _, view := range following {
+		if view.cursor == end {
+			view.cursor = size
+		}
+		view.ScrollTo(size)
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+func TestFollowGrowth(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "log")
+	if err := os.WriteFile(path, []byte("line 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hf, _, err := loadHexFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hf.removeTemp()
+	appendLine := func(line string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(line)
+		f.Close()
+	}
+
+	if hf.followGrowth() {
+		t.Errorf("grew without an append")
+	}
+	appendLine("line 2\n")
+	if !hf.followGrowth() {
+		t.Fatalf("append not seen")
+	}
+	if got := string(contents(hf.buf)); got != "line 1\nline 2\n" || hf.stats.Size() != int64(len(got)) {
+		t.Errorf("after 1 append: %q, size %d", got, hf.stats.Size())
+	}
+
+	//with an edit, the new bytes are pasted after it
+	hf.Replace(0, 4, B.NewMem([]byte("LINE")))
+	appendLine("line 3\n")
+	if !hf.followGrowth() {
+		t.Fatalf("append not seen")
+	}
+	if got := string(contents(hf.buf)); got != "LINE 1\nline 2\nline 3\n" {
+		t.Errorf("after an edit: %q", got)
+	}
+	if got := string(contents(hf.orig)); got != "line 1\nline 2\nline 3\n" {
+		t.Errorf("original: %q", got)
+	}
+	if hunks := hf.Changes(); len(hunks) != 1 {
+		t.Errorf("appended bytes are changes: %+v", hunks)
+	}
+	if _, changed := hf.changedOnDisk(); changed {
+		t.Errorf("followed file is changed on disk")
+	}
+}
@@ -5,6 +5,7 @@ package main
 import (
 	"fmt"
 	"io/fs"
+	"os"
 
 	B "github.com/snhmibby/filebuf"
 )
@@ -16,6 +17,19 @@ const (
 	DialogOpen   = "Open"         //fileDialog, callback: actionOpen
 	DialogSaveAs = "Save As"      //fileDialog, callback: actionWriteFile
 	DialogGoto   = "Goto Address" //intDialog,  callback: actionGotoAddr
+	DialogFill   = "Fill Byte"    //intDialog,  callback: actionSetFillByte
+	DialogPlugin = "Load Plugin"  //fileDialog, callback: actionOpenPlugin
+	DialogScript = "Run Script"   //fileDialog, callback: actionScriptFile
+
+	DialogSavePatch  = "Save Patch"  //fileDialog, callback: binPatchWindow.writePatch
+	DialogApplyPatch = "Apply Patch" //fileDialog, callback: binPatchWindow.applyPatchFile
+
+	DialogFillData   = "Fill"           //fillDialog
+	DialogFind       = "Find All"       //findDialog
+	DialogTransform  = "Transform"      //transformDialog
+	DialogCodec      = "Encode/Decode"  //codecDialog
+	DialogExport     = "Export"         //exportDialog
+	DialogExportFile = "Export To File" //fileDialog, callback: exportDialog.writeFile
 )
 
 //should not be an opaque closure, but a struct with an action-enum.
@@ -24,6 +38,7 @@ const (
 //the program on a save, so that they don't 'change' when the file gets written)
 type Undo struct {
 	undo, redo func() (int64, int64) //return affected region
+	lost       editLoss              //restored after undo
 }
 
 //an opened file
@@ -31,8 +46,41 @@ type HexFile struct {
 	name       string
 	buf        *B.Buffer
 	dirty      bool
+	readOnly   bool //edits are refused
+	follow     bool //bytes appended on disk are appended to the buffer (see follow.go)
+	kind       fileKind
 	stats      fs.FileInfo
+	seen       fs.FileInfo //change on disk the user chose to ignore (see watch.go)
 	undo, redo []Undo
+	temp       []string //our own temporary files (stdin, decoded data), removed when the file is closed
+	snapshot   string   //private copy the buffer reads from after an outside change (see detach)
+	device     *os.File //the block device the buffer reads from, closed with the file
+	lost       editLoss //of the edits that don't have an undo entry yet
+
+	//firmware images are loaded in memory and saved as records
+	format fileFormat
+	fw     fwInfo
+
+	//process memory regions (see process.go), nil for files
+	proc *procMem
+
+	//differences with the file on disk (computed in the background)
+	orig    *B.Buffer //the file as it is on disk
+	version int       //incremented on every edit
+	changes fileChanges
+
+	//vi marks and bookmarks
+	marks fileMarks
+
+	//annotated ranges
+	annotations fileAnnotations
+
+	//hits of find all
+	search fileSearch
+
+	//the project file (annotations, bookmarks) as last loaded/saved
+	projectSaved []byte
+	savedVersion int //version of the file when it was last loaded/saved
 }
 
 //each tab is a view on an opened file
@@ -48,6 +96,7 @@ type ViewState struct {
 	bytesPerLine   int64 //number of 'dunked' bytes per line
 	linesPerScreen int64 //number of lines per screen
 	editmode       editMode
+	markKey        byte //'m', '\'' or '`' while waiting for the letter of a mark
 
 	//current selection
 	selectionStart, selectionSize int64
@@ -58,6 +107,7 @@ type ViewState struct {
 
 	//update scroll-position (communicate to imgui scrollbar during widget build)
 	shouldScroll bool
+	scrollToTop  bool //put scrollToAddr on the first line instead of just on screen
 	scrollToAddr int64
 }
 
@@ -83,12 +133,54 @@ type Globals struct {
 	//Current copy/paste buffer
 	//TODO: could be something nice, a circular buffer, named buffers, etc
 	ClipBoard *B.Buffer
+
+	//Tab to make the active one in the next frame (-1 for none)
+	SelectTab int
+
+	//Recently opened files, most recent first
+	Recent []string
+
+	//Last directory of each file dialog, by dialog id
+	DialogDirs map[string]string
+
+	//Bytes per line in the hex views, 0 fits them to the window
+	BytesPerLine int
+
+	//Gaps in loaded firmware images (Intel HEX, S-records) are filled with this byte
+	FillByte byte
+
+	//Tool windows
+	Compare     compareWindow
+	Changes     changesWindow
+	Hash        hashWindow
+	Bookmarks   bookmarksWindow
+	Annotations annotationsWindow
+	Process     processWindow
+	BinPatch    binPatchWindow
+	Entropy     entropyWindow
+	Strings     stringsWindow
+	Bitmap      bitmapWindow
+
+	//Bitmap of the active file beside the hex view
+	Minimap minimap
+
+	//Opened files changed by other programs
+	Watch fileWatcher
+
+	//Loaded plugins
+	Plugins []loadedPlugin
+
+	//Starlark console
+	Script scriptConsole
 }
 
 var HD Globals = Globals{
-	Tabs:      make([]HexTab, 0),
-	ActiveTab: -1,
-	Files:     make(map[string]*HexFile),
+	Tabs:       make([]HexTab, 0),
+	ActiveTab:  -1,
+	Files:      make(map[string]*HexFile),
+	FillByte:   0xFF,
+	SelectTab:  -1,
+	DialogDirs: make(map[string]string),
 }
 
 func ActiveTab() *HexTab {
@@ -143,6 +235,14 @@ func (st *ViewState) inSelection(addr int64) bool {
 
 func (st *ViewState) ScrollTo(addr int64) {
 	st.shouldScroll = true
+	st.scrollToTop = false
+	st.scrollToAddr = addr
+}
+
+//scroll so that addr is on the top line of the screen
+func (st *ViewState) ScrollTop(addr int64) {
+	st.shouldScroll = true
+	st.scrollToTop = true
 	st.scrollToAddr = addr
 }
 
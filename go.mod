@@ -6,6 +6,8 @@ require (
 	github.com/AllenDang/giu v0.5.7-0.20210929101140-50bb71316c51
 	github.com/AllenDang/imgui-go v1.12.1-0.20210929095526-68b309906bdc
 	github.com/snhmibby/filebuf v0.0.0-20211007205637-09d9d55bb255
+	go.starlark.net v0.0.0-20230302034142-4b1e35fe2254
+	golang.org/x/crypto v0.0.0-20211108221036-ceb1ce70b4fa
 )
 
 require (
@@ -17,4 +19,8 @@ require (
 	github.com/sahilm/fuzzy v0.1.0 // indirect
 	golang.org/x/exp v0.0.0-20210903233438-a2d0902c3ac7 // indirect
 	golang.org/x/net v0.0.0-20211029224645-99673261e6eb // indirect
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
 )
+
+//OpenReaderAt (lazy block devices) isn't upstream yet
+replace github.com/snhmibby/filebuf => ./third_party/filebuf
@@ -0,0 +1,398 @@
+package main
+
+//checksums and hashes over the selection or the entire file
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"sync"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+	B "github.com/snhmibby/filebuf"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+)
+
+//parameters of a crc (the 'Rocksoft' model, with refin == refout)
+type crcParams struct {
+	width     uint
+	poly      uint64
+	init      uint64
+	reflected bool
+	xorOut    uint64
+}
+
+var (
+	crc8SMBus      = crcParams{width: 8, poly: 0x07}
+	crc8Maxim      = crcParams{width: 8, poly: 0x31, reflected: true}
+	crc16ARC       = crcParams{width: 16, poly: 0x8005, reflected: true}
+	crc16Modbus    = crcParams{width: 16, poly: 0x8005, init: 0xFFFF, reflected: true}
+	crc16CCITT     = crcParams{width: 16, poly: 0x1021, init: 0xFFFF}
+	crc16XModem    = crcParams{width: 16, poly: 0x1021}
+	crc16Kermit    = crcParams{width: 16, poly: 0x1021, reflected: true}
+	crc32BZip2     = crcParams{width: 32, poly: 0x04C11DB7, init: 0xFFFFFFFF, xorOut: 0xFFFFFFFF}
+	crc32MPEG2     = crcParams{width: 32, poly: 0x04C11DB7, init: 0xFFFFFFFF}
+	crc32Koopman   = crc32.MakeTable(crc32.Koopman)
+	crc32Castagnol = crc32.MakeTable(crc32.Castagnoli)
+)
+
+func reflectBits(v uint64, width uint) uint64 {
+	var r uint64
+	for i := uint(0); i < width; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+//crcHash is a table driven crc of 8 to 64 bits wide
+type crcHash struct {
+	p     crcParams
+	mask  uint64
+	table [256]uint64
+	crc   uint64
+}
+
+func newCRC(p crcParams) func() hash.Hash {
+	return func() hash.Hash {
+		c := &crcHash{p: p, mask: 1<<p.width - 1}
+		if p.width == 64 {
+			c.mask = ^uint64(0)
+		}
+		for i := uint64(0); i < 256; i++ {
+			var r uint64
+			if p.reflected {
+				rpoly := reflectBits(p.poly, p.width)
+				r = i
+				for k := 0; k < 8; k++ {
+					if r&1 != 0 {
+						r = r>>1 ^ rpoly
+					} else {
+						r >>= 1
+					}
+				}
+			} else {
+				r = i << (p.width - 8)
+				top := uint64(1) << (p.width - 1)
+				for k := 0; k < 8; k++ {
+					if r&top != 0 {
+						r = r<<1 ^ p.poly
+					} else {
+						r <<= 1
+					}
+				}
+			}
+			c.table[i] = r & c.mask
+		}
+		c.Reset()
+		return c
+	}
+}
+
+func (c *crcHash) Reset() {
+	c.crc = c.p.init
+	if c.p.reflected {
+		c.crc = reflectBits(c.p.init, c.p.width)
+	}
+}
+
+func (c *crcHash) Write(p []byte) (int, error) {
+	crc := c.crc
+	if c.p.reflected {
+		for _, b := range p {
+			crc = c.table[byte(crc)^b] ^ crc>>8
+		}
+	} else {
+		shift := c.p.width - 8
+		for _, b := range p {
+			crc = (c.table[byte(crc>>shift)^b] ^ crc<<8) & c.mask
+		}
+	}
+	c.crc = crc
+	return len(p), nil
+}
+
+//big endian, like the hash/crc32 package
+func (c *crcHash) Sum(b []byte) []byte {
+	v := (c.crc ^ c.p.xorOut) & c.mask
+	for i := int(c.p.width/8) - 1; i >= 0; i-- {
+		b = append(b, byte(v>>(8*uint(i))))
+	}
+	return b
+}
+
+func (c *crcHash) Size() int      { return int(c.p.width / 8) }
+func (c *crcHash) BlockSize() int { return 1 }
+
+type hashAlgo struct {
+	name     string
+	new      func() hash.Hash
+	checksum bool //a number (crc/adler), its byte order can be swapped
+}
+
+func mustHash(f func(key []byte) (hash.Hash, error)) func() hash.Hash {
+	return func() hash.Hash {
+		h, err := f(nil)
+		if err != nil {
+			panic(err)
+		}
+		return h
+	}
+}
+
+var hashAlgos = []hashAlgo{
+	{"CRC-8/SMBus", newCRC(crc8SMBus), true},
+	{"CRC-8/Maxim", newCRC(crc8Maxim), true},
+	{"CRC-16/ARC", newCRC(crc16ARC), true},
+	{"CRC-16/Modbus", newCRC(crc16Modbus), true},
+	{"CRC-16/CCITT-False", newCRC(crc16CCITT), true},
+	{"CRC-16/XModem", newCRC(crc16XModem), true},
+	{"CRC-16/Kermit", newCRC(crc16Kermit), true},
+	{"CRC-32", func() hash.Hash { return crc32.NewIEEE() }, true},
+	{"CRC-32C (Castagnoli)", func() hash.Hash { return crc32.New(crc32Castagnol) }, true},
+	{"CRC-32K (Koopman)", func() hash.Hash { return crc32.New(crc32Koopman) }, true},
+	{"CRC-32/BZip2", newCRC(crc32BZip2), true},
+	{"CRC-32/MPEG-2", newCRC(crc32MPEG2), true},
+	{"Adler-32", func() hash.Hash { return adler32.New() }, true},
+	{"MD5", md5.New, false},
+	{"SHA-1", sha1.New, false},
+	{"SHA-256", sha256.New, false},
+	{"SHA-512", sha512.New, false},
+	{"BLAKE2s-256", mustHash(blake2s.New256), false},
+	{"BLAKE2b-256", mustHash(blake2b.New256), false},
+	{"BLAKE2b-512", mustHash(blake2b.New512), false},
+}
+
+//stream b through all hashes, progress is called for every chunk and can cancel
+func hashBuffer(b *B.Buffer, hashes []hash.Hash, progress func(done int64) bool) bool {
+	var done int64
+	canceled := false
+	b.Iter(func(p []byte) bool {
+		for _, h := range hashes {
+			h.Write(p)
+		}
+		done += int64(len(p))
+		canceled = !progress(done)
+		return canceled
+	})
+	return !canceled
+}
+
+/*
+ * Hash window
+ */
+
+type hashWindow struct {
+	open bool
+
+	selected      []bool //index in hashAlgos
+	selectionOnly bool
+	littleEndian  bool //for checksums
+	overwrite     bool //write results over the bytes at the cursor, instead of inserting
+
+	//computed in the background
+	mu       sync.Mutex
+	gen      int
+	busy     bool
+	progress float32
+	results  map[int][]byte //algorithm index -> sum
+	what     string         //description of the hashed range
+}
+
+func (hw *hashWindow) Open() {
+	hw.open = true
+	if hw.selected != nil {
+		return
+	}
+	hw.selectionOnly = true
+	hw.selected = make([]bool, len(hashAlgos))
+	for i, a := range hashAlgos {
+		if a.name == "CRC-32" || a.name == "SHA-256" {
+			hw.selected[i] = true
+		}
+	}
+}
+
+func (hw *hashWindow) start() {
+	tab := ActiveTab()
+	hf := ActiveFile()
+	if tab == nil || hf == nil {
+		return
+	}
+	off, size := int64(0), hf.buf.Size()
+	if s, n := tab.view.Selection(); hw.selectionOnly && n > 0 {
+		off, size = s, n
+	}
+	var which []int
+	var hashes []hash.Hash
+	for i, a := range hashAlgos {
+		if hw.selected[i] {
+			which = append(which, i)
+			hashes = append(hashes, a.new())
+		}
+	}
+	data := hf.buf.Copy(off, size)
+
+	hw.mu.Lock()
+	hw.gen++
+	gen := hw.gen
+	hw.busy = true
+	hw.progress = 0
+	hw.results = nil
+	hw.what = fmt.Sprintf("%s, %X-%X (%s)", hf.stats.Name(), off, off+size, mkSize(size))
+	hw.mu.Unlock()
+
+	go func() {
+		var shown float32
+		ok := hashBuffer(data, hashes, func(done int64) bool {
+			hw.mu.Lock()
+			defer hw.mu.Unlock()
+			if size > 0 {
+				hw.progress = float32(done) / float32(size)
+			}
+			if hw.progress-shown > 0.01 {
+				shown = hw.progress
+				G.Update()
+			}
+			return gen == hw.gen
+		})
+		hw.mu.Lock()
+		if ok && gen == hw.gen {
+			hw.results = make(map[int][]byte)
+			for k, i := range which {
+				hw.results[i] = hashes[k].Sum(nil)
+			}
+			hw.busy = false
+		}
+		hw.mu.Unlock()
+		G.Update()
+	}()
+}
+
+func (hw *hashWindow) cancel() {
+	hw.mu.Lock()
+	hw.gen++
+	hw.busy = false
+	hw.mu.Unlock()
+}
+
+//the result of algorithm i in the chosen byte order
+func (hw *hashWindow) sum(i int, sum []byte) []byte {
+	if !hw.littleEndian || !hashAlgos[i].checksum {
+		return sum
+	}
+	r := make([]byte, len(sum))
+	for k := range sum {
+		r[k] = sum[len(sum)-1-k]
+	}
+	return r
+}
+
+//write sum into the active file at the cursor, as 1 undo-able edit
+func (hw *hashWindow) writeAtCursor(sum []byte) {
+	tab := ActiveTab()
+	hf := ActiveFile()
+	if tab == nil || hf == nil {
+		return
+	}
+	off := tab.view.cursor
+	var size int64
+	if hw.overwrite {
+		size = int64(len(sum))
+		if off+size > hf.buf.Size() {
+			size = hf.buf.Size() - off
+		}
+	}
+	if err := hf.Replace(off, size, B.NewMem(sum)); err != nil {
+		ErrorDialog("Write hash", err.Error())
+		return
+	}
+	tab.view.SetSelection(off, int64(len(sum)))
+}
+
+func (hw *hashWindow) resultTable(results map[int][]byte) {
+	flags := I.TableFlags_RowBg | I.TableFlags_Resizable | I.TableFlags_ScrollY
+	if I.BeginTable("HashTable", 3, flags, I.ContentRegionAvail(), 0) {
+		defer I.EndTable()
+		I.TableSetupColumn("Algorithm", 0, 0, 0)
+		I.TableSetupColumn("Value", 0, 0, 0)
+		I.TableSetupColumn("", 0, 0, 0)
+		I.TableHeadersRow()
+		for i, a := range hashAlgos {
+			sum, ok := results[i]
+			if !ok {
+				continue
+			}
+			sum = hw.sum(i, sum)
+			I.TableNextRow(0, 0)
+			I.TableNextColumn()
+			I.Text(a.name)
+			I.TableNextColumn()
+			I.Text(hex.EncodeToString(sum))
+			I.TableNextColumn()
+			if I.SmallButton(fmt.Sprint("Copy##hashcopy", i)) {
+				G.Context.GetPlatform().SetClipboard(hex.EncodeToString(sum))
+			}
+			I.SameLine()
+			if I.SmallButton(fmt.Sprint("Write at Cursor##hashwrite", i)) {
+				hw.writeAtCursor(sum)
+			}
+		}
+	}
+}
+
+func drawHashWindow() {
+	hw := &HD.Hash
+	if !hw.open {
+		hw.mu.Lock()
+		busy := hw.busy
+		hw.mu.Unlock()
+		if busy {
+			hw.cancel()
+		}
+		return
+	}
+
+	hw.mu.Lock()
+	busy, progress, results, what := hw.busy, hw.progress, hw.results, hw.what
+	hw.mu.Unlock()
+
+	var algos G.Layout
+	for i, a := range hashAlgos {
+		algos = append(algos, G.Checkbox(a.name, &hw.selected[i]))
+	}
+
+	var status G.Widget = G.Label(what)
+	if busy {
+		status = G.Row(
+			G.ProgressBar(progress).Size(200, 0),
+			G.Button("Cancel").OnClick(hw.cancel),
+		)
+	}
+
+	G.Window("Hash").IsOpen(&hw.open).Pos(100, 100).Size(650, 450).Layout(
+		G.SplitLayout(G.DirectionHorizontal, true, 200,
+			algos,
+			G.Layout{
+				G.Row(
+					G.Checkbox("Selection Only", &hw.selectionOnly),
+					G.Checkbox("Little Endian Checksums", &hw.littleEndian),
+					G.Checkbox("Overwrite", &hw.overwrite),
+				),
+				G.Row(
+					G.Button("Compute").OnClick(hw.start),
+					status,
+				),
+				G.Custom(func() { hw.resultTable(results) }),
+			},
+		),
+	)
+}
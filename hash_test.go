@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"hash"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+//the check values of the crc catalogue (the checksums of "123456789")
+func TestHashCheckValues(t *testing.T) {
+	want := map[string]string{
+		"CRC-8/SMBus":          "f4",
+		"CRC-8/Maxim":          "a1",
+		"CRC-16/ARC":           "bb3d",
+		"CRC-16/Modbus":        "4b37",
+		"CRC-16/CCITT-False":   "29b1",
+		"CRC-16/XModem":        "31c3",
+		"CRC-16/Kermit":        "2189",
+		"CRC-32":               "cbf43926",
+		"CRC-32C (Castagnoli)": "e3069283",
+		"CRC-32/BZip2":         "fc891918",
+		"CRC-32/MPEG-2":        "0376e6e7",
+		"Adler-32":             "091e01de",
+		"MD5":                  "25f9e794323b453885f5181f1b624d0b",
+		"SHA-1":                "f7c3bc1d808e04732adf679965ccc34ca7ae3441",
+	}
+	for _, a := range hashAlgos {
+		sum, ok := want[a.name]
+		if !ok {
+			continue
+		}
+		delete(want, a.name)
+		//in 2 pieces, through the buffer
+		b := B.NewMem([]byte("1234"))
+		b.Paste(4, B.NewMem([]byte("56789")))
+		h := a.new()
+		hashBuffer(b, []hash.Hash{h}, func(int64) bool { return true })
+		if got := fmt.Sprintf("%x", h.Sum(nil)); got != sum {
+			t.Errorf("%s: %s, want %s", a.name, got, sum)
+		}
+	}
+	for name := range want {
+		t.Errorf("no hash %s", name)
+	}
+}
@@ -28,12 +28,30 @@ func addrLabel(addr int64, nDigits int) string {
 	return fmt.Sprintf("%0*X:", nDigits, addr)
 }
 
+//a highlighter gives the background colour of the byte at addr (if any)
+type highlighter func(addr int64) (color.RGBA, bool)
+
+//a tooltipper gives the text shown when hovering over the byte at addr ("" for none)
+type tooltipper func(addr int64) string
+
 type HexViewWidget struct {
 	state *ViewState
 
 	id     string
 	buffer *B.Buffer
 
+	highlights []highlighter
+	tooltips   []tooltipper
+	viewOnly   bool  //don't handle edit keys and the edit popup
+	readOnly   bool  //only the keys that don't edit
+	baseAddr   int64 //address shown for the first byte
+
+	//bytes that couldn't be read are shown as ??
+	unreadable func(addr int64) bool
+
+	//width of the widget, 0 fills the available width
+	outerWidth float32
+
 	width           float32
 	height          float32
 	charWidth       float32
@@ -47,6 +65,48 @@ func HexView(id string, b *B.Buffer, st *ViewState) *HexViewWidget {
 	return h
 }
 
+//add background colouring of bytes, the first matching highlighter wins
+func (h *HexViewWidget) Highlight(f highlighter) *HexViewWidget {
+	h.highlights = append(h.highlights, f)
+	return h
+}
+
+//add a tooltip to bytes, the texts of all tooltippers are shown
+func (h *HexViewWidget) Tooltip(f tooltipper) *HexViewWidget {
+	h.tooltips = append(h.tooltips, f)
+	return h
+}
+
+//show the bytes for which f is true as ?? (i.e. unmapped process memory)
+func (h *HexViewWidget) Unreadable(f func(addr int64) bool) *HexViewWidget {
+	h.unreadable = f
+	return h
+}
+
+//no edit keys or edit modes, for read-only files
+func (h *HexViewWidget) ReadOnly(ro bool) *HexViewWidget {
+	h.readOnly = ro
+	return h
+}
+
+//show addresses starting from base instead of 0 (i.e. firmware load addresses)
+func (h *HexViewWidget) BaseAddr(base int64) *HexViewWidget {
+	h.baseAddr = base
+	return h
+}
+
+//the width of the widget, to make room for something beside it
+func (h *HexViewWidget) Width(w float32) *HexViewWidget {
+	h.outerWidth = w
+	return h
+}
+
+//only display and select, the edit keys and popup are for the active tab
+func (h *HexViewWidget) ViewOnly() *HexViewWidget {
+	h.viewOnly = true
+	return h
+}
+
 func bytesPerLine(width, charwidth float32) int {
 	//to display 1 byte takes 4 characters: 2 for hexdump, 1 trailing space and 1 print
 	maxChars := int(width / (4 * charwidth))
@@ -65,18 +125,29 @@ func (h *HexViewWidget) update() {
 	sz := I.CalcTextSize("F", true, 0)
 	h.charWidth, h.charHeight = sz.X, sz.Y
 
-	size := h.buffer.Size()
+	size := h.baseAddr + h.buffer.Size()
 	nDigits := numHexDigits(size)
 	h.addressBarWidth, _ = G.CalcTextSize(addrLabel(size, nDigits))
 
 	h.state.bytesPerLine = int64(bytesPerLine(h.width-h.addressBarWidth, h.charWidth))
+	if HD.BytesPerLine > 0 {
+		h.state.bytesPerLine = int64(HD.BytesPerLine)
+	}
 	h.state.linesPerScreen = int64(h.height / h.charHeight)
 
 	h.state.topAddr = int64(I.ScrollY()/h.charHeight) * h.state.bytesPerLine
+	if h.readOnly {
+		h.state.editmode = NormalMode
+	}
 
 	if h.state.shouldScroll {
-		h.ScrollTo(h.state.scrollToAddr)
+		if h.state.scrollToTop {
+			h.setTop(h.state.scrollToAddr)
+		} else {
+			h.ScrollTo(h.state.scrollToAddr)
+		}
 		h.state.shouldScroll = false
+		h.state.scrollToTop = false
 	}
 }
 
@@ -110,6 +181,7 @@ func (h *HexViewWidget) handleKeys() {
 		G.KeyRight: func() { actionMove(+1) },
 		G.KeyL:     func() { actionMove(+1) },
 		G.KeyG:     actionGoto,
+		G.KeyN:     func() { actionFindNext(!G.IsKeyDown(G.KeyLeftShift) && !G.IsKeyDown(G.KeyRightShift)) },
 
 		//edit
 		G.KeyX: h.selectMinimal1(actionCut),
@@ -119,9 +191,23 @@ func (h *HexViewWidget) handleKeys() {
 		G.KeyO: func() { h.state.SetSelection(0, 0); h.state.editmode = OverwriteMode },
 		G.KeyU: actionUndo,
 		G.KeyR: actionRedo,
+
+		//marks, the letter follows
+		G.KeyM:           func() { h.state.markKey = 'm' },
+		G.KeyApostrophe:  func() { h.state.markKey = '\'' },
+		G.KeyGraveAccent: func() { h.state.markKey = '`' },
+	}
+	if h.readOnly {
+		for _, k := range []G.Key{G.KeyX, G.KeyP, G.KeyI, G.KeyO, G.KeyU, G.KeyR} {
+			delete(keymap, k)
+		}
 	}
 	//other modes are handled by the edit-input-widget in the hex dump
-	if h.state.editmode == NormalMode && G.IsWindowFocused(G.FocusedFlagsNone) {
+	if h.state.editmode == NormalMode && !h.viewOnly && G.IsWindowFocused(G.FocusedFlagsNone) {
+		if h.state.markKey != 0 {
+			h.handleMarkKey()
+			return
+		}
 		for k, f := range keymap {
 			if G.IsKeyPressed(k) {
 				f()
@@ -130,6 +216,26 @@ func (h *HexViewWidget) handleKeys() {
 	}
 }
 
+//the letter after m (set mark) or ' and ` (jump to mark)
+func (h *HexViewWidget) handleMarkKey() {
+	if G.IsKeyPressed(G.KeyEscape) {
+		h.state.markKey = 0
+		return
+	}
+	for k := G.KeyA; k <= G.KeyZ; k++ {
+		if G.IsKeyPressed(k) {
+			c := byte('a' + k - G.KeyA)
+			if h.state.markKey == 'm' {
+				actionSetMark(c)
+			} else {
+				actionJumpMark(c)
+			}
+			h.state.markKey = 0
+			return
+		}
+	}
+}
+
 func printByte(b byte) string {
 	if unicode.IsGraphic(rune(b)) {
 		return string(b)
@@ -190,6 +296,14 @@ func (h *HexViewWidget) printBG(addr int64, cursorw, selectw int) {
 	canvas := G.GetCanvas()
 	pos := G.GetCursorScreenPos()
 
+	for _, hl := range h.highlights {
+		if bg, ok := hl(addr); ok {
+			rect := image.Pt(selectw*int(h.charWidth), int(h.charHeight))
+			canvas.AddRectFilled(pos, pos.Add(rect), bg, 0, 0)
+			break
+		}
+	}
+
 	if addr == h.state.cursor {
 		cursorBG := color.RGBA{R: 255, G: 100, B: 000, A: 255}
 		if h.state.editmode != NormalMode {
@@ -239,6 +353,7 @@ func (h *HexViewWidget) BuildCell(addr int64, txt string) {
 	if !G.IsItemHovered() {
 		return
 	}
+	h.showTooltip(addr)
 	if h.state.dragging {
 		h.updateSelection(addr)
 	}
@@ -266,6 +381,25 @@ func (h *HexViewWidget) BuildCell(addr int64, txt string) {
 	}
 }
 
+func (h *HexViewWidget) isUnreadable(addr int64) bool {
+	return h.unreadable != nil && addr < h.buffer.Size() && h.unreadable(addr)
+}
+
+func (h *HexViewWidget) showTooltip(addr int64) {
+	var tip string
+	for _, f := range h.tooltips {
+		if s := f(addr); s != "" {
+			if tip != "" {
+				tip += "\n"
+			}
+			tip += s
+		}
+	}
+	if tip != "" && !h.state.dragging {
+		I.SetTooltip(tip)
+	}
+}
+
 //to be called from Build() function, prints hexdump of byte and handles keyclicks and such
 func (h *HexViewWidget) BuildHexCell(addr int64, b byte) {
 	var hex string
@@ -277,6 +411,8 @@ func (h *HexViewWidget) BuildHexCell(addr int64, b byte) {
 	}
 	if addr == endAddr {
 		hex = "   "
+	} else if h.isUnreadable(addr) {
+		hex = "?? "
 	} else {
 		hex = fmt.Sprintf("%02X ", b)
 	}
@@ -286,15 +422,22 @@ func (h *HexViewWidget) BuildHexCell(addr int64, b byte) {
 //to be called from Build() function, prints readable interpretation of byte and handles keyclicks and such
 func (h *HexViewWidget) BuildStrCell(addr int64, b byte) {
 	str := printByte(b)
+	if h.isUnreadable(addr) {
+		str = "?"
+	}
 	h.BuildCell(addr, str)
 }
 
 func (h *HexViewWidget) Build() {
 	//use a child widget with NoMove flags, so that dragging events gets passed to the
 	//widget, instead of dragging the window
-	G.Child().Border(false).Flags(G.WindowFlagsNoMove).Layout(
+	var popup G.Widget = G.ContextMenu().Layout(menuEdit())
+	if h.viewOnly {
+		popup = G.Layout{}
+	}
+	G.Child().Border(false).Size(h.outerWidth, 0).Flags(G.WindowFlagsNoMove).Layout(
 		G.Custom(h.printWidget),
-		G.ContextMenu().Layout(menuEdit()),
+		popup,
 	).Build()
 }
 
@@ -356,7 +499,7 @@ func (h *HexViewWidget) printInsertDump() {
 	//print the hex dump using a listclipper
 	numLines := (h.buffer.Size() + h.state.bytesPerLine - 1) / h.state.bytesPerLine
 	lineBuffer := make([]byte, int(h.state.bytesPerLine)) //buffer to read the bytes for 1 line
-	maxAddr := numHexDigits(h.buffer.Size())              //saved for printing address
+	maxAddr := numHexDigits(h.baseAddr + h.buffer.Size()) //saved for printing address
 
 	var seenCursor = false //the input-handling means
 	var clip I.ListClipper
@@ -384,7 +527,7 @@ func (h *HexViewWidget) printInsertDump() {
 
 			//address
 			I.TableNextColumn()
-			I.Text(addrLabel(offs, maxAddr))
+			I.Text(addrLabel(h.baseAddr+offs, maxAddr))
 
 			//hex dump
 			var cursorOffset = 0 //if the cursor is in this line, linuBuffer reads should offset
@@ -446,7 +589,7 @@ func (h *HexViewWidget) printOverWriteDump() {
 	//print the hex dump using a listclipper
 	numLines := (h.buffer.Size() + h.state.bytesPerLine - 1) / h.state.bytesPerLine
 	lineBuffer := make([]byte, int(h.state.bytesPerLine)) //buffer to read the bytes for 1 line
-	maxAddr := numHexDigits(h.buffer.Size())              //saved for printing address
+	maxAddr := numHexDigits(h.baseAddr + h.buffer.Size()) //saved for printing address
 
 	var seenCursor = false //the input-handling means
 	var clip I.ListClipper
@@ -470,7 +613,7 @@ func (h *HexViewWidget) printOverWriteDump() {
 
 			//address
 			I.TableNextColumn()
-			I.Text(addrLabel(offs, maxAddr))
+			I.Text(addrLabel(h.baseAddr+offs, maxAddr))
 
 			//hex dump
 			I.TableNextColumn()
@@ -516,7 +659,7 @@ func (h *HexViewWidget) printNormalDump() {
 	//print the hex dump using a listclipper
 	numLines := (h.buffer.Size() + h.state.bytesPerLine - 1) / h.state.bytesPerLine
 	lineBuffer := make([]byte, int(h.state.bytesPerLine)) //buffer to read the bytes for 1 line
-	maxAddr := numHexDigits(h.buffer.Size())              //saved for printing address
+	maxAddr := numHexDigits(h.baseAddr + h.buffer.Size()) //saved for printing address
 
 	var clip I.ListClipper
 	//dumb hack: do numlines + 10 because on big files, the last few lines get chopped off
@@ -539,7 +682,7 @@ func (h *HexViewWidget) printNormalDump() {
 
 			//address
 			I.TableNextColumn()
-			I.Text(addrLabel(offs, maxAddr))
+			I.Text(addrLabel(h.baseAddr+offs, maxAddr))
 
 			//hex dump
 			I.TableNextColumn()
@@ -576,18 +719,25 @@ func (h *HexViewWidget) ScrollTo(addr int64) {
 	bpl := h.state.bytesPerLine
 	top := h.state.topAddr
 	switch {
-	case h.state.cursor < top:
+	case addr < top:
 		//scroll up, addr should be in the first line
 		//make first line the one that contains addr
-		top = (h.state.cursor / bpl) * bpl
+		top = (addr / bpl) * bpl
 
-	case h.state.cursor > top+bpl*h.state.linesPerScreen:
+	case addr > top+bpl*h.state.linesPerScreen:
 		//scroll down, addr should be in the last line
-		a := h.state.cursor - h.state.linesPerScreen*bpl
+		a := addr - h.state.linesPerScreen*bpl
 		top = ((a + bpl - 1) / bpl) * bpl
 	default:
 		//addr is already on screen
 	}
+	h.setTop(top)
+}
+
+//make the line containing addr the first line on screen
+func (h *HexViewWidget) setTop(addr int64) {
+	bpl := h.state.bytesPerLine
+	top := (addr / bpl) * bpl
 	/* clamp address */
 	if top < 0 {
 		top = 0
@@ -0,0 +1,502 @@
+package main
+
+//the LZ4 frame format (the format of the lz4 command), streaming.
+//the writer makes independent 64KB blocks with a content checksum, the reader reads
+//all frames except the legacy format and frames that need a dictionary.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+const (
+	lz4Magic          = 0x184D2204
+	lz4LegacyMagic    = 0x184C2102
+	lz4SkippableMagic = 0x184D2A50 //to 0x184D2A5F
+	lz4BlockSize      = 64 << 10   //blocks made by the writer
+	lz4Window         = 64 << 10   //max match offset
+
+	lz4MinMatch     = 4
+	lz4MatchLimit   = 12 //the last match starts at least this far from the end of a block
+	lz4LastLiterals = 5  //the last bytes of a block are literals
+	lz4HashLog      = 14
+)
+
+var errLZ4Corrupt = errors.New("lz4: corrupt data")
+
+/*
+ * xxHash32, the checksum of the lz4 format
+ */
+
+const (
+	xxPrime1 uint32 = 2654435761
+	xxPrime2 uint32 = 2246822519
+	xxPrime3 uint32 = 3266489917
+	xxPrime4 uint32 = 668265263
+	xxPrime5 uint32 = 374761393
+)
+
+type xxh32 struct {
+	v     [4]uint32
+	buf   [16]byte
+	n     int //bytes in buf
+	total uint64
+}
+
+func newXXH32() *xxh32 {
+	p1, p2 := xxPrime1, xxPrime2 //wrap around, the constants would overflow
+	x := new(xxh32)
+	x.v = [4]uint32{p1 + p2, p2, 0, -p1}
+	return x
+}
+
+func xxRound(acc, in uint32) uint32 {
+	return bits.RotateLeft32(acc+in*xxPrime2, 13) * xxPrime1
+}
+
+func (x *xxh32) stripe(p []byte) {
+	for i := range x.v {
+		x.v[i] = xxRound(x.v[i], binary.LittleEndian.Uint32(p[4*i:]))
+	}
+}
+
+func (x *xxh32) Write(p []byte) (int, error) {
+	n := len(p)
+	x.total += uint64(n)
+	if x.n > 0 {
+		k := copy(x.buf[x.n:], p)
+		x.n += k
+		p = p[k:]
+		if x.n < len(x.buf) {
+			return n, nil
+		}
+		x.stripe(x.buf[:])
+		x.n = 0
+	}
+	for ; len(p) >= 16; p = p[16:] {
+		x.stripe(p)
+	}
+	x.n = copy(x.buf[:], p)
+	return n, nil
+}
+
+func (x *xxh32) Sum32() uint32 {
+	var h uint32
+	if x.total >= 16 {
+		h = bits.RotateLeft32(x.v[0], 1) + bits.RotateLeft32(x.v[1], 7) +
+			bits.RotateLeft32(x.v[2], 12) + bits.RotateLeft32(x.v[3], 18)
+	} else {
+		h = xxPrime5 //the seed (0) + prime 5
+	}
+	h += uint32(x.total)
+	p := x.buf[:x.n]
+	for ; len(p) >= 4; p = p[4:] {
+		h += binary.LittleEndian.Uint32(p) * xxPrime3
+		h = bits.RotateLeft32(h, 17) * xxPrime4
+	}
+	for _, b := range p {
+		h += uint32(b) * xxPrime5
+		h = bits.RotateLeft32(h, 11) * xxPrime1
+	}
+	h ^= h >> 15
+	h *= xxPrime2
+	h ^= h >> 13
+	h *= xxPrime3
+	h ^= h >> 16
+	return h
+}
+
+func xxSum32(p []byte) uint32 {
+	x := newXXH32()
+	x.Write(p)
+	return x.Sum32()
+}
+
+/*
+ * blocks
+ */
+
+//decode a block, appended to dst (which holds the history for matches)
+func lz4DecodeBlock(dst, src []byte, max int) ([]byte, error) {
+	start := len(dst)
+	length := func(i, n int) (int, int, error) {
+		if n < 15 {
+			return i, n, nil
+		}
+		for {
+			if i >= len(src) {
+				return 0, 0, errLZ4Corrupt
+			}
+			b := src[i]
+			i++
+			n += int(b)
+			if b != 255 {
+				return i, n, nil
+			}
+		}
+	}
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		var lit int
+		var err error
+		if i, lit, err = length(i, int(token>>4)); err != nil {
+			return nil, err
+		}
+		if lit > len(src)-i || len(dst)-start+lit > max {
+			return nil, errLZ4Corrupt
+		}
+		dst = append(dst, src[i:i+lit]...)
+		i += lit
+		if i == len(src) {
+			break //the last sequence has no match
+		}
+		if i+2 > len(src) {
+			return nil, errLZ4Corrupt
+		}
+		off := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		var ml int
+		if i, ml, err = length(i, int(token&15)); err != nil {
+			return nil, err
+		}
+		ml += lz4MinMatch
+		if off == 0 || off > len(dst) || len(dst)-start+ml > max {
+			return nil, errLZ4Corrupt
+		}
+		pos := len(dst) - off
+		if off >= ml {
+			dst = append(dst, dst[pos:pos+ml]...)
+		} else {
+			//the match overlaps what it writes (a repeating pattern)
+			for k := 0; k < ml; k++ {
+				dst = append(dst, dst[pos+k])
+			}
+		}
+	}
+	return dst, nil
+}
+
+func lz4PutLength(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+//a sequence of literals followed by a match (ml 0 for the last literals of a block)
+func lz4PutSequence(dst, lit []byte, off, ml int) []byte {
+	token := byte(15)
+	if len(lit) < 15 {
+		token = byte(len(lit))
+	}
+	token <<= 4
+	if ml > 0 {
+		if ml-lz4MinMatch < 15 {
+			token |= byte(ml - lz4MinMatch)
+		} else {
+			token |= 15
+		}
+	}
+	dst = append(dst, token)
+	if len(lit) >= 15 {
+		dst = lz4PutLength(dst, len(lit)-15)
+	}
+	dst = append(dst, lit...)
+	if ml > 0 {
+		dst = append(dst, byte(off), byte(off>>8))
+		if ml-lz4MinMatch >= 15 {
+			dst = lz4PutLength(dst, ml-lz4MinMatch-15)
+		}
+	}
+	return dst
+}
+
+//greedy compression of an independent block, table is scratch space
+func lz4CompressBlock(dst, src []byte, table []int32) []byte {
+	for i := range table {
+		table[i] = -1
+	}
+	anchor := 0
+	for i := 0; i+lz4MatchLimit < len(src); {
+		seq := binary.LittleEndian.Uint32(src[i:])
+		h := (seq * xxPrime1) >> (32 - lz4HashLog)
+		ref := int(table[h])
+		table[h] = int32(i)
+		if ref < 0 || i-ref > lz4Window-1 || binary.LittleEndian.Uint32(src[ref:]) != seq {
+			i++
+			continue
+		}
+		ml := lz4MinMatch
+		for i+ml < len(src)-lz4LastLiterals && src[ref+ml] == src[i+ml] {
+			ml++
+		}
+		dst = lz4PutSequence(dst, src[anchor:i], i-ref, ml)
+		i += ml
+		anchor = i
+	}
+	return lz4PutSequence(dst, src[anchor:], 0, 0)
+}
+
+/*
+ * frames
+ */
+
+type lz4Reader struct {
+	r   io.Reader
+	err error
+
+	inFrame  bool
+	blockMax int
+	checksum bool   //blocks have checksums
+	size     int64  //content size, -1 if unknown
+	content  *xxh32 //content checksum, nil if there is none
+	read     int64  //decoded bytes of the frame
+	hist     []byte //the window of the last decoded bytes + the decoded block
+	out      []byte //decoded bytes not returned yet (the end of hist)
+	tmp      []byte
+}
+
+func newLZ4Reader(r io.Reader) *lz4Reader {
+	return &lz4Reader{r: r}
+}
+
+func (z *lz4Reader) readUint32() (uint32, error) {
+	var b [4]byte
+	_, err := io.ReadFull(z.r, b[:])
+	return binary.LittleEndian.Uint32(b[:]), err
+}
+
+//read a frame header, returns io.EOF at the end of the input
+func (z *lz4Reader) readHeader() error {
+	for {
+		magic, err := z.readUint32()
+		if err == io.ErrUnexpectedEOF {
+			return errLZ4Corrupt
+		} else if err != nil {
+			return err
+		}
+		if magic&0xFFFFFFF0 != lz4SkippableMagic {
+			if magic == lz4LegacyMagic {
+				return fmt.Errorf("lz4: the legacy format isn't supported")
+			} else if magic != lz4Magic {
+				return fmt.Errorf("lz4: not an lz4 frame")
+			}
+			break
+		}
+		n, err := z.readUint32()
+		if err != nil {
+			return errLZ4Corrupt
+		}
+		if _, err := io.CopyN(io.Discard, z.r, int64(n)); err != nil {
+			return errLZ4Corrupt
+		}
+	}
+
+	desc := make([]byte, 2, 15)
+	if _, err := io.ReadFull(z.r, desc); err != nil {
+		return errLZ4Corrupt
+	}
+	flg, bd := desc[0], desc[1]
+	if flg>>6 != 1 {
+		return fmt.Errorf("lz4: unknown version %d", flg>>6)
+	}
+	if flg&1 != 0 {
+		return fmt.Errorf("lz4: frames with a dictionary aren't supported")
+	}
+	switch (bd >> 4) & 7 {
+	case 4:
+		z.blockMax = 64 << 10
+	case 5:
+		z.blockMax = 256 << 10
+	case 6:
+		z.blockMax = 1 << 20
+	case 7:
+		z.blockMax = 4 << 20
+	default:
+		return errLZ4Corrupt
+	}
+	n := 1 //header checksum
+	if flg&8 != 0 {
+		n += 8
+	}
+	desc = desc[:2+n]
+	if _, err := io.ReadFull(z.r, desc[2:]); err != nil {
+		return errLZ4Corrupt
+	}
+	if byte(xxSum32(desc[:len(desc)-1])>>8) != desc[len(desc)-1] {
+		return fmt.Errorf("lz4: bad header checksum")
+	}
+	z.size = -1
+	if flg&8 != 0 {
+		z.size = int64(binary.LittleEndian.Uint64(desc[2:]))
+	}
+	z.checksum = flg&16 != 0
+	z.content = nil
+	if flg&4 != 0 {
+		z.content = newXXH32()
+	}
+	z.read = 0
+	z.hist = z.hist[:0]
+	z.inFrame = true
+	return nil
+}
+
+//the end of a frame: check the content size and checksum
+func (z *lz4Reader) endFrame() error {
+	z.inFrame = false
+	if z.size >= 0 && z.read != z.size {
+		return fmt.Errorf("lz4: the content is %d bytes instead of %d", z.read, z.size)
+	}
+	if z.content != nil {
+		sum, err := z.readUint32()
+		if err != nil {
+			return errLZ4Corrupt
+		}
+		if sum != z.content.Sum32() {
+			return fmt.Errorf("lz4: bad checksum")
+		}
+	}
+	return nil
+}
+
+//decode the next block into z.out
+func (z *lz4Reader) readBlock() error {
+	if !z.inFrame {
+		if err := z.readHeader(); err != nil {
+			return err
+		}
+	}
+	n, err := z.readUint32()
+	if err != nil {
+		return errLZ4Corrupt
+	}
+	if n == 0 {
+		return z.endFrame()
+	}
+	raw := n&(1<<31) != 0
+	n &^= 1 << 31
+	if int(n) > z.blockMax {
+		return errLZ4Corrupt
+	}
+	if cap(z.tmp) < int(n) {
+		z.tmp = make([]byte, n)
+	}
+	data := z.tmp[:n]
+	if _, err := io.ReadFull(z.r, data); err != nil {
+		return errLZ4Corrupt
+	}
+	if z.checksum {
+		sum, err := z.readUint32()
+		if err != nil {
+			return errLZ4Corrupt
+		}
+		if sum != xxSum32(data) {
+			return fmt.Errorf("lz4: bad block checksum")
+		}
+	}
+
+	//keep the last window of the output for the matches of linked blocks
+	if len(z.hist) > lz4Window {
+		z.hist = append(z.hist[:0], z.hist[len(z.hist)-lz4Window:]...)
+	}
+	start := len(z.hist)
+	if raw {
+		z.hist = append(z.hist, data...)
+	} else if z.hist, err = lz4DecodeBlock(z.hist, data, z.blockMax); err != nil {
+		return err
+	}
+	z.out = z.hist[start:]
+	z.read += int64(len(z.out))
+	if z.content != nil {
+		z.content.Write(z.out)
+	}
+	return nil
+}
+
+func (z *lz4Reader) Read(p []byte) (int, error) {
+	for len(z.out) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.readBlock()
+	}
+	n := copy(p, z.out)
+	z.out = z.out[n:]
+	return n, nil
+}
+
+type lz4Writer struct {
+	w       io.Writer
+	started bool
+	buf     []byte //input for the next block
+	content *xxh32
+	table   []int32
+	out     []byte
+}
+
+func newLZ4Writer(w io.Writer) *lz4Writer {
+	return &lz4Writer{
+		w:       w,
+		content: newXXH32(),
+		buf:     make([]byte, 0, lz4BlockSize),
+		out:     make([]byte, 4, lz4BlockSize+lz4BlockSize/255+16), //size + worst case block
+	}
+}
+
+func (z *lz4Writer) writeBlock() error {
+	if !z.started {
+		//version 1, independent blocks, content checksum, 64KB blocks
+		hdr := []byte{0x04, 0x22, 0x4D, 0x18, 0x64, 0x40, 0}
+		hdr[6] = byte(xxSum32(hdr[4:6]) >> 8)
+		if _, err := z.w.Write(hdr); err != nil {
+			return err
+		}
+		z.started = true
+		z.table = make([]int32, 1<<lz4HashLog)
+	}
+	if len(z.buf) == 0 {
+		return nil
+	}
+	z.content.Write(z.buf)
+	z.out = lz4CompressBlock(z.out[:4], z.buf, z.table)
+	size := uint32(len(z.out) - 4)
+	if len(z.out)-4 >= len(z.buf) {
+		//incompressible, stored as is
+		z.out = append(z.out[:4], z.buf...)
+		size = uint32(len(z.buf)) | 1<<31
+	}
+	binary.LittleEndian.PutUint32(z.out, size)
+	z.buf = z.buf[:0]
+	_, err := z.w.Write(z.out)
+	return err
+}
+
+func (z *lz4Writer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		k := copy(z.buf[len(z.buf):cap(z.buf)], p)
+		z.buf = z.buf[:len(z.buf)+k]
+		p = p[k:]
+		if len(z.buf) == cap(z.buf) {
+			if err := z.writeBlock(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+//write the last block and the end of the frame
+func (z *lz4Writer) Close() error {
+	if err := z.writeBlock(); err != nil {
+		return err
+	}
+	var end [8]byte
+	binary.LittleEndian.PutUint32(end[4:], z.content.Sum32())
+	_, err := z.w.Write(end[:])
+	return err
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os/exec"
+	"testing"
+)
+
+//"hexdunk hexdunk hexdunk hexdunk hexdunk hexdunk!\n" made by the lz4 command (v1.9.4)
+var (
+	lz4Text = []byte("hexdunk hexdunk hexdunk hexdunk hexdunk hexdunk!\n")
+
+	//lz4 -c
+	lz4TextFrame = []byte{
+		0x04, 0x22, 0x4d, 0x18, 0x64, 0x40, 0xa7, 0x12, 0x00, 0x00, 0x00, 0x8f,
+		0x68, 0x65, 0x78, 0x64, 0x75, 0x6e, 0x6b, 0x20, 0x08, 0x00, 0x11, 0x50,
+		0x75, 0x6e, 0x6b, 0x21, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x3b, 0x3f, 0x9e,
+		0x3c,
+	}
+	//lz4 -c -BX -BD --content-size: block checksums, linked blocks and the size
+	lz4TextFrameX = []byte{
+		0x04, 0x22, 0x4d, 0x18, 0x7c, 0x40, 0x31, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x64, 0x12, 0x00, 0x00, 0x00, 0x8f, 0x68, 0x65, 0x78, 0x64,
+		0x75, 0x6e, 0x6b, 0x20, 0x08, 0x00, 0x11, 0x50, 0x75, 0x6e, 0x6b, 0x21,
+		0x0a, 0xea, 0xab, 0x15, 0x49, 0x00, 0x00, 0x00, 0x00, 0x3b, 0x3f, 0x9e,
+		0x3c,
+	}
+	//"ab", too short to compress: a raw block
+	lz4RawFrame = []byte{
+		0x04, 0x22, 0x4d, 0x18, 0x64, 0x40, 0xa7, 0x02, 0x00, 0x00, 0x80, 0x61,
+		0x62, 0x00, 0x00, 0x00, 0x00, 0x53, 0xfc, 0x99, 0x49,
+	}
+)
+
+func lz4Decode(data []byte) ([]byte, error) {
+	return io.ReadAll(newLZ4Reader(bytes.NewReader(data)))
+}
+
+func lz4Encode(data []byte) []byte {
+	var out bytes.Buffer
+	z := newLZ4Writer(&out)
+	z.Write(data)
+	z.Close()
+	return out.Bytes()
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+//test data: random bytes, runs, text and repeats further away than the window
+func lz4Samples() map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 200<<10)
+	rng.Read(random)
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog. "), 5000)
+	mixed := make([]byte, 0, 300<<10)
+	for len(mixed) < 300<<10 {
+		if rng.Intn(2) == 0 {
+			mixed = append(mixed, bytes.Repeat([]byte{byte(rng.Intn(256))}, rng.Intn(300))...)
+		} else {
+			mixed = append(mixed, random[:rng.Intn(1000)]...)
+		}
+	}
+	return map[string][]byte{
+		"empty":   {},
+		"byte":    {42},
+		"short":   []byte("abcdabcdabcd"),
+		"zeroes":  make([]byte, 1<<20),
+		"random":  random,
+		"text":    text,
+		"mixed":   mixed,
+		"far":     concat(random[:100<<10], random[:100<<10]),
+		"15+":     concat(random[:15], bytes.Repeat([]byte{7}, 19), random[:270]),
+		"blockEq": bytes.Repeat([]byte("0123456789abcdef"), lz4BlockSize/16),
+	}
+}
+
+func TestXXH32(t *testing.T) {
+	tests := []struct {
+		in  string
+		sum uint32
+	}{
+		{"", 0x02CC5D05},
+		{"a", 0x550D7456},
+		{"abc", 0x32D153FF},
+		{"Nobody inspects the spammish repetition", 0xE2293B2F},
+	}
+	for _, tc := range tests {
+		if sum := xxSum32([]byte(tc.in)); sum != tc.sum {
+			t.Errorf("xxh32(%q) = %08X, want %08X", tc.in, sum, tc.sum)
+		}
+		//written in pieces it is the same
+		x := newXXH32()
+		for i := 0; i < len(tc.in); i++ {
+			x.Write([]byte(tc.in[i : i+1]))
+		}
+		if sum := x.Sum32(); sum != tc.sum {
+			t.Errorf("xxh32(%q) byte by byte = %08X, want %08X", tc.in, sum, tc.sum)
+		}
+	}
+}
+
+func TestLZ4KnownFrames(t *testing.T) {
+	skippable := []byte{0x5A, 0x2A, 0x4D, 0x18, 3, 0, 0, 0, 1, 2, 3}
+	tests := []struct {
+		name  string
+		frame []byte
+		want  []byte
+	}{
+		{"default", lz4TextFrame, lz4Text},
+		{"checksums", lz4TextFrameX, lz4Text},
+		{"raw block", lz4RawFrame, []byte("ab")},
+		{"2 frames", concat(lz4TextFrame, lz4RawFrame), concat(lz4Text, []byte("ab"))},
+		{"skippable", concat(skippable, lz4RawFrame, skippable), []byte("ab")},
+	}
+	for _, tc := range tests {
+		got, err := lz4Decode(tc.frame)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if !bytes.Equal(got, tc.want) {
+			t.Errorf("%s: decoded %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLZ4Corrupt(t *testing.T) {
+	flip := func(frame []byte, i int) []byte {
+		c := append([]byte(nil), frame...)
+		c[i] ^= 1
+		return c
+	}
+	//one byte less than the content, with a valid header checksum
+	size := flip(lz4TextFrameX, 6)
+	size[14] = byte(xxSum32(size[4:14]) >> 8)
+
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{"truncated", lz4TextFrame[:20]},
+		{"no end mark", lz4TextFrame[:len(lz4TextFrame)-8]},
+		{"header checksum", flip(lz4TextFrame, 6)},
+		{"content checksum", flip(lz4TextFrame, len(lz4TextFrame)-1)},
+		{"block checksum", flip(lz4TextFrameX, 37)},
+		{"content size", size},
+		{"match offset", flip(lz4TextFrame, 21)},
+		{"not lz4", []byte("hexdunk hexdunk")},
+		{"legacy", []byte{0x02, 0x21, 0x4C, 0x18, 0, 0, 0, 0}},
+	}
+	for _, tc := range tests {
+		if _, err := lz4Decode(tc.frame); err == nil {
+			t.Errorf("%s: no error", tc.name)
+		}
+	}
+}
+
+func TestLZ4RoundTrip(t *testing.T) {
+	for name, data := range lz4Samples() {
+		frame := lz4Encode(data)
+		got, err := lz4Decode(frame)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if !bytes.Equal(got, data) {
+			t.Errorf("%s: %d bytes decoded differ from the %d encoded", name, len(got), len(data))
+		}
+		//the same through small writes and reads
+		var out bytes.Buffer
+		z := newLZ4Writer(&out)
+		for p := data; len(p) > 0; {
+			n := 1 + len(p)%7777
+			if n > len(p) {
+				n = len(p)
+			}
+			z.Write(p[:n])
+			p = p[n:]
+		}
+		z.Close()
+		if !bytes.Equal(out.Bytes(), frame) {
+			t.Errorf("%s: small writes give another frame", name)
+		}
+		r := newLZ4Reader(bytes.NewReader(frame))
+		var small []byte
+		buf := make([]byte, 1000)
+		for {
+			n, err := r.Read(buf)
+			small = append(small, buf[:n]...)
+			if err != nil {
+				break
+			}
+		}
+		if !bytes.Equal(small, data) {
+			t.Errorf("%s: small reads differ", name)
+		}
+	}
+}
+
+//frames made by the lz4 command decode, and the lz4 command decodes ours
+func TestLZ4Command(t *testing.T) {
+	lz4, err := exec.LookPath("lz4")
+	if err != nil {
+		t.Skip("no lz4 command")
+	}
+	run := func(in []byte, args ...string) []byte {
+		cmd := exec.Command(lz4, args...)
+		cmd.Stdin = bytes.NewReader(in)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("lz4 %v: %v", args, err)
+		}
+		return out
+	}
+	for name, data := range lz4Samples() {
+		for _, args := range [][]string{{"-c", "-q"}, {"-c", "-q", "-9", "-BD", "-B4", "-BX", "--content-size"}} {
+			got, err := lz4Decode(run(data, args...))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("%s: lz4 %v frame decodes wrong (%v)", name, args, err)
+			}
+		}
+		if got := run(lz4Encode(data), "-d", "-c", "-q"); !bytes.Equal(got, data) {
+			t.Errorf("%s: lz4 -d decodes our frame wrong", name)
+		}
+	}
+}
@@ -1,27 +1,169 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
 	G "github.com/AllenDang/giu"
 	//I "github.com/AllenDang/imgui-go"
 )
 
+//command line options
+var (
+	flagReadOnly = flag.Bool("r", false, "open the files read-only")
+	flagOffset   = flag.String("offset", "0", "put the cursor at this address (i.e. 0x1234)")
+	flagBPL      = flag.Int("bpl", 0, "bytes per line (default: fit to the window)")
+	flagScript   = flag.String("script", "", "run this script on the files and save them, without the gui")
+
+	cmdFiles  []string //files to open, stdin is read into a temporary file
+	cmdStdin  string   //that temporary file
+	cmdOffset int64
+)
+
+//restore the last session, or open the files from the command line
+func startup() {
+	HD.Watch.start()
+	if err := restoreSession(len(cmdFiles) == 0); err != nil {
+		ErrorDialog("Restoring Session", err.Error())
+	}
+	for i, p := range cmdFiles {
+		hf, err := OpenHexFile(p)
+		if err != nil {
+			ErrorDialog(fmt.Sprintf("Opening File <%s>", p), err.Error())
+			continue
+		}
+		if p == cmdStdin && len(hf.temp) == 0 {
+			hf.temp = append(hf.temp, p)
+		}
+		if *flagReadOnly {
+			hf.readOnly = true
+		}
+		tab := &HD.Tabs[len(HD.Tabs)-1]
+		addr := cmdOffset - hf.fw.base
+		hf.ClampAddr(&addr)
+		tab.view.cursor = addr
+		tab.view.ScrollTo(addr)
+		if i == 0 {
+			HD.SelectTab = len(HD.Tabs) - 1
+		}
+	}
+}
+
+//copy stdin into a temporary file, so it can be opened like any other file
+func readStdin() (string, error) {
+	f, err := os.CreateTemp("", "stdin*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, os.Stdin)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func parseCommandLine() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-r] [-offset addr] [-bpl n] [-script file] [file ...]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s patch command args... file... (see %s patch -h)\n", os.Args[0], os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "a file named - is read from stdin\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var err error
+	cmdOffset, err = strconv.ParseInt(*flagOffset, 0, 64)
+	if err != nil || cmdOffset < 0 {
+		fmt.Fprintf(os.Stderr, "bad offset: %s\n", *flagOffset)
+		os.Exit(2)
+	}
+	if *flagBPL < 0 {
+		fmt.Fprintf(os.Stderr, "bad bytes per line: %d\n", *flagBPL)
+		os.Exit(2)
+	}
+	HD.BytesPerLine = *flagBPL
+
+	for _, p := range flag.Args() {
+		if p == "-" {
+			if cmdStdin == "" {
+				cmdStdin, err = readStdin()
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "reading stdin: %v\n", err)
+					os.Exit(1)
+				}
+			}
+			p = cmdStdin
+		}
+		cmdFiles = append(cmdFiles, p)
+	}
+}
+
 func draw() {
+	if !sessionRestored {
+		startup()
+	}
 	G.MainMenuBar().Layout(mkMenu()).Build()
 
 	//G.SingleWindowWithMenuBar().Layout(
 	G.Window("Files").Pos(5, 30).Size(600, 600).Layout(
 		G.PrepareMsgbox(),
-		PrepareFileDialog(DialogOpen, actionOpen),
+		PrepareOpenFileDialog(DialogOpen, actionOpenDialog),
 		PrepareFileDialog(DialogSaveAs, actionWriteFile),
+		PrepareFileDialog(DialogPlugin, actionOpenPlugin),
+		PrepareFileDialog(DialogScript, actionScriptFile),
+		PrepareFileDialog(DialogSavePatch, HD.BinPatch.writePatch),
+		PrepareFileDialog(DialogApplyPatch, HD.BinPatch.applyPatchFile),
 		PrepareIntDialog(DialogGoto, actionGotoAddr),
+		PrepareIntDialog(DialogFill, actionSetFillByte),
+		PrepareFillDialog(DialogFillData),
+		PrepareFindDialog(DialogFind),
+		PrepareTransformDialog(DialogTransform),
+		PrepareCodecDialog(DialogCodec),
+		PrepareExportDialog(DialogExport),
+		PrepareExportFileDialog(DialogExportFile, DialogExport),
 		//G.MenuBar().Layout(mkMenu()),
 		//makeToolBar(),
 		mkTabWidget(),
 	)
+	drawCompareWindow()
+	drawChangesWindow()
+	drawHashWindow()
+	drawBookmarksWindow()
+	drawAnnotationsWindow()
+	drawProcessWindow()
+	drawBinPatchWindow()
+	drawEntropyWindow()
+	drawStringsWindow()
+	drawBitmapWindow()
+	drawFileChangedWindow()
+	drawScriptConsole()
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "patch" {
+		os.Exit(runPatch(os.Args[2:]))
+	}
+	parseCommandLine()
+	if *flagScript != "" {
+		status := runScriptHeadless(*flagScript, cmdFiles)
+		if cmdStdin != "" {
+			os.Remove(cmdStdin)
+		}
+		os.Exit(status)
+	}
 	G.SetDefaultFont("DejavuSansMono.ttf", 12)
 	w := G.NewMasterWindow("HexDunk", 800, 800, 0)
 	w.Run(draw)
+	shutdown()
+	if cmdStdin != "" {
+		//it wasn't opened, or the tab was closed already
+		os.Remove(cmdStdin)
+	}
 }
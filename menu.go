@@ -9,6 +9,20 @@ func ifActiveFile(w G.Widget) G.Widget {
 	return G.Condition(ActiveFile() != nil, G.Layout{w}, G.Layout{disabled})
 }
 
+//the active file can be edited
+func ifWritable(w G.Widget) G.Widget {
+	file := ActiveFile()
+	disabled := G.Style().SetDisabled(true).To(w)
+	return G.Condition(file != nil && !file.readOnly, G.Layout{w}, G.Layout{disabled})
+}
+
+//the active file can grow on disk
+func ifFollowable(w G.Widget) G.Widget {
+	file := ActiveFile()
+	disabled := G.Style().SetDisabled(true).To(w)
+	return G.Condition(file != nil && file.canFollow(), G.Layout{w}, G.Layout{disabled})
+}
+
 func ifClipboard(w G.Widget) G.Widget {
 	disabled := G.Style().SetDisabled(true).To(w)
 	return G.Condition(HD.ClipBoard != nil, G.Layout{w}, G.Layout{disabled})
@@ -36,39 +50,83 @@ func menuFile() G.Widget {
 	return G.Layout{
 		G.MenuItem("New").OnClick(actionNewFile),
 		G.MenuItem("Open").OnClick(actionOpenFile),
+		G.MenuItem("Open Process").OnClick(actionOpenProcess),
+		menuRecent(),
+		G.MenuItemf("Firmware Fill Byte (%02X)", HD.FillByte).OnClick(actionFillByte),
 		G.Separator(),
-		ifActiveFile(G.MenuItem("Save").OnClick(actionSaveFile)),
-		ifActiveFile(G.MenuItem("Save As").OnClick(actionSaveAs)),
+		ifWritable(G.MenuItem("Save").OnClick(actionSaveFile)),
+		ifWritable(G.MenuItem("Save As").OnClick(actionSaveAs)),
+		ifActiveFile(G.MenuItem("Read Only").Selected(readOnly()).OnClick(actionToggleReadOnly)),
+		ifFollowable(G.MenuItem("Follow").Selected(following()).OnClick(actionToggleFollow)),
 		ifActiveFile(G.MenuItem("Close Tab").OnClick(actionCloseTab)),
 		G.Separator(),
+		ifActiveFile(G.MenuItem("Export").OnClick(actionExport)),
+		G.Separator(),
 		//G.MenuItem("Settings").OnClick(menuEditSettings),
 		//G.Separator(),
 		G.MenuItem("Quit").OnClick(actionQuit),
 	}
 }
 
+func readOnly() bool {
+	file := ActiveFile()
+	return file != nil && file.readOnly
+}
+
+func following() bool {
+	file := ActiveFile()
+	return file != nil && file.follow
+}
+
+func menuRecent() G.Widget {
+	var items G.Layout
+	for _, p := range HD.Recent {
+		p := p
+		items = append(items, G.MenuItem(p).OnClick(func() { actionOpen(p) }))
+	}
+	if len(items) > 0 {
+		items = append(items, G.Separator(), G.MenuItem("Clear").OnClick(actionClearRecent))
+	}
+	return G.Menu("Recent Files").Layout(items...).Enabled(len(HD.Recent) > 0)
+}
+
 func menuEdit() G.Widget {
 	return G.Layout{
-		ifSelection(G.MenuItem("Cut        x").OnClick(actionCut)),
+		ifWritable(ifSelection(G.MenuItem("Cut        x").OnClick(actionCut))),
 		ifSelection(G.MenuItem("Copy       y").OnClick(actionCopy)),
-		ifClipboard(G.MenuItem("Paste      p").OnClick(actionPaste)),
+		ifWritable(ifClipboard(G.MenuItem("Paste      p").OnClick(actionPaste))),
+		G.Separator(),
+		ifWritable(ifUndo(G.MenuItem("Undo       u").OnClick(actionUndo))),
+		ifWritable(ifRedo(G.MenuItem("Redo       r").OnClick(actionRedo))),
+		G.Separator(),
+		ifActiveFile(G.MenuItem("Find All").OnClick(actionFind)),
+		ifActiveFile(G.MenuItem("Find Next  n").OnClick(func() { actionFindNext(true) })),
+		ifActiveFile(G.MenuItem("Find Prev  N").OnClick(func() { actionFindNext(false) })),
 		G.Separator(),
-		ifUndo(G.MenuItem("Undo       u").OnClick(actionUndo)),
-		ifRedo(G.MenuItem("Redo       r").OnClick(actionRedo)),
+		ifWritable(G.MenuItem("Fill").OnClick(actionFill)),
+		ifWritable(ifSelection(G.MenuItem("Transform").OnClick(actionTransform))),
+		ifSelection(G.MenuItem("Encode/Decode").OnClick(actionCodec)),
+		ifWritable(ifSelection(G.MenuItem("Revert to Disk").OnClick(actionRevert))),
+		ifActiveFile(G.MenuItem("Add Bookmark").OnClick(actionAddBookmark)),
+		ifSelection(G.MenuItem("Annotate Selection").OnClick(actionAnnotate)),
 	}
 }
 
-func menuPlugin() G.Widget {
+func menuTools() G.Widget {
 	return G.Layout{
-		G.MenuItem("Load"),
+		ifActiveFile(G.MenuItem("Compare Files").OnClick(actionCompare)),
+		ifActiveFile(G.MenuItem("Changes").OnClick(actionChanges)),
+		ifActiveFile(G.MenuItem("Hash").OnClick(actionHash)),
+		ifActiveFile(G.MenuItem("Bookmarks").OnClick(actionBookmarks)),
+		ifActiveFile(G.MenuItem("Annotations").OnClick(actionAnnotations)),
+		ifActiveFile(G.MenuItem("Binary Patch").OnClick(actionBinPatch)),
+		ifActiveFile(G.MenuItem("Entropy").OnClick(actionEntropy)),
+		ifActiveFile(G.MenuItem("Strings").OnClick(actionStrings)),
+		ifActiveFile(G.MenuItem("Bitmap").OnClick(actionBitmap)),
+		G.MenuItem("Minimap").Selected(!HD.Minimap.hidden).OnClick(actionMinimap),
 		G.Separator(),
-		G.Menu("Plugin Foo").Layout(
-			G.MenuItem("DoBar"),
-			G.MenuItem("DoBaz"),
-			G.MenuItem("Quux"),
-			G.Separator(),
-			G.MenuItem("Settings"),
-		),
+		G.MenuItem("Script Console").OnClick(actionScriptConsole),
+		ifActiveFile(G.MenuItem("Run Script").OnClick(actionRunScript)),
 	}
 }
 
@@ -76,6 +134,7 @@ func mkMenu() G.Widget {
 	return G.Layout{
 		G.Menu("File").Layout(menuFile()),
 		G.Menu("Edit").Layout(menuEdit()),
-		//G.Menu("Plugin").Layout(menuPlugin()),
+		G.Menu("Tools").Layout(menuTools()),
+		G.Menu("Plugin").Layout(menuPlugins()),
 	}
 }
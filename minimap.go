@@ -0,0 +1,277 @@
+package main
+
+//the minimap: the whole file as a bitmap beside the hex view, with the part on screen,
+//changes, bookmarks and search hits marked. clicking (or dragging) on it jumps there.
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sync"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+	B "github.com/snhmibby/filebuf"
+)
+
+//cells per row of the bitmap, each cell is 1 pixel wide
+const minimapWidth = 64
+
+const (
+	MinimapByteClass int32 = iota
+	MinimapByteValue
+)
+
+var minimapModes = []string{"Byte Class", "Byte Value"}
+
+var (
+	minimapChange   = color.RGBA{R: 255, G: 140, B: 0, A: 255}
+	minimapBookmark = color.RGBA{R: 0, G: 220, B: 220, A: 255}
+	minimapHit      = color.RGBA{R: 255, G: 230, B: 0, A: 255}
+)
+
+//colour of every byte value per mode
+var minimapPalettes = [...][256][3]uint8{minimapClassPalette(), minimapValuePalette()}
+
+//0x00 black, 0xFF white, printable ascii blue, other ascii green, the rest red
+func minimapClassPalette() (p [256][3]uint8) {
+	for c := range p {
+		switch {
+		case c == 0x00:
+			p[c] = [3]uint8{0, 0, 0}
+		case c == 0xFF:
+			p[c] = [3]uint8{255, 255, 255}
+		case c >= 0x20 && c < 0x7F:
+			p[c] = [3]uint8{55, 126, 184}
+		case c < 0x80:
+			p[c] = [3]uint8{77, 175, 74}
+		default:
+			p[c] = [3]uint8{228, 26, 28}
+		}
+	}
+	return p
+}
+
+//dark blue over green to yellow
+func minimapValuePalette() (p [256][3]uint8) {
+	stops := [][3]float64{{20, 20, 80}, {30, 140, 140}, {120, 200, 60}, {250, 230, 40}}
+	for c := range p {
+		f := float64(c) / 255 * float64(len(stops)-1)
+		i := int(f)
+		if i >= len(stops)-1 {
+			i = len(stops) - 2
+		}
+		t := f - float64(i)
+		for k := range p[c] {
+			p[c][k] = uint8(stops[i][k] + t*(stops[i+1][k]-stops[i][k]))
+		}
+	}
+	return p
+}
+
+//bytes per cell so that size bytes fit in rows rows
+func minimapCellSize(size int64, rows int) int64 {
+	cells := int64(minimapWidth * rows)
+	if cells <= 0 {
+		return 1
+	}
+	n := (size + cells - 1) / cells
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+//the bitmap of b, every cell has the average colour of its bytes.
+//progress is called for every chunk and can cancel
+func minimapImage(b *B.Buffer, perCell int64, mode int32, progress func() bool) (*image.RGBA, bool) {
+	cells := (b.Size() + perCell - 1) / perCell
+	rows := int((cells + minimapWidth - 1) / minimapWidth)
+	if rows < 1 {
+		rows = 1
+	}
+	img := image.NewRGBA(image.Rect(0, 0, minimapWidth, rows))
+	palette := &minimapPalettes[mode]
+	var sum [3]int64
+	var n, cell int64
+	flush := func() {
+		o := img.PixOffset(int(cell%minimapWidth), int(cell/minimapWidth))
+		for k := range sum {
+			img.Pix[o+k] = uint8(sum[k] / n)
+		}
+		img.Pix[o+3] = 255
+		sum = [3]int64{}
+		n = 0
+		cell++
+	}
+	canceled := false
+	b.Iter(func(p []byte) bool {
+		for _, c := range p {
+			col := &palette[c]
+			sum[0] += int64(col[0])
+			sum[1] += int64(col[1])
+			sum[2] += int64(col[2])
+			n++
+			if n == perCell {
+				flush()
+			}
+		}
+		canceled = !progress()
+		return canceled
+	})
+	if n > 0 {
+		flush()
+	}
+	return img, !canceled
+}
+
+//the input of the bitmap, when it changes it is made again
+type minimapKey struct {
+	name    string
+	version int
+	rows    int
+	mode    int32
+}
+
+type minimap struct {
+	hidden bool
+	mode   int32
+
+	//made in the background
+	mu      sync.Mutex
+	gen     int
+	key     minimapKey
+	texture *G.Texture
+	shown   minimapKey //input of texture
+	perCell int64      //bytes per cell of texture
+}
+
+//(re)make the bitmap if the file, its contents or the size of the minimap changed
+func (m *minimap) update(hf *HexFile, rows int) {
+	key := minimapKey{hf.name, hf.version, rows, m.mode}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if key == m.key {
+		return
+	}
+	m.key = key
+	m.gen++
+	gen, data := m.gen, hf.buf.Copy(0, hf.buf.Size())
+	perCell := minimapCellSize(data.Size(), rows)
+	go func() {
+		img, ok := minimapImage(data, perCell, key.mode, func() bool {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			return gen == m.gen
+		})
+		if !ok {
+			return
+		}
+		G.NewTextureFromRgba(img, func(t *G.Texture) {
+			m.mu.Lock()
+			if gen == m.gen {
+				m.texture = t
+				m.shown = key
+				m.perCell = perCell
+			}
+			m.mu.Unlock()
+			G.Update()
+		})
+	}()
+}
+
+//draw the minimap of hf in the available height
+func (m *minimap) build(tab *HexTab, hf *HexFile) {
+	_, h := G.GetAvailableRegion()
+	height := int(h)
+	if height < 16 {
+		return
+	}
+	m.update(hf, height)
+	m.mu.Lock()
+	texture, shown, perCell := m.texture, m.shown, m.perCell
+	m.mu.Unlock()
+
+	pos := G.GetCursorScreenPos()
+	canvas := G.GetCanvas()
+	canvas.AddRectFilled(pos, pos.Add(image.Pt(minimapWidth, height)), graphBG, 0, 0)
+	if texture == nil || shown.name != hf.name {
+		G.InvisibleButton().Size(minimapWidth, float32(height)).Build()
+		return
+	}
+
+	//small files are stretched, up to 8 pixels per row
+	size := hf.buf.Size()
+	rows := (size/perCell + minimapWidth - 1) / minimapWidth
+	if rows < 1 {
+		rows = 1
+	}
+	rowHeight := float64(height) / float64(rows)
+	if rowHeight > 8 {
+		rowHeight = 8
+	}
+	toY := func(addr int64) int { return int(float64(addr/perCell/minimapWidth) * rowHeight) }
+	canvas.AddImage(texture, pos, pos.Add(image.Pt(minimapWidth, int(float64(rows)*rowHeight))))
+
+	//changes on the left, search hits on the right, bookmarks across
+	mark := func(addr int64, x0, x1 int, col color.RGBA) {
+		y := toY(addr)
+		canvas.AddLine(pos.Add(image.Pt(x0, y)), pos.Add(image.Pt(x1, y)), col, 1)
+	}
+	hunks := hf.Changes()
+	for _, d := range hunks {
+		y0, y1 := toY(d.bOff), toY(d.bOff+d.bSize)
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		canvas.AddRectFilled(pos.Add(image.Pt(0, y0)), pos.Add(image.Pt(4, y1)), minimapChange, 0, 0)
+	}
+	hits, _ := hf.SearchHits()
+	lastY := -1
+	for _, at := range hits {
+		if y := toY(at); y != lastY {
+			mark(at, minimapWidth-6, minimapWidth, minimapHit)
+			lastY = y
+		}
+	}
+	for _, b := range hf.marks.bookmarks {
+		mark(b.off, 0, minimapWidth, minimapBookmark)
+	}
+
+	//the part of the file on screen and the cursor
+	view := tab.view
+	y0, y1 := toY(view.topAddr), toY(view.topAddr+view.bytesPerLine*view.linesPerScreen)
+	if y1 < y0+2 {
+		y1 = y0 + 2
+	}
+	canvas.AddRect(pos.Add(image.Pt(0, y0)), pos.Add(image.Pt(minimapWidth, y1)), graphView, 0, 0, 1)
+	mark(view.cursor, 0, minimapWidth, graphCursor)
+
+	G.InvisibleButton().Size(minimapWidth, float32(height)).Build()
+	G.ContextMenu().Layout(
+		G.MenuItem(minimapModes[MinimapByteClass]).Selected(m.mode == MinimapByteClass).OnClick(func() { m.mode = MinimapByteClass }),
+		G.MenuItem(minimapModes[MinimapByteValue]).Selected(m.mode == MinimapByteValue).OnClick(func() { m.mode = MinimapByteValue }),
+	).Build()
+
+	mouse := G.GetMousePos().Sub(pos)
+	if mouse.X < 0 || mouse.X >= minimapWidth || mouse.Y < 0 || mouse.Y >= height {
+		return
+	}
+	row := int64(float64(mouse.Y) / rowHeight)
+	addr := (row*minimapWidth + int64(mouse.X)) * perCell
+	if addr >= size {
+		return
+	}
+	if I.IsItemHovered() {
+		I.SetTooltip(fmt.Sprintf("%X", addr+hf.fw.base))
+	}
+	if I.IsItemActive() && I.IsMouseDown(int(G.MouseButtonLeft)) {
+		tab.setCursor(addr)
+		//center the view on addr
+		top := addr - view.bytesPerLine*view.linesPerScreen/2
+		if top < 0 {
+			top = 0
+		}
+		view.ScrollTop(top)
+	}
+}
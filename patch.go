@@ -0,0 +1,217 @@
+package main
+
+//hexdunk patch: edit files from the command line, without the gui.
+//the edits and the saving are the same as in the editor (undo entries are made, but
+//nobody will use them).
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+const patchUsage = `usage: %s patch [-n] [-max n] command args... file...
+commands:
+  set OFFSET BYTES        overwrite the bytes at OFFSET
+  insert OFFSET BYTES     insert the bytes at OFFSET
+  delete OFFSET SIZE      delete SIZE bytes at OFFSET
+  replace PATTERN REPL    replace every PATTERN with REPL
+  apply PATCHFILE         apply an IPS, BPS or JSON patch file
+OFFSET and SIZE are decimal, or hex with 0x. OFFSET is a load address for firmware images.
+BYTES, PATTERN and REPL are hex, spaces are ignored (i.e. "90 90" or 9090).
+A file that can't be patched is left alone, and the exit status is 1.
+`
+
+//a parsed patch command
+type patchCmd struct {
+	name      string
+	off, size int64
+	data      []byte
+	pattern   []byte
+	max       int    //replace at most max patterns (0 is all)
+	patchFile string //apply: the patch file, its contents are in data
+}
+
+//hex bytes, with optional spaces and 0x
+func parseHexBytes(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad hex bytes %q", s)
+	}
+	return data, nil
+}
+
+func parseOffset(s string) (int64, error) {
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad number %q", s)
+	}
+	return n, nil
+}
+
+//parse the command, returns the remaining arguments (the files)
+func parsePatchCmd(args []string) (*patchCmd, []string, error) {
+	if len(args) < 3 {
+		return nil, nil, fmt.Errorf("missing arguments")
+	}
+	cmd := &patchCmd{name: args[0]}
+	var err error
+	switch cmd.name {
+	case "apply":
+		cmd.patchFile = args[1]
+		if cmd.data, err = os.ReadFile(cmd.patchFile); err != nil {
+			return nil, nil, err
+		}
+		if _, err = detectPatchFormat(cmd.data); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", cmd.patchFile, err)
+		}
+		return cmd, args[2:], nil
+	case "set", "insert":
+		if cmd.off, err = parseOffset(args[1]); err != nil {
+			return nil, nil, err
+		}
+		if cmd.data, err = parseHexBytes(args[2]); err != nil {
+			return nil, nil, err
+		}
+		if len(cmd.data) == 0 {
+			return nil, nil, fmt.Errorf("%s: no bytes", cmd.name)
+		}
+	case "delete":
+		if cmd.off, err = parseOffset(args[1]); err != nil {
+			return nil, nil, err
+		}
+		if cmd.size, err = parseOffset(args[2]); err != nil {
+			return nil, nil, err
+		}
+	case "replace":
+		if cmd.pattern, err = parseHexBytes(args[1]); err != nil {
+			return nil, nil, err
+		}
+		if cmd.data, err = parseHexBytes(args[2]); err != nil {
+			return nil, nil, err
+		}
+		if len(cmd.pattern) == 0 {
+			return nil, nil, fmt.Errorf("replace: empty pattern")
+		}
+	default:
+		return nil, nil, fmt.Errorf("unknown command %q", cmd.name)
+	}
+	return cmd, args[3:], nil
+}
+
+//apply the command to hf, returns a description of what was done
+func (cmd *patchCmd) apply(hf *HexFile) (string, error) {
+	off := cmd.off - hf.fw.base
+	switch cmd.name {
+	case "set":
+		size := int64(len(cmd.data))
+		if off+size > hf.buf.Size() {
+			size = hf.buf.Size() - off
+		}
+		if off < 0 || size < 0 {
+			return "", fmt.Errorf("offset %#x is outside of the file", cmd.off)
+		}
+		return fmt.Sprintf("set %d bytes at %#x", len(cmd.data), cmd.off), hf.Replace(off, size, B.NewMem(cmd.data))
+	case "insert":
+		return fmt.Sprintf("inserted %d bytes at %#x", len(cmd.data), cmd.off), hf.Replace(off, 0, B.NewMem(cmd.data))
+	case "delete":
+		return fmt.Sprintf("deleted %d bytes at %#x", cmd.size, cmd.off), hf.Replace(off, cmd.size, B.NewMem(nil))
+	case "replace":
+		n := 0
+		for at := findBytes(hf.buf, cmd.pattern, 0); at >= 0; at = findBytes(hf.buf, cmd.pattern, at) {
+			if err := hf.Replace(at, int64(len(cmd.pattern)), B.NewMem(cmd.data)); err != nil {
+				return "", err
+			}
+			at += int64(len(cmd.data))
+			n++
+			if n == cmd.max {
+				break
+			}
+		}
+		if n == 0 {
+			return "", fmt.Errorf("pattern %x not found", cmd.pattern)
+		}
+		return fmt.Sprintf("replaced %d times", n), nil
+	case "apply":
+		format, n, err := hf.applyPatch(cmd.data)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("applied %s patch %s (%d changes)", format.name, cmd.patchFile, n), nil
+	}
+	panic("unknown patch command " + cmd.name)
+}
+
+//save the edits and the project file of a file opened without the gui
+func (hf *HexFile) saveHeadless() error {
+	if hf.version != hf.savedVersion {
+		if err := hf.save(hf.name); err != nil {
+			return err
+		}
+	}
+	if hf.onDisk() && hf.projectChanged() {
+		return hf.saveProject(hf.name)
+	}
+	return nil
+}
+
+func patchFile(cmd *patchCmd, path string, dryRun bool) (string, error) {
+	hf, _, err := loadHexFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer hf.removeTemp()
+	if hf.readOnly {
+		return "", fmt.Errorf("pipes, character devices and /proc files can't be patched")
+	}
+	if err := hf.loadProject(path); err != nil {
+		return "", err
+	}
+	what, err := cmd.apply(hf)
+	if err != nil || dryRun {
+		return what, err
+	}
+	return what, hf.saveHeadless()
+}
+
+//hexdunk patch, returns the exit status
+func runPatch(args []string) int {
+	flags := flag.NewFlagSet("patch", flag.ExitOnError)
+	dryRun := flags.Bool("n", false, "dry run: don't save the files")
+	max := flags.Int("max", 0, "replace: at most this many patterns per file (0 for all)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), patchUsage, os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	cmd, files, err := parsePatchCmd(flags.Args())
+	if err == nil && len(files) == 0 {
+		err = fmt.Errorf("no files")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "patch: %v\n", err)
+		flags.Usage()
+		return 2
+	}
+	cmd.max = *max
+
+	status := 0
+	for _, p := range files {
+		what, err := patchFile(cmd, p, *dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", p, err)
+			status = 1
+			continue
+		}
+		fmt.Printf("%s: %s\n", p, what)
+	}
+	return status
+}
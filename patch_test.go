@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+func TestParsePatchCmd(t *testing.T) {
+	dir := t.TempDir()
+	ips := filepath.Join(dir, "fix.ips")
+	os.WriteFile(ips, []byte("PATCHEOF"), 0644)
+	notPatch := filepath.Join(dir, "fix.txt")
+	os.WriteFile(notPatch, []byte("hello"), 0644)
+
+	tests := []struct {
+		args  []string
+		want  patchCmd
+		files int
+		ok    bool
+	}{
+		{[]string{"set", "0x10", "90 90", "a", "b"}, patchCmd{name: "set", off: 16, data: []byte{0x90, 0x90}}, 2, true},
+		{[]string{"insert", "8", "0xCAFE", "a"}, patchCmd{name: "insert", off: 8, data: []byte{0xCA, 0xFE}}, 1, true},
+		{[]string{"delete", "0x8", "0x4", "a"}, patchCmd{name: "delete", off: 8, size: 4}, 1, true},
+		{[]string{"replace", "de ad", "", "a"}, patchCmd{name: "replace", pattern: []byte{0xDE, 0xAD}, data: []byte{}}, 1, true},
+		{[]string{"apply", ips, "a"}, patchCmd{name: "apply", patchFile: ips, data: []byte("PATCHEOF")}, 1, true},
+		{[]string{"set", "0x10", "90"}, patchCmd{}, 0, true},
+		{[]string{"set", "-1", "90", "a"}, patchCmd{}, 0, false},
+		{[]string{"set", "x", "90", "a"}, patchCmd{}, 0, false},
+		{[]string{"set", "0", "9", "a"}, patchCmd{}, 0, false},
+		{[]string{"set", "0", "", "a"}, patchCmd{}, 0, false},
+		{[]string{"delete", "0", "many", "a"}, patchCmd{}, 0, false},
+		{[]string{"replace", "", "90", "a"}, patchCmd{}, 0, false},
+		{[]string{"apply", notPatch, "a"}, patchCmd{}, 0, false},
+		{[]string{"apply", filepath.Join(dir, "none"), "a"}, patchCmd{}, 0, false},
+		{[]string{"frob", "0", "0", "a"}, patchCmd{}, 0, false},
+		{[]string{"set", "0"}, patchCmd{}, 0, false},
+	}
+	for _, tc := range tests {
+		cmd, files, err := parsePatchCmd(tc.args)
+		if !tc.ok {
+			if err == nil {
+				t.Errorf("%q: no error", tc.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tc.args, err)
+			continue
+		}
+		if len(files) != tc.files {
+			t.Errorf("%q: files %q", tc.args, files)
+		}
+		if tc.want.name == "" {
+			continue
+		}
+		if cmd.name != tc.want.name || cmd.off != tc.want.off || cmd.size != tc.want.size ||
+			!bytes.Equal(cmd.data, tc.want.data) || !bytes.Equal(cmd.pattern, tc.want.pattern) ||
+			cmd.patchFile != tc.want.patchFile {
+			t.Errorf("%q: %+v, want %+v", tc.args, *cmd, tc.want)
+		}
+	}
+}
+
+func TestPatchCmdApply(t *testing.T) {
+	tests := []struct {
+		args []string
+		max  int
+		base int64  //load address of a firmware image
+		want string //"" is an error
+	}{
+		{[]string{"set", "2", "5858"}, 0, 0, "abXXefabcd"},
+		{[]string{"set", "8", "585858"}, 0, 0, "abcdefabXXX"},
+		{[]string{"set", "11", "58"}, 0, 0, ""},
+		{[]string{"set", "0x102", "58"}, 0, 0x100, "abXdefabcd"},
+		{[]string{"set", "0x10", "58"}, 0, 0x100, ""},
+		{[]string{"insert", "0", "58"}, 0, 0, "Xabcdefabcd"},
+		{[]string{"delete", "1", "3"}, 0, 0, "aefabcd"},
+		{[]string{"replace", "6263", "2d2d2d"}, 0, 0, "a---defa---d"},
+		{[]string{"replace", "6263", ""}, 1, 0, "adefabcd"},
+		{[]string{"replace", "61", "6161"}, 0, 0, "aabcdefaabcd"},
+		{[]string{"replace", "7a", "00"}, 0, 0, ""},
+	}
+	for _, tc := range tests {
+		cmd, _, err := parsePatchCmd(append(tc.args, "file"))
+		if err != nil {
+			t.Fatalf("%q: %v", tc.args, err)
+		}
+		cmd.max = tc.max
+		hf := &HexFile{buf: B.NewMem([]byte("abcdefabcd")), fw: fwInfo{base: tc.base}}
+		hf.resetOriginal()
+		_, err = cmd.apply(hf)
+		switch got := string(contents(hf.buf)); {
+		case tc.want == "" && err == nil:
+			t.Errorf("%q: no error, got %q", tc.args, got)
+		case tc.want != "" && err != nil:
+			t.Errorf("%q: %v", tc.args, err)
+		case tc.want != "" && got != tc.want:
+			t.Errorf("%q: %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
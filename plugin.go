@@ -0,0 +1,195 @@
+package main
+
+//go plugins (.so files built with -buildmode=plugin), see the api package.
+//plugins register themselves in their init function, which runs when they are loaded.
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"plugin"
+
+	G "github.com/AllenDang/giu"
+	B "github.com/snhmibby/filebuf"
+	"github.com/snhmibby/hexdunk/api"
+)
+
+type loadedPlugin struct {
+	path    string
+	plugins []*api.Plugin //registered by this file
+}
+
+func init() {
+	api.SetHost(pluginHost{})
+}
+
+func loadPlugin(path string) error {
+	for _, lp := range HD.Plugins {
+		if lp.path == path {
+			return fmt.Errorf("%s is already loaded", path)
+		}
+	}
+	before := len(api.Registered())
+	if _, err := plugin.Open(path); err != nil {
+		return err
+	}
+	registered := api.Registered()[before:]
+	if len(registered) == 0 {
+		return fmt.Errorf("%s didn't register a plugin", path)
+	}
+	HD.Plugins = append(HD.Plugins, loadedPlugin{path: path, plugins: registered})
+	return nil
+}
+
+func runPluginAction(p *api.Plugin, item api.MenuItem) {
+	if err := item.Action(); err != nil {
+		ErrorDialog(fmt.Sprintf("%s: %s", p.Name, item.Label), err.Error())
+	}
+}
+
+func menuPlugins() G.Widget {
+	layout := G.Layout{G.MenuItem("Load").OnClick(actionLoadPlugin)}
+	if len(HD.Plugins) > 0 {
+		layout = append(layout, G.Separator())
+	}
+	for _, lp := range HD.Plugins {
+		for _, p := range lp.plugins {
+			p := p
+			var items G.Layout
+			for _, item := range p.Menu {
+				item := item
+				items = append(items, G.MenuItem(item.Label).OnClick(func() { runPluginAction(p, item) }))
+			}
+			items = append(items, G.Separator(), G.MenuItem(filepath.Base(lp.path)).Enabled(false))
+			layout = append(layout, G.Menu(p.Name).Layout(items...))
+		}
+	}
+	return layout
+}
+
+/*
+ * api.Host, the edits of plugins are undoable like the ones from the keyboard
+ */
+
+type pluginHost struct{}
+
+func (pluginHost) active() (*HexTab, *HexFile, error) {
+	tab, file := ActiveTab(), ActiveFile()
+	if tab == nil || file == nil {
+		return nil, nil, api.ErrNoFile
+	}
+	return tab, file, nil
+}
+
+func (h pluginHost) FileName() (string, error) {
+	_, file, err := h.active()
+	if err != nil {
+		return "", err
+	}
+	return file.name, nil
+}
+
+func (h pluginHost) Size() (int64, error) {
+	_, file, err := h.active()
+	if err != nil {
+		return 0, err
+	}
+	return file.buf.Size(), nil
+}
+
+func (h pluginHost) Read(off, size int64) ([]byte, error) {
+	_, file, err := h.active()
+	if err != nil {
+		return nil, err
+	}
+	if size < 0 || off < 0 || off+size > file.buf.Size() {
+		return nil, fmt.Errorf("Read: %d bytes at %d is outside of the file (%d bytes)", size, off, file.buf.Size())
+	}
+	data := make([]byte, size)
+	file.buf.Seek(off, io.SeekStart)
+	_, err = io.ReadFull(file.buf, data)
+	return data, err
+}
+
+func (h pluginHost) Write(off int64, data []byte) error {
+	_, file, err := h.active()
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	if off+size > file.buf.Size() {
+		size = file.buf.Size() - off
+	}
+	return file.Replace(off, size, B.NewMem(append([]byte(nil), data...)))
+}
+
+func (h pluginHost) Insert(off int64, data []byte) error {
+	_, file, err := h.active()
+	if err != nil {
+		return err
+	}
+	return file.Replace(off, 0, B.NewMem(append([]byte(nil), data...)))
+}
+
+func (h pluginHost) Delete(off, size int64) error {
+	_, file, err := h.active()
+	if err != nil {
+		return err
+	}
+	return file.Replace(off, size, B.NewMem(nil))
+}
+
+func (h pluginHost) Cursor() (int64, error) {
+	tab, _, err := h.active()
+	if err != nil {
+		return 0, err
+	}
+	return tab.view.cursor, nil
+}
+
+func (h pluginHost) SetCursor(off int64) error {
+	tab, _, err := h.active()
+	if err != nil {
+		return err
+	}
+	tab.setCursor(off)
+	return nil
+}
+
+func (h pluginHost) Selection() (int64, int64, error) {
+	tab, _, err := h.active()
+	if err != nil {
+		return 0, 0, err
+	}
+	off, size := tab.view.Selection()
+	return off, size, nil
+}
+
+func (h pluginHost) SetSelection(off, size int64) error {
+	tab, file, err := h.active()
+	if err != nil {
+		return err
+	}
+	if size < 0 || off < 0 || off+size > file.buf.Size() {
+		return fmt.Errorf("SetSelection: %d bytes at %d is outside of the file (%d bytes)", size, off, file.buf.Size())
+	}
+	tab.view.SetSelection(off, size)
+	return nil
+}
+
+//the edits are grouped even if edits fails, so they can be undone in one go
+func (h pluginHost) Group(edits func() error) error {
+	_, file, err := h.active()
+	if err != nil {
+		return err
+	}
+	n := len(file.undo)
+	err = edits()
+	file.groupUndo(len(file.undo) - n)
+	return err
+}
+
+func (pluginHost) Info(title, msg string)  { InfoDialog(title, msg) }
+func (pluginHost) Error(title, msg string) { ErrorDialog(title, msg) }
+
+func (pluginHost) Confirm(title, msg string, yes func()) { YesNoDialog(title, msg, yes) }
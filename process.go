@@ -0,0 +1,442 @@
+package main
+
+//live process memory (linux): the mapped regions of /proc/PID/maps are opened as
+//files, their bytes are read from and written to /proc/PID/mem.
+//the process keeps running: the part of a region that is on screen is read again
+//every watchInterval (see watch.go)
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+	B "github.com/snhmibby/filebuf"
+)
+
+const (
+	procPageSize  = 4096
+	procReadChunk = 1 << 20
+	maxRegionSize = 1 << 30 //regions are read in memory
+)
+
+type procEntry struct {
+	pid  int
+	name string
+	cmd  string
+}
+
+//a line of /proc/PID/maps
+type procRegion struct {
+	start, end int64
+	perms      string
+	path       string
+}
+
+func (r procRegion) String() string {
+	return fmt.Sprintf("%X-%X %s %s", r.start, r.end, r.perms, r.path)
+}
+
+//the memory of a process opened as a file
+type procMem struct {
+	pid        int
+	region     procRegion
+	unreadable []int64 //sorted offsets of the pages that couldn't be read
+}
+
+func (pm *procMem) memPath() string {
+	return fmt.Sprintf("/proc/%d/mem", pm.pid)
+}
+
+func (pm *procMem) isUnreadable(off int64) bool {
+	page := off / procPageSize * procPageSize
+	i := sort.Search(len(pm.unreadable), func(i int) bool { return pm.unreadable[i] >= page })
+	return i < len(pm.unreadable) && pm.unreadable[i] == page
+}
+
+//all processes, by pid
+func listProcesses() ([]procEntry, error) {
+	dirs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	var procs []procEntry
+	for _, d := range dirs {
+		pid, err := strconv.Atoi(d.Name())
+		if err != nil || !d.IsDir() {
+			continue
+		}
+		comm, _ := os.ReadFile(filepath.Join("/proc", d.Name(), "comm"))
+		cmd, _ := os.ReadFile(filepath.Join("/proc", d.Name(), "cmdline"))
+		procs = append(procs, procEntry{
+			pid:  pid,
+			name: strings.TrimSpace(string(comm)),
+			cmd:  strings.TrimSpace(strings.ReplaceAll(string(cmd), "\x00", " ")),
+		})
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].pid < procs[j].pid })
+	return procs, nil
+}
+
+func parseMaps(r io.Reader) ([]procRegion, error) {
+	var regions []procRegion
+	scan := bufio.NewScanner(r)
+	for scan.Scan() {
+		fields := strings.Fields(scan.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		addrs := strings.SplitN(fields[0], "-", 2)
+		if len(addrs) != 2 {
+			return nil, fmt.Errorf("bad maps line: %s", scan.Text())
+		}
+		start, err1 := strconv.ParseUint(addrs[0], 16, 64)
+		end, err2 := strconv.ParseUint(addrs[1], 16, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("bad maps line: %s", scan.Text())
+		}
+		if end > math.MaxInt64 {
+			continue //[vsyscall] is in kernel space, past the offsets of a buffer
+		}
+		r := procRegion{start: int64(start), end: int64(end), perms: fields[1]}
+		if len(fields) > 5 {
+			r.path = strings.Join(fields[5:], " ")
+		}
+		regions = append(regions, r)
+	}
+	return regions, scan.Err()
+}
+
+func processRegions(pid int) ([]procRegion, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMaps(f)
+}
+
+//read a region, pages that can't be read are left 0 and listed as unreadable
+func readRegion(mem io.ReaderAt, r procRegion) ([]byte, []int64) {
+	return readRange(mem, r, 0, r.end-r.start)
+}
+
+//read size bytes at (page aligned) offset off of a region, like readRegion
+func readRange(mem io.ReaderAt, r procRegion, off, size int64) ([]byte, []int64) {
+	data := make([]byte, size)
+	var unreadable []int64
+	for o := int64(0); o < size; o += procReadChunk {
+		end := o + procReadChunk
+		if end > size {
+			end = size
+		}
+		if n, _ := mem.ReadAt(data[o:end], r.start+off+o); int64(n) == end-o {
+			continue
+		}
+		//try the pages of this chunk one by one
+		for p := o; p < end; p += procPageSize {
+			pe := p + procPageSize
+			if pe > end {
+				pe = end
+			}
+			if n, _ := mem.ReadAt(data[p:pe], r.start+off+p); int64(n) != pe-p {
+				for i := p; i < pe; i++ {
+					data[i] = 0
+				}
+				unreadable = append(unreadable, off+p)
+			}
+		}
+	}
+	return data, unreadable
+}
+
+//the pages in [start, end) that couldn't be read were read again, returns if the
+//list changed
+func (pm *procMem) setUnreadable(start, end int64, unreadable []int64) bool {
+	var pages, old []int64
+	for _, p := range pm.unreadable {
+		if p < start || p >= end {
+			pages = append(pages, p)
+		} else {
+			old = append(old, p)
+		}
+	}
+	pages = append(pages, unreadable...)
+	sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+	pm.unreadable = pages
+	if len(old) != len(unreadable) {
+		return true
+	}
+	for i := range old {
+		if old[i] != unreadable[i] {
+			return true
+		}
+	}
+	return false
+}
+
+//read [off, off+size) of the buffer from the process again. What the process changed
+//goes in the original, and in the buffer where it wasn't edited (that isn't an
+//edit). Returns if anything changed
+func (hf *HexFile) updateProcess(mem io.ReaderAt, off, size int64) bool {
+	pm := hf.proc
+	hunks := hf.Changes()
+	//whole pages of the region, the buffer is shifted by edits that insert or delete
+	start := mapAddr(hunks, off, 1) / procPageSize * procPageSize
+	end := (mapAddr(hunks, off+size, 1) + procPageSize - 1) / procPageSize * procPageSize
+	if end > hf.orig.Size() {
+		end = hf.orig.Size()
+	}
+	if start >= end {
+		return false
+	}
+	data, unreadable := readRange(mem, pm.region, start, end-start)
+	changed := pm.setUnreadable(start, end, unreadable)
+	old := make([]byte, end-start)
+	hf.orig.Seek(start, io.SeekStart)
+	io.ReadFull(hf.orig, old)
+	if bytes.Equal(old, data) {
+		return changed
+	}
+
+	//a and delta: the start of the unedited bytes after a hunk, and their shift in
+	//the buffer
+	var a, delta int64
+	update := func(s, e int64) {
+		if s < start {
+			s = start
+		}
+		if e > end {
+			e = end
+		}
+		for s < e && old[s-start] == data[s-start] {
+			s++
+		}
+		for e > s && old[e-1-start] == data[e-1-start] {
+			e--
+		}
+		if s < e {
+			hf.buf.Remove(s+delta, e-s)
+			hf.buf.Paste(s+delta, B.NewMem(append([]byte(nil), data[s-start:e-start]...)))
+		}
+	}
+	for _, h := range hunks {
+		update(a, h.aOff)
+		a, delta = h.aOff+h.aSize, h.bOff+h.bSize-h.aOff-h.aSize
+	}
+	update(a, end)
+	hf.orig.Remove(start, end-start)
+	hf.orig.Paste(start, B.NewMem(data))
+
+	saved := hf.version == hf.savedVersion
+	hf.touch()
+	if saved {
+		hf.savedVersion = hf.version
+	}
+	return true
+}
+
+//read the part of the region that is on screen in its tabs again
+func (hf *HexFile) refreshProcess() {
+	mem, err := os.Open(hf.proc.memPath())
+	if err != nil {
+		return //the process is gone, keep the last bytes read
+	}
+	defer mem.Close()
+	for _, tab := range HD.Tabs {
+		if v := tab.view; tab.name == hf.name {
+			hf.updateProcess(mem, v.topAddr, v.bytesPerLine*v.linesPerScreen)
+		}
+	}
+}
+
+//open a memory region of a process in a tab
+func OpenProcessRegion(pid int, r procRegion) (*HexFile, error) {
+	name := regionName(pid, r)
+	hf, ok := HD.Files[name]
+	if !ok {
+		var err error
+		if hf, err = loadProcessRegion(pid, r); err != nil {
+			return nil, mkErr("OpenProcessRegion", err)
+		}
+		HD.Files[name] = hf
+	}
+	OpenTab(hf)
+	return hf, nil
+}
+
+//the name of a region opened as a file
+func regionName(pid int, r procRegion) string {
+	return fmt.Sprintf("/proc/%d/mem@%X-%X", pid, r.start, r.end)
+}
+
+//read a memory region of a process, without the gui
+func loadProcessRegion(pid int, r procRegion) (*HexFile, error) {
+	if r.end-r.start > maxRegionSize {
+		return nil, fmt.Errorf("region %s is too big (%s) to load in memory", r, mkSize(r.end-r.start))
+	}
+	pm := &procMem{pid: pid, region: r}
+	mem, err := os.Open(pm.memPath())
+	if err != nil {
+		return nil, err
+	}
+	data, unreadable := readRegion(mem, r)
+	mem.Close()
+	pm.unreadable = unreadable
+
+	label := filepath.Base(r.path)
+	if r.path == "" {
+		label = "anon"
+	}
+	hf := new(HexFile)
+	hf.buf = B.NewMem(data)
+	hf.name = regionName(pid, r)
+	hf.stats = memInfo{name: fmt.Sprintf("%d %s %X", pid, label, r.start), size: int64(len(data))}
+	hf.kind = KindProcess
+	hf.proc = pm
+	hf.fw = fwInfo{base: r.start}
+	hf.readOnly = !strings.Contains(r.perms, "w")
+	hf.resetOriginal()
+	return hf, nil
+}
+
+//write the changed bytes of a region back into the process
+func writeProcess(hf *HexFile) error {
+	f, err := os.OpenFile(hf.proc.memPath(), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return writeChanged(hf, f, hf.proc.region.start, 1)
+}
+
+/*
+ * Open process window
+ */
+
+type processWindow struct {
+	open     bool
+	filter   string
+	procs    []procEntry
+	pid      int //selected process
+	regions  []procRegion
+	readable bool //only show readable regions
+	err      string
+}
+
+func (pw *processWindow) Open() {
+	pw.open = true
+	pw.readable = true
+	pw.refresh()
+}
+
+func (pw *processWindow) refresh() {
+	procs, err := listProcesses()
+	pw.procs, pw.err = procs, ""
+	if err != nil {
+		pw.err = err.Error()
+	}
+	if pw.pid != 0 {
+		pw.selectProcess(pw.pid)
+	}
+}
+
+func (pw *processWindow) selectProcess(pid int) {
+	pw.pid = pid
+	regions, err := processRegions(pid)
+	pw.regions, pw.err = regions, ""
+	if err != nil {
+		pw.err = err.Error()
+	}
+}
+
+func (pw *processWindow) processTable() {
+	flags := I.TableFlags_ScrollY | I.TableFlags_RowBg | I.TableFlags_Resizable
+	if I.BeginTable("ProcessTable", 2, flags, I.ContentRegionAvail(), 0) {
+		defer I.EndTable()
+		I.TableSetupColumn("PID", 0, 0, 0)
+		I.TableSetupColumn("Name", 0, 0, 0)
+		I.TableSetupScrollFreeze(0, 1)
+		I.TableHeadersRow()
+		filter := strings.ToLower(pw.filter)
+		for _, p := range pw.procs {
+			if filter != "" && !strings.Contains(strings.ToLower(p.name+" "+p.cmd), filter) && !strings.HasPrefix(strconv.Itoa(p.pid), filter) {
+				continue
+			}
+			I.TableNextRow(0, 0)
+			I.TableNextColumn()
+			if I.SelectableV(fmt.Sprint(p.pid), p.pid == pw.pid, selectFlags, I.Vec2{}) {
+				pw.selectProcess(p.pid)
+			}
+			if p.cmd != "" && I.IsItemHovered() {
+				I.SetTooltip(p.cmd)
+			}
+			I.TableNextColumn()
+			I.Text(p.name)
+		}
+	}
+}
+
+func (pw *processWindow) regionTable() {
+	flags := I.TableFlags_ScrollY | I.TableFlags_RowBg | I.TableFlags_Resizable
+	if I.BeginTable("RegionTable", 4, flags, I.ContentRegionAvail(), 0) {
+		defer I.EndTable()
+		I.TableSetupColumn("Address", 0, 0, 0)
+		I.TableSetupColumn("Size", 0, 0, 0)
+		I.TableSetupColumn("Perms", 0, 0, 0)
+		I.TableSetupColumn("Mapping", 0, 0, 0)
+		I.TableSetupScrollFreeze(0, 1)
+		I.TableHeadersRow()
+		for i, r := range pw.regions {
+			if pw.readable && !strings.HasPrefix(r.perms, "r") {
+				continue
+			}
+			I.TableNextRow(0, 0)
+			I.TableNextColumn()
+			if I.SelectableV(fmt.Sprintf("%X-%X##region%d", r.start, r.end, i), false, selectFlags, I.Vec2{}) {
+				if I.IsMouseDoubleClicked(int(G.MouseButtonLeft)) {
+					if _, err := OpenProcessRegion(pw.pid, r); err != nil {
+						ErrorDialog("Open Process Region", err.Error())
+					}
+				}
+			}
+			I.TableNextColumn()
+			I.Text(mkSize(r.end - r.start))
+			I.TableNextColumn()
+			I.Text(r.perms)
+			I.TableNextColumn()
+			I.Text(r.path)
+		}
+	}
+}
+
+func drawProcessWindow() {
+	pw := &HD.Process
+	if !pw.open {
+		return
+	}
+	status := "Double click a region to open it."
+	if pw.err != "" {
+		status = pw.err
+	}
+	G.Window("Open Process").IsOpen(&pw.open).Pos(60, 60).Size(800, 500).Layout(
+		G.Row(
+			G.InputText(&pw.filter).Label("Filter").Size(200),
+			G.Button("Refresh").OnClick(pw.refresh),
+			G.Checkbox("Readable Regions Only", &pw.readable),
+		),
+		G.Label(status),
+		G.SplitLayout(G.DirectionHorizontal, true, 250,
+			G.Custom(pw.processTable),
+			G.Custom(pw.regionTable),
+		),
+	)
+}
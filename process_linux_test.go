@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+//the child of TestProcessChild: 3 pages with a hole in the middle, changed on
+//commands from stdin
+func TestProcessHelper(t *testing.T) {
+	if os.Getenv("HEXDUNK_TEST_CHILD") != "1" {
+		return
+	}
+	prot, flags := syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE
+	mem, err := syscall.Mmap(-1, 0, 3*procPageSize, prot, flags)
+	if err != nil {
+		os.Exit(2)
+	}
+	copy(mem, "hexdunk")
+	copy(mem[2*procPageSize:], "page 2")
+	addr := uintptr(unsafe.Pointer(&mem[0]))
+	syscall.Syscall(syscall.SYS_MUNMAP, addr+procPageSize, procPageSize, 0)
+	fmt.Printf("%x\n", addr)
+
+	scan := bufio.NewScanner(os.Stdin)
+	for scan.Scan() {
+		switch scan.Text() {
+		case "set":
+			mem[0], mem[2*procPageSize] = 'H', 'P'
+		case "map":
+			_, _, e := syscall.Syscall6(syscall.SYS_MMAP, addr+procPageSize, procPageSize,
+				uintptr(prot), uintptr(flags|syscall.MAP_FIXED), ^uintptr(0), 0)
+			if e != 0 {
+				os.Exit(2)
+			}
+			mem[procPageSize] = 'M'
+		}
+		fmt.Println("ok")
+	}
+	os.Exit(0)
+}
+
+func TestProcessChild(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestProcessHelper$")
+	cmd.Env = append(os.Environ(), "HEXDUNK_TEST_CHILD=1")
+	stdin, _ := cmd.StdinPipe()
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+	out := bufio.NewReader(stdout)
+	line, err := out.ReadString('\n')
+	if err != nil {
+		t.Fatalf("child: %v", err)
+	}
+	addr, err := strconv.ParseInt(strings.TrimSpace(line), 16, 64)
+	if err != nil {
+		t.Fatalf("child said %q", line)
+	}
+	pid := cmd.Process.Pid
+	send := func(c string) {
+		fmt.Fprintln(stdin, c)
+		if line, err := out.ReadString('\n'); err != nil || line != "ok\n" {
+			t.Fatalf("child: %q %v", line, err)
+		}
+	}
+
+	//the pages are mapped, the hole isn't
+	regions, err := processRegions(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped := func(a int64) bool {
+		for _, r := range regions {
+			if a >= r.start && a < r.end {
+				return strings.HasPrefix(r.perms, "rw")
+			}
+		}
+		return false
+	}
+	if !mapped(addr) || mapped(addr+procPageSize) || !mapped(addr+2*procPageSize) {
+		t.Fatalf("the pages at %X aren't mapped as made: %v", addr, regions)
+	}
+
+	r := procRegion{start: addr, end: addr + 3*procPageSize, perms: "rw-p"}
+	hf, err := loadProcessRegion(pid, r)
+	if err != nil {
+		t.Skipf("can't read the memory of a child: %v", err)
+	}
+	page := func(p int64, n int) string {
+		data := contents(hf.buf)
+		return string(data[p*procPageSize : p*procPageSize+int64(n)])
+	}
+	if page(0, 7) != "hexdunk" || page(2, 6) != "page 2" {
+		t.Fatalf("read %q %q", page(0, 7), page(2, 6))
+	}
+	if !hf.proc.isUnreadable(procPageSize) || hf.proc.isUnreadable(0) {
+		t.Errorf("unreadable pages %v", hf.proc.unreadable)
+	}
+
+	mem, err := os.Open(hf.proc.memPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mem.Close()
+	size := hf.buf.Size()
+
+	//the child writes, the new bytes are read
+	send("set")
+	if !hf.updateProcess(mem, 0, size) || page(0, 7) != "Hexdunk" || page(2, 6) != "Page 2" {
+		t.Errorf("after set: %q %q", page(0, 7), page(2, 6))
+	}
+	//the hole is mapped
+	send("map")
+	if !hf.updateProcess(mem, 0, size) || page(1, 1) != "M" || len(hf.proc.unreadable) != 0 {
+		t.Errorf("after map: %q, unreadable %v", page(1, 1), hf.proc.unreadable)
+	}
+
+	//edits go back into the child
+	hf.Replace(3, 4, B.NewMem([]byte("DUNK")))
+	if err := writeProcess(hf); err != nil {
+		t.Fatal(err)
+	}
+	hf.resetOriginal()
+	if hf.updateProcess(mem, 0, size) {
+		t.Errorf("changed after writing")
+	}
+	check := make([]byte, 7)
+	mem.ReadAt(check, addr)
+	if string(check) != "HexDUNK" {
+		t.Errorf("the child has %q", check)
+	}
+}
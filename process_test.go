@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+func TestParseMaps(t *testing.T) {
+	maps := `55d0c8a00000-55d0c8a02000 r--p 00000000 fd:01 1234    /usr/bin/cat
+55d0c8a02000-55d0c8a07000 r-xp 00002000 fd:01 1234    /usr/bin/cat
+7f0e1c000000-7f0e1c021000 rw-p 00000000 00:00 0
+7ffd5e4f0000-7ffd5e511000 rw-p 00000000 00:00 0       [stack]
+7f0e1d000000-7f0e1d001000 r--p 00000000 fd:01 99      /tmp/a file with spaces
+ffffffffff600000-ffffffffff601000 --xp 00000000 00:00 0 [vsyscall]
+`
+	regions, err := parseMaps(strings.NewReader(maps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []procRegion{
+		{0x55d0c8a00000, 0x55d0c8a02000, "r--p", "/usr/bin/cat"},
+		{0x55d0c8a02000, 0x55d0c8a07000, "r-xp", "/usr/bin/cat"},
+		{0x7f0e1c000000, 0x7f0e1c021000, "rw-p", ""},
+		{0x7ffd5e4f0000, 0x7ffd5e511000, "rw-p", "[stack]"},
+		{0x7f0e1d000000, 0x7f0e1d001000, "r--p", "/tmp/a file with spaces"},
+	}
+	if !reflect.DeepEqual(regions, want) {
+		t.Errorf("got %+v\nwant %+v", regions, want)
+	}
+	for _, bad := range []string{"55d0c8a00000 r--p 00000000 fd:01 1234", "xyz-55d0c8a02000 r--p 00000000 fd:01 1234"} {
+		if _, err := parseMaps(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+//memory of a process at base, reading a page in bad fails like in /proc/PID/mem
+type fakeMem struct {
+	base int64
+	data []byte
+	bad  map[int64]bool //offsets of unmapped pages
+}
+
+func (m *fakeMem) ReadAt(p []byte, addr int64) (int, error) {
+	off := addr - m.base
+	for i := range p {
+		if m.bad[(off+int64(i))/procPageSize*procPageSize] || off+int64(i) >= int64(len(m.data)) {
+			return i, io.ErrUnexpectedEOF
+		}
+		p[i] = m.data[off+int64(i)]
+	}
+	return len(p), nil
+}
+
+func TestReadRegion(t *testing.T) {
+	const base = 0x10000
+	mem := &fakeMem{base: base, data: bytes.Repeat([]byte{0xAB}, 5*procPageSize),
+		bad: map[int64]bool{procPageSize: true, 3 * procPageSize: true}}
+	r := procRegion{start: base, end: base + 5*procPageSize - 100}
+	data, unreadable := readRegion(mem, r)
+	if want := []int64{procPageSize, 3 * procPageSize}; !reflect.DeepEqual(unreadable, want) {
+		t.Errorf("unreadable %v, want %v", unreadable, want)
+	}
+	for i, c := range data {
+		bad := mem.bad[int64(i)/procPageSize*procPageSize]
+		if bad && c != 0 || !bad && c != 0xAB {
+			t.Fatalf("byte %d is %02X", i, c)
+		}
+	}
+	//a part of it
+	data, unreadable = readRange(mem, r, 2*procPageSize, 2*procPageSize)
+	if len(data) != 2*procPageSize || !reflect.DeepEqual(unreadable, []int64{3 * procPageSize}) {
+		t.Errorf("range: %d bytes, unreadable %v", len(data), unreadable)
+	}
+}
+
+func TestUpdateProcess(t *testing.T) {
+	const base = 0x10000
+	mem := &fakeMem{base: base, data: bytes.Repeat([]byte("0123456789abcdef"), procPageSize/4),
+		bad: map[int64]bool{2 * procPageSize: true}}
+	r := procRegion{start: base, end: base + int64(len(mem.data)), perms: "rw-p"}
+	data, unreadable := readRegion(mem, r)
+	hf := &HexFile{buf: B.NewMem(data), kind: KindProcess, proc: &procMem{region: r, unreadable: unreadable}}
+	hf.resetOriginal()
+	size := int64(len(data))
+
+	//nothing changed
+	if hf.updateProcess(mem, 0, size) {
+		t.Errorf("changed without a change")
+	}
+
+	//the process writes, the page comes back
+	mem.data[10] = 'X'
+	delete(mem.bad, 2*procPageSize)
+	if !hf.updateProcess(mem, 0, size) {
+		t.Fatalf("change not seen")
+	}
+	if len(hf.proc.unreadable) != 0 || hf.proc.isUnreadable(2*procPageSize) {
+		t.Errorf("unreadable %v", hf.proc.unreadable)
+	}
+	got := contents(hf.buf)
+	if !bytes.Equal(got, mem.data) {
+		t.Errorf("the buffer isn't the process memory")
+	}
+	if hf.version != hf.savedVersion {
+		t.Errorf("reading the process made an edit")
+	}
+
+	//edits are kept, the bytes after an insert are shifted
+	hf.Replace(0, 0, B.NewMem([]byte("++")))
+	hf.Replace(20, 2, B.NewMem([]byte("ed")))
+	mem.data[17], mem.data[30], mem.data[3*procPageSize] = 'Y', 'Z', 'W'
+	mem.bad[procPageSize] = true
+	//only the first page is on screen
+	hf.updateProcess(mem, 0, 100)
+	got = contents(hf.buf)
+	if got[2+17] != 'Y' || string(got[20:22]) != "ed" || got[2+30] != 'Z' {
+		t.Errorf("buffer %q", got[:40])
+	}
+	if got[2+3*procPageSize] == 'W' || hf.proc.isUnreadable(procPageSize) {
+		t.Errorf("read outside of the screen")
+	}
+	if orig := contents(hf.orig); orig[30] != 'Z' || orig[18] != mem.data[18] {
+		t.Errorf("original %q", orig[:40])
+	}
+	checkHunks(t, hf)
+
+	//on screen at the end, the page is gone again
+	hf.updateProcess(mem, size-2*procPageSize, 2*procPageSize+2)
+	if got = contents(hf.buf); got[2+3*procPageSize] != 'W' {
+		t.Errorf("the end wasn't read")
+	}
+	hf.updateProcess(mem, procPageSize, 10)
+	if !hf.proc.isUnreadable(procPageSize) || !bytes.Equal(contents(hf.orig)[procPageSize:2*procPageSize], make([]byte, procPageSize)) {
+		t.Errorf("an unreadable page isn't zeroes: %v", hf.proc.unreadable)
+	}
+	checkHunks(t, hf)
+}
@@ -0,0 +1,193 @@
+package main
+
+//the project file: annotations and bookmarks of a file, saved in a sidecar file next to it
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"io/fs"
+	"os"
+)
+
+const projectExt = ".hexdunk"
+
+type projectFile struct {
+	Annotations []projectAnnotation `json:"annotations,omitempty"`
+	Bookmarks   []projectBookmark   `json:"bookmarks,omitempty"`
+	Marks       map[string]int64    `json:"marks,omitempty"`
+}
+
+type projectAnnotation struct {
+	Offset int64  `json:"offset"`
+	Size   int64  `json:"size"`
+	Color  string `json:"color"` //#rrggbbaa
+	Label  string `json:"label"`
+	Note   string `json:"note,omitempty"`
+}
+
+type projectBookmark struct {
+	Name    string `json:"name"`
+	Offset  int64  `json:"offset"`
+	Comment string `json:"comment,omitempty"`
+}
+
+func projectPath(path string) string {
+	return path + projectExt
+}
+
+func formatColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
+
+func parseColor(s string) (color.RGBA, error) {
+	var c color.RGBA
+	_, err := fmt.Sscanf(s, "#%02x%02x%02x%02x", &c.R, &c.G, &c.B, &c.A)
+	return c, err
+}
+
+func (hf *HexFile) project() projectFile {
+	var p projectFile
+	for _, a := range hf.annotations {
+		p.Annotations = append(p.Annotations, projectAnnotation{
+			Offset: a.off,
+			Size:   a.size,
+			Color:  formatColor(a.color),
+			Label:  a.label,
+			Note:   a.note,
+		})
+	}
+	for _, b := range hf.marks.bookmarks {
+		p.Bookmarks = append(p.Bookmarks, projectBookmark{Name: b.name, Offset: b.off, Comment: b.comment})
+	}
+	for _, c := range hf.marks.markNames() {
+		if p.Marks == nil {
+			p.Marks = make(map[string]int64)
+		}
+		p.Marks[string(c)], _ = hf.marks.mark(c)
+	}
+	return p
+}
+
+func (hf *HexFile) setProject(p projectFile) error {
+	var annotations fileAnnotations
+	for _, a := range p.Annotations {
+		c, err := parseColor(a.Color)
+		if err != nil {
+			return fmt.Errorf("annotation %s: bad colour %s", a.Label, a.Color)
+		}
+		if a.Offset < 0 || a.Size <= 0 {
+			return fmt.Errorf("annotation %s: bad range %d (%d bytes)", a.Label, a.Offset, a.Size)
+		}
+		annotations = append(annotations, &annotation{off: a.Offset, size: a.Size, color: c, label: a.Label, note: a.Note})
+	}
+	var marks fileMarks
+	for _, b := range p.Bookmarks {
+		marks.addBookmark(b.Name, b.Comment, b.Offset)
+	}
+	for name, off := range p.Marks {
+		if len(name) != 1 || name[0] < 'a' || name[0] > 'z' {
+			return fmt.Errorf("bad mark name '%s'", name)
+		}
+		marks.setMark(name[0], off)
+	}
+	annotations.sort()
+	hf.annotations = annotations
+	hf.marks = marks
+	return nil
+}
+
+func (hf *HexFile) projectJSON() ([]byte, error) {
+	return json.MarshalIndent(hf.project(), "", "\t")
+}
+
+//load the project file of path, if there is one
+func (hf *HexFile) loadProject(path string) error {
+	data, err := os.ReadFile(projectPath(path))
+	if errors.Is(err, fs.ErrNotExist) {
+		hf.projectSaved, _ = hf.projectJSON()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var p projectFile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("%s: %v", projectPath(path), err)
+	}
+	if err := hf.setProject(p); err != nil {
+		return fmt.Errorf("%s: %v", projectPath(path), err)
+	}
+	hf.projectSaved, _ = hf.projectJSON()
+	return nil
+}
+
+//save the project file for path, an empty project is only written over an existing one
+func (hf *HexFile) saveProject(path string) error {
+	data, err := hf.projectJSON()
+	if err != nil {
+		return err
+	}
+	if err := writeProject(path, data); err != nil {
+		return err
+	}
+	hf.projectSaved = data
+	return nil
+}
+
+func writeProject(path string, data []byte) error {
+	p := projectPath(path)
+	if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) && string(data) == "{}" {
+		return nil
+	}
+	return os.WriteFile(p, append(data, '\n'), 0644)
+}
+
+//the project with the offsets in the file on disk, before the unsaved edits.
+//annotations of only deleted bytes are dropped.
+func (hf *HexFile) savedProject() projectFile {
+	p := hf.project()
+	hunks := hf.Changes()
+	orig := func(off int64) int64 {
+		return mapAddr(hunks, off, 1)
+	}
+	var annotations []projectAnnotation
+	for _, a := range p.Annotations {
+		off, end := orig(a.Offset), orig(a.Offset+a.Size)
+		if end > off {
+			a.Offset, a.Size = off, end-off
+			annotations = append(annotations, a)
+		}
+	}
+	p.Annotations = annotations
+	for i := range p.Bookmarks {
+		p.Bookmarks[i].Offset = orig(p.Bookmarks[i].Offset)
+	}
+	for name, off := range p.Marks {
+		p.Marks[name] = orig(off)
+	}
+	return p
+}
+
+//annotations or bookmarks changed since the last load/save
+func (hf *HexFile) projectChanged() bool {
+	data, err := hf.projectJSON()
+	return err != nil || string(data) != string(hf.projectSaved)
+}
+
+//save the project when closing the file. With unsaved edits the offsets are
+//those of the file on disk, the edits are lost but the annotations aren't.
+func (hf *HexFile) closeProject() error {
+	if !hf.onDisk() || !hf.projectChanged() {
+		return nil
+	}
+	if hf.version == hf.savedVersion {
+		return hf.saveProject(hf.name)
+	}
+	data, err := json.MarshalIndent(hf.savedProject(), "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeProject(hf.name, data)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+//closing with unsaved edits writes the offsets of the file on disk
+func TestCloseProjectUnsaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.bin")
+	os.WriteFile(path, []byte("0123456789abcdef"), 0644)
+	hf := &HexFile{name: path, buf: B.NewMem([]byte("0123456789abcdef"))}
+	hf.resetOriginal()
+	hf.annotations = fileAnnotations{
+		{off: 2, size: 4, color: color.RGBA{A: 255}, label: "shifted"},
+		{off: 8, size: 2, color: color.RGBA{A: 255}, label: "deleted"},
+	}
+	hf.marks.addBookmark("b", "", 12)
+	hf.marks.setMark('m', 14)
+
+	hf.Replace(0, 0, B.NewMem([]byte("++++")))
+	hf.Replace(12, 2, B.NewMem(nil))
+	if err := hf.closeProject(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(projectPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p projectFile
+	if err := json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Annotations) != 1 || p.Annotations[0].Offset != 2 || p.Annotations[0].Size != 4 {
+		t.Errorf("annotations %+v", p.Annotations)
+	}
+	if len(p.Bookmarks) != 1 || p.Bookmarks[0].Offset != 12 || p.Marks["m"] != 14 {
+		t.Errorf("bookmarks %+v, marks %v", p.Bookmarks, p.Marks)
+	}
+	//the annotations in the editor still follow the edits
+	if hf.annotations[0].off != 6 {
+		t.Errorf("the open file changed: %+v", hf.annotations[0])
+	}
+}
@@ -0,0 +1,544 @@
+package main
+
+//scripts in starlark (a python dialect, https://github.com/bazelbuild/starlark), run from
+//the script console or the command line (-script). They work on a file and a view of it
+//with the same edits as the keyboard; all edits of a run are undone as one.
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+	B "github.com/snhmibby/filebuf"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+)
+
+func init() {
+	//scripts, not config files: allow loops and reassignment at top level
+	resolve.AllowGlobalReassign = true
+	resolve.AllowRecursion = true
+	resolve.AllowLambda = true
+	resolve.AllowNestedDef = true
+	resolve.AllowSet = true
+}
+
+//what a script works on: the active tab, or a file opened on the command line
+type scriptTarget struct {
+	file *HexFile
+	view *ViewState
+}
+
+type scriptFunc func(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+
+var scriptFuncs = map[string]scriptFunc{
+	"name":       scriptName,
+	"size":       scriptSize,
+	"read":       scriptRead,
+	"peek":       scriptPeek,
+	"write":      scriptWrite,
+	"poke":       scriptPoke,
+	"insert":     scriptInsert,
+	"delete":     scriptDelete,
+	"cursor":     scriptCursor,
+	"set_cursor": scriptSetCursor,
+	"selection":  scriptSelection,
+	"select":     scriptSelect,
+	"find":       scriptFind,
+	"hash":       scriptHash,
+	"annotate":   scriptAnnotate,
+	"bookmark":   scriptBookmark,
+}
+
+//the predeclared functions, bound to t
+func (t *scriptTarget) builtins() starlark.StringDict {
+	d := make(starlark.StringDict)
+	for name, f := range scriptFuncs {
+		f := f
+		d[name] = starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			return f(t, b.Name(), args, kwargs)
+		})
+	}
+	return d
+}
+
+//the console runs scripts on the gui thread, a script that takes more steps than this
+//(a few seconds) is stopped. its edits so far are undone as one
+const scriptMaxSteps = 100000000
+
+//run src, print goes to out. globals of an earlier run (in the console) can be
+//passed to keep them, the new globals are returned. maxSteps 0 is no limit
+func (t *scriptTarget) run(filename string, src string, globals starlark.StringDict, out io.Writer, maxSteps uint64) (starlark.StringDict, error) {
+	predeclared := t.builtins()
+	for k, v := range globals {
+		predeclared[k] = v
+	}
+	thread := &starlark.Thread{
+		Name:  filename,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Fprintln(out, msg) },
+	}
+	if maxSteps > 0 {
+		thread.SetMaxExecutionSteps(maxSteps)
+	}
+	if globals == nil {
+		globals = make(starlark.StringDict)
+	}
+	n := len(t.file.undo)
+	result, err := starlark.ExecFile(thread, filename, src, predeclared)
+	t.file.groupUndo(len(t.file.undo) - n)
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		err = fmt.Errorf("%s", evalErr.Backtrace())
+	}
+	for k, v := range result {
+		globals[k] = v
+	}
+	return globals, err
+}
+
+/*
+ * argument helpers
+ */
+
+func toInt64(fn string, v starlark.Value) (int64, error) {
+	i, ok := v.(starlark.Int)
+	if !ok {
+		return 0, fmt.Errorf("%s: got %s, want int", fn, v.Type())
+	}
+	n, ok := i.Int64()
+	if !ok {
+		return 0, fmt.Errorf("%s: %v is too big", fn, i)
+	}
+	return n, nil
+}
+
+//unpack int64 arguments, names ending in ? are optional and keep their value
+func unpackInts(fn string, args starlark.Tuple, kwargs []starlark.Tuple, names []string, ints ...*int64) error {
+	vals := make([]starlark.Value, len(ints))
+	pairs := make([]interface{}, 0, 2*len(ints))
+	for i := range ints {
+		pairs = append(pairs, names[i], &vals[i])
+	}
+	if err := starlark.UnpackArgs(fn, args, kwargs, pairs...); err != nil {
+		return err
+	}
+	for i, v := range vals {
+		if v == nil {
+			continue
+		}
+		n, err := toInt64(fn, v)
+		if err != nil {
+			return err
+		}
+		*ints[i] = n
+	}
+	return nil
+}
+
+//a string (of bytes) or a sequence of ints
+func toBytes(fn string, v starlark.Value) ([]byte, error) {
+	if s, ok := starlark.AsString(v); ok {
+		return []byte(s), nil
+	}
+	it, ok := v.(starlark.Iterable)
+	if !ok {
+		return nil, fmt.Errorf("%s: got %s, want string or list of ints", fn, v.Type())
+	}
+	var data []byte
+	iter := it.Iterate()
+	defer iter.Done()
+	var x starlark.Value
+	for iter.Next(&x) {
+		b, err := toInt64(fn, x)
+		if err != nil {
+			return nil, err
+		}
+		if b < 0 || b > 255 {
+			return nil, fmt.Errorf("%s: %d is not a byte", fn, b)
+		}
+		data = append(data, byte(b))
+	}
+	return data, nil
+}
+
+func (t *scriptTarget) checkRange(fn string, off, size int64) error {
+	if size < 0 || off < 0 || off+size > t.file.buf.Size() {
+		return fmt.Errorf("%s: %d bytes at %d is outside of the file (%d bytes)", fn, size, off, t.file.buf.Size())
+	}
+	return nil
+}
+
+func (t *scriptTarget) read(off, size int64) []byte {
+	data := make([]byte, size)
+	t.file.buf.Seek(off, io.SeekStart)
+	io.ReadFull(t.file.buf, data)
+	return data
+}
+
+/*
+ * the functions
+ */
+
+func scriptName(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn, args, kwargs); err != nil {
+		return nil, err
+	}
+	return starlark.String(t.file.name), nil
+}
+
+func scriptSize(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn, args, kwargs); err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt64(t.file.buf.Size()), nil
+}
+
+//read(off, size): the bytes as a string
+func scriptRead(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var off, size int64
+	if err := unpackInts(fn, args, kwargs, []string{"off", "size"}, &off, &size); err != nil {
+		return nil, err
+	}
+	if err := t.checkRange(fn, off, size); err != nil {
+		return nil, err
+	}
+	return starlark.String(t.read(off, size)), nil
+}
+
+//peek(off): the byte at off as an int
+func scriptPeek(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var off int64
+	if err := unpackInts(fn, args, kwargs, []string{"off"}, &off); err != nil {
+		return nil, err
+	}
+	if err := t.checkRange(fn, off, 1); err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt(int(t.read(off, 1)[0])), nil
+}
+
+//replace size bytes at off with data
+func (t *scriptTarget) replace(fn string, off, size int64, data []byte) error {
+	if err := t.checkRange(fn, off, size); err != nil {
+		return err
+	}
+	return t.file.Replace(off, size, B.NewMem(data))
+}
+
+//write(off, data): overwrite, the file grows if data runs past EOF
+func scriptWrite(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var offv, datav starlark.Value
+	if err := starlark.UnpackArgs(fn, args, kwargs, "off", &offv, "data", &datav); err != nil {
+		return nil, err
+	}
+	off, err := toInt64(fn, offv)
+	if err != nil {
+		return nil, err
+	}
+	data, err := toBytes(fn, datav)
+	if err != nil {
+		return nil, err
+	}
+	size := int64(len(data))
+	if off+size > t.file.buf.Size() {
+		size = t.file.buf.Size() - off
+	}
+	return starlark.None, t.replace(fn, off, size, data)
+}
+
+//poke(off, byte)
+func scriptPoke(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var off, b int64
+	if err := unpackInts(fn, args, kwargs, []string{"off", "byte"}, &off, &b); err != nil {
+		return nil, err
+	}
+	if b < 0 || b > 255 {
+		return nil, fmt.Errorf("%s: %d is not a byte", fn, b)
+	}
+	return starlark.None, t.replace(fn, off, 1, []byte{byte(b)})
+}
+
+//insert(off, data)
+func scriptInsert(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var offv, datav starlark.Value
+	if err := starlark.UnpackArgs(fn, args, kwargs, "off", &offv, "data", &datav); err != nil {
+		return nil, err
+	}
+	off, err := toInt64(fn, offv)
+	if err != nil {
+		return nil, err
+	}
+	data, err := toBytes(fn, datav)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.None, t.replace(fn, off, 0, data)
+}
+
+//delete(off, size)
+func scriptDelete(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var off, size int64
+	if err := unpackInts(fn, args, kwargs, []string{"off", "size"}, &off, &size); err != nil {
+		return nil, err
+	}
+	return starlark.None, t.replace(fn, off, size, nil)
+}
+
+func scriptCursor(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn, args, kwargs); err != nil {
+		return nil, err
+	}
+	return starlark.MakeInt64(t.view.cursor), nil
+}
+
+func scriptSetCursor(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var off int64
+	if err := unpackInts(fn, args, kwargs, []string{"off"}, &off); err != nil {
+		return nil, err
+	}
+	t.file.ClampAddr(&off)
+	t.view.cursor = off
+	t.view.ScrollTo(off)
+	return starlark.None, nil
+}
+
+//selection(): (off, size), size is 0 for no selection
+func scriptSelection(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackArgs(fn, args, kwargs); err != nil {
+		return nil, err
+	}
+	off, size := t.view.Selection()
+	return starlark.Tuple{starlark.MakeInt64(off), starlark.MakeInt64(size)}, nil
+}
+
+//select(off, size)
+func scriptSelect(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var off, size int64
+	if err := unpackInts(fn, args, kwargs, []string{"off", "size"}, &off, &size); err != nil {
+		return nil, err
+	}
+	if err := t.checkRange(fn, off, size); err != nil {
+		return nil, err
+	}
+	t.view.SetSelection(off, size)
+	return starlark.None, nil
+}
+
+//find(data, start=0): offset of the first match from start, -1 if none
+func scriptFind(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var datav, startv starlark.Value
+	if err := starlark.UnpackArgs(fn, args, kwargs, "data", &datav, "start?", &startv); err != nil {
+		return nil, err
+	}
+	pat, err := toBytes(fn, datav)
+	if err != nil {
+		return nil, err
+	}
+	var start int64
+	if startv != nil {
+		if start, err = toInt64(fn, startv); err != nil {
+			return nil, err
+		}
+	}
+	return starlark.MakeInt64(findBytes(t.file.buf, pat, start)), nil
+}
+
+//hash(algo, off=0, size=-1): hex digest, algo as in Tools -> Hash (case doesn't matter)
+func scriptHash(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var algo string
+	var offv, sizev starlark.Value
+	if err := starlark.UnpackArgs(fn, args, kwargs, "algo", &algo, "off?", &offv, "size?", &sizev); err != nil {
+		return nil, err
+	}
+	var h hash.Hash
+	var names []string
+	for _, a := range hashAlgos {
+		names = append(names, a.name)
+		if strings.EqualFold(a.name, algo) {
+			h = a.new()
+		}
+	}
+	if h == nil {
+		return nil, fmt.Errorf("%s: unknown algorithm %s, want one of %s", fn, algo, strings.Join(names, ", "))
+	}
+	off, size := int64(0), int64(-1)
+	var err error
+	if offv != nil {
+		if off, err = toInt64(fn, offv); err != nil {
+			return nil, err
+		}
+	}
+	if sizev != nil {
+		if size, err = toInt64(fn, sizev); err != nil {
+			return nil, err
+		}
+	}
+	if size < 0 {
+		size = t.file.buf.Size() - off
+	}
+	if err := t.checkRange(fn, off, size); err != nil {
+		return nil, err
+	}
+	var b *B.Buffer
+	if size == 0 {
+		b = B.NewMem(nil)
+	} else {
+		b = t.file.buf.Copy(off, size)
+	}
+	hashBuffer(b, []hash.Hash{h}, func(int64) bool { return true })
+	return starlark.String(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+//annotate(off, size, label, note="")
+func scriptAnnotate(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var offv, sizev starlark.Value
+	var label, note string
+	if err := starlark.UnpackArgs(fn, args, kwargs, "off", &offv, "size", &sizev, "label", &label, "note?", &note); err != nil {
+		return nil, err
+	}
+	off, err := toInt64(fn, offv)
+	if err != nil {
+		return nil, err
+	}
+	size, err := toInt64(fn, sizev)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkRange(fn, off, size); err != nil {
+		return nil, err
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("%s: empty range", fn)
+	}
+	t.file.annotations.add(off, size, label).note = note
+	return starlark.None, nil
+}
+
+//bookmark(name, off, comment="")
+func scriptBookmark(t *scriptTarget, fn string, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, comment string
+	var offv starlark.Value
+	if err := starlark.UnpackArgs(fn, args, kwargs, "name", &name, "off", &offv, "comment?", &comment); err != nil {
+		return nil, err
+	}
+	off, err := toInt64(fn, offv)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkRange(fn, off, 0); err != nil {
+		return nil, err
+	}
+	t.file.marks.addBookmark(name, comment, off)
+	return starlark.None, nil
+}
+
+//run the script at path on files without the gui, edited files are saved.
+//returns the exit status
+func runScriptHeadless(path string, files []string) int {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, p := range files {
+		if err := runScriptOn(path, string(src), p); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", p, err)
+			status = 1
+		}
+	}
+	return status
+}
+
+func runScriptOn(filename, src, path string) error {
+	hf, _, err := loadHexFile(path)
+	if err != nil {
+		return err
+	}
+	defer hf.removeTemp()
+	if err := hf.loadProject(path); err != nil {
+		return err
+	}
+	hf.readOnly = hf.readOnly || *flagReadOnly
+	view := new(ViewState)
+	view.cursor = cmdOffset - hf.fw.base
+	hf.ClampAddr(&view.cursor)
+
+	t := &scriptTarget{file: hf, view: view}
+	if _, err := t.run(filename, src, make(starlark.StringDict), os.Stdout, 0); err != nil {
+		return err
+	}
+	return hf.saveHeadless()
+}
+
+/*
+ * Script console
+ */
+
+type scriptConsole struct {
+	open    bool
+	src     string
+	output  string
+	globals starlark.StringDict //kept between runs
+	target  scriptTarget        //the functions of all runs are bound to it
+}
+
+func (sc *scriptConsole) run(filename, src string) {
+	tab, file := ActiveTab(), ActiveFile()
+	if tab == nil || file == nil {
+		sc.output += "no file opened\n"
+		return
+	}
+	if file.readOnly {
+		sc.output += fmt.Sprintf("%s is opened read-only, edits will fail\n", file.name)
+	}
+	var out strings.Builder
+	sc.target = scriptTarget{file: file, view: tab.view}
+	var err error
+	sc.globals, err = sc.target.run(filename, src, sc.globals, &out, scriptMaxSteps)
+	sc.output += out.String()
+	if err != nil {
+		sc.output += err.Error() + "\n"
+	}
+}
+
+func (sc *scriptConsole) runFile(path string) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		ErrorDialog(fmt.Sprintf("Reading Script <%s>", path), err.Error())
+		return
+	}
+	sc.open = true
+	sc.output += fmt.Sprintf(">>> %s\n", path)
+	sc.run(path, string(src))
+}
+
+func drawScriptConsole() {
+	sc := &HD.Script
+	if !sc.open {
+		return
+	}
+	G.Window("Script Console").IsOpen(&sc.open).Pos(100, 100).Size(600, 500).Layout(
+		G.Row(
+			G.Button("Run").OnClick(func() {
+				sc.output += ">>> " + strings.ReplaceAll(sc.src, "\n", "\n... ") + "\n"
+				sc.run("console", sc.src)
+			}),
+			G.Button("Run File").OnClick(actionRunScript),
+			G.Button("Clear Output").OnClick(func() { sc.output = "" }),
+			G.Button("Reset").OnClick(func() { sc.globals = nil }),
+		),
+		G.SplitLayout(G.DirectionVertical, true, 300,
+			G.Custom(func() {
+				I.InputTextMultilineV("##scriptoutput", &sc.output, I.Vec2{X: -1, Y: -1}, I.InputTextFlagsReadOnly, nil)
+			}),
+			G.Custom(func() {
+				I.InputTextMultilineV("##scriptsrc", &sc.src, I.Vec2{X: -1, Y: -1}, I.InputTextFlagsAllowTabInput, nil)
+			}),
+		),
+	)
+}
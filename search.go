@@ -0,0 +1,265 @@
+package main
+
+//find all: every occurrence of a text or hex pattern in the file is highlighted, n and
+//N go to the next and previous one. after an edit the file is searched again in the
+//background.
+
+import (
+	"bytes"
+	"image/color"
+	"io"
+	"sort"
+	"sync"
+
+	G "github.com/AllenDang/giu"
+	B "github.com/snhmibby/filebuf"
+)
+
+//more hits than this aren't useful
+const maxSearchHits = 100000
+
+var searchHitBG = color.RGBA{R: 230, G: 210, B: 0, A: 110}
+
+type fileSearch struct {
+	mu      sync.Mutex
+	pattern []byte
+	version int //file version the hits (or the running search) belong to
+	gen     int //bumped by every search, a search that isn't the last one stops
+	busy    bool
+	report  bool    //go to the first hit (or say there are none) when the search is done
+	hits    []int64 //sorted
+}
+
+//offsets of pat in b, at most max of them. cancel stops the search
+func findAll(b *B.Buffer, pat []byte, max int, cancel func() bool) []int64 {
+	const chunk = 1 << 20
+	var hits []int64
+	size := b.Size()
+	data := make([]byte, chunk+len(pat)-1)
+	for off := int64(0); off < size && len(hits) < max; off += chunk {
+		if cancel != nil && cancel() {
+			return nil
+		}
+		n := int64(len(data))
+		if size-off < n {
+			n = size - off
+		}
+		b.Seek(off, io.SeekStart)
+		io.ReadFull(b, data[:n])
+		//hits starting in the overlap are found in the next chunk
+		for i := 0; len(hits) < max; i++ {
+			j := bytes.Index(data[i:n], pat)
+			if j < 0 || i+j >= chunk {
+				break
+			}
+			i += j
+			hits = append(hits, off+int64(i))
+		}
+	}
+	return hits
+}
+
+//search the current version of the file in the background, a newer search cancels
+//this one. s.mu must be held
+func (hf *HexFile) startSearch() {
+	s := &hf.search
+	s.gen++
+	s.version = hf.version
+	s.busy = true
+	gen, pattern := s.gen, s.pattern
+	buf := hf.buf.Copy(0, hf.buf.Size())
+	go func() {
+		hits := findAll(buf, pattern, maxSearchHits, func() bool {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			return gen != s.gen
+		})
+		s.mu.Lock()
+		if gen == s.gen {
+			s.hits = hits
+			s.busy = false
+		}
+		s.mu.Unlock()
+		G.Update()
+	}()
+}
+
+//search the file for pattern in the background (nil to stop searching). when it is
+//done, the cursor goes to the first hit
+func (hf *HexFile) Search(pattern []byte) {
+	s := &hf.search
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pattern = pattern
+	s.hits = nil
+	if pattern == nil {
+		s.gen++ //stop a running search
+		s.version = hf.version
+		s.busy = false
+		s.report = false
+		return
+	}
+	s.report = true
+	hf.startSearch()
+}
+
+//search again in the background if the file was edited since the last search, and
+//report a finished find all. called every frame for the active file
+func (hf *HexFile) updateSearch() {
+	s := &hf.search
+	s.mu.Lock()
+	if s.version != hf.version && s.pattern != nil {
+		hf.startSearch()
+	}
+	report := s.report && !s.busy
+	if report {
+		s.report = false
+	}
+	s.mu.Unlock()
+	if report {
+		hits, _ := hf.SearchHits()
+		if len(hits) == 0 {
+			InfoDialog("Find", "Not found")
+		} else {
+			actionFindNext(true)
+		}
+	}
+}
+
+//the hits of the last search and the length of the pattern
+func (hf *HexFile) SearchHits() ([]int64, int64) {
+	hf.search.mu.Lock()
+	defer hf.search.mu.Unlock()
+	return hf.search.hits, int64(len(hf.search.pattern))
+}
+
+//colour the bytes of every hit
+func (hf *HexFile) searchHighlighter() highlighter {
+	hits, n := hf.SearchHits()
+	return func(addr int64) (color.RGBA, bool) {
+		//last hit that starts at or before addr
+		i := sort.Search(len(hits), func(i int) bool { return hits[i] > addr }) - 1
+		if i >= 0 && addr < hits[i]+n {
+			return searchHitBG, true
+		}
+		return color.RGBA{}, false
+	}
+}
+
+//the next hit after off (or the previous one before it), with wrap around
+func nextHit(hits []int64, off int64, forward bool) (int64, bool) {
+	if len(hits) == 0 {
+		return 0, false
+	}
+	if forward {
+		i := sort.Search(len(hits), func(i int) bool { return hits[i] > off })
+		return hits[i%len(hits)], true
+	}
+	i := sort.Search(len(hits), func(i int) bool { return hits[i] >= off }) - 1
+	if i < 0 {
+		i = len(hits) - 1
+	}
+	return hits[i], true
+}
+
+/*
+ * the find dialog
+ */
+
+type findDialog struct {
+	id   string
+	open bool
+
+	text  string
+	isHex bool
+}
+
+func (d *findDialog) Dispose() {}
+
+func (d *findDialog) saveState() {
+	G.Context.SetState(d.id, d)
+}
+
+func (d *findDialog) close() {
+	d.saveState()
+	G.CloseCurrentPopup()
+}
+
+func (d *findDialog) find() {
+	hf := ActiveFile()
+	if hf == nil {
+		d.close()
+		return
+	}
+	pattern := []byte(d.text)
+	if d.isHex {
+		var err error
+		if pattern, err = parseHexBytes(d.text); err != nil {
+			ErrorDialog("Find", err.Error())
+			return
+		}
+	}
+	if len(pattern) == 0 {
+		hf.Search(nil)
+		d.close()
+		return
+	}
+	hf.Search(pattern)
+	d.close()
+}
+
+func getFindDialog(id string) *findDialog {
+	r := G.Context.GetState(id)
+	if r == nil {
+		d := &findDialog{id: id}
+		d.saveState()
+		return d
+	}
+	return r.(*findDialog)
+}
+
+func prepareFindDialog(id string) G.Widget {
+	d := getFindDialog(id)
+	return G.Custom(func() {
+		if d.open {
+			G.OpenPopup(id)
+			d.open = false
+		}
+		label := "Text"
+		if d.isHex {
+			label = "Hex Bytes"
+		}
+		G.SetNextWindowSizeV(350, 0, G.ConditionOnce)
+		G.PopupModal(id).Layout(
+			G.InputText(&d.text).Label(label),
+			G.Checkbox("Hex", &d.isHex),
+			G.Label("n and N go to the next and previous hit, an empty search clears them"),
+			G.Row(
+				G.Button("Cancel").OnClick(d.close),
+				G.Button("Find All").OnClick(d.find),
+			),
+			G.Custom(func() {
+				if G.IsKeyPressed(G.KeyEscape) {
+					d.close()
+				}
+				if G.IsKeyPressed(G.KeyEnter) {
+					d.find()
+				}
+			}),
+		).Build()
+	})
+}
+
+/*
+ *Public:
+ */
+
+func FindDialog(id string) {
+	d := getFindDialog(id)
+	d.open = true
+	d.saveState()
+}
+
+func PrepareFindDialog(id string) G.Widget {
+	return prepareFindDialog(id)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+func TestFindAll(t *testing.T) {
+	const chunk = 1 << 20
+	big := make([]byte, 3*chunk)
+	for _, off := range []int{0, chunk - 2, chunk + 5, 2*chunk - 1, 3*chunk - 3} {
+		copy(big[off:], "xyz")
+	}
+	//the buffer is made of pieces
+	b := B.NewMem(big[:chunk-1])
+	b.Paste(b.Size(), B.NewMem(big[chunk-1:]))
+
+	tests := []struct {
+		name string
+		buf  *B.Buffer
+		pat  string
+		max  int
+		want []int64
+	}{
+		{"chunk boundaries", b, "xyz", 100, []int64{0, chunk - 2, chunk + 5, 2*chunk - 1, 3*chunk - 3}},
+		{"max", b, "xyz", 2, []int64{0, chunk - 2}},
+		{"none", b, "xyzw", 100, nil},
+		{"overlapping", B.NewMem([]byte("aaaa")), "aa", 100, []int64{0, 1, 2}},
+		{"longer than the file", B.NewMem([]byte("ab")), "abc", 100, nil},
+		{"empty file", B.NewEmpty(), "a", 100, nil},
+	}
+	for _, tc := range tests {
+		if got := findAll(tc.buf, []byte(tc.pat), tc.max, nil); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, got, tc.want)
+		}
+	}
+	if got := findAll(b, []byte("xyz"), 100, func() bool { return true }); got != nil {
+		t.Errorf("canceled search found %v", got)
+	}
+}
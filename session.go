@@ -0,0 +1,171 @@
+package main
+
+//the session: opened tabs and their views, recent files and dialog directories.
+//it is saved on exit and restored on startup. Bookmarks and annotations are saved
+//in the project file of each file when it is closed, and come back when it is reopened.
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const maxRecentFiles = 10
+
+type sessionTab struct {
+	Path           string   `json:"path"`
+	Cursor         int64    `json:"cursor"`
+	TopAddr        int64    `json:"topAddr"`
+	SelectionStart int64    `json:"selectionStart"`
+	SelectionSize  int64    `json:"selectionSize"`
+	EditMode       editMode `json:"editMode"`
+	ReadOnly       bool     `json:"readOnly,omitempty"`
+}
+
+type session struct {
+	Tabs       []sessionTab      `json:"tabs"`
+	ActiveTab  int               `json:"activeTab"`
+	Recent     []string          `json:"recent,omitempty"`
+	DialogDirs map[string]string `json:"dialogDirs,omitempty"`
+
+	HideMinimap bool  `json:"hideMinimap,omitempty"`
+	MinimapMode int32 `json:"minimapMode,omitempty"`
+}
+
+//restored in the first frame, so dialogs can be shown
+var sessionRestored bool
+
+func sessionPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "hexdunk", "session.json"), nil
+}
+
+//put path in front of the recent files
+func addRecentFile(path string) {
+	recent := []string{path}
+	for _, p := range HD.Recent {
+		if p != path && len(recent) < maxRecentFiles {
+			recent = append(recent, p)
+		}
+	}
+	HD.Recent = recent
+}
+
+//new files (and stdin) are temporary files, not worth remembering
+func isTempFile(path string) bool {
+	name := filepath.Base(path)
+	temp := strings.HasPrefix(name, "NewFile") || strings.HasPrefix(name, "stdin")
+	return temp && strings.HasPrefix(path, os.TempDir())
+}
+
+func currentSession() session {
+	s := session{
+		ActiveTab:  HD.ActiveTab,
+		Recent:     HD.Recent,
+		DialogDirs: HD.DialogDirs,
+
+		HideMinimap: HD.Minimap.hidden,
+		MinimapMode: HD.Minimap.mode,
+	}
+	for i, tab := range HD.Tabs {
+		if isTempFile(tab.name) || !HD.Files[tab.name].onDisk() {
+			if i < HD.ActiveTab {
+				s.ActiveTab--
+			}
+			continue
+		}
+		v := tab.view
+		s.Tabs = append(s.Tabs, sessionTab{
+			Path:           tab.name,
+			Cursor:         v.cursor,
+			TopAddr:        v.topAddr,
+			SelectionStart: v.selectionStart,
+			SelectionSize:  v.selectionSize,
+			EditMode:       v.editmode,
+			ReadOnly:       HD.Files[tab.name].readOnly,
+		})
+	}
+	return s
+}
+
+func saveSession() error {
+	p, err := sessionPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(currentSession(), "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, append(data, '\n'), 0644)
+}
+
+//restore the last session, the tabs only if reopenTabs is set.
+//files that can't be opened anymore are skipped
+func restoreSession(reopenTabs bool) error {
+	sessionRestored = true
+	p, err := sessionPath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return mkErr(p, err)
+	}
+	HD.Recent = s.Recent
+	if s.DialogDirs != nil {
+		HD.DialogDirs = s.DialogDirs
+	}
+	HD.Minimap.hidden = s.HideMinimap
+	if s.MinimapMode >= 0 && int(s.MinimapMode) < len(minimapModes) {
+		HD.Minimap.mode = s.MinimapMode
+	}
+
+	if !reopenTabs {
+		return nil
+	}
+
+	var missing []string
+	active := -1
+	for i, t := range s.Tabs {
+		if _, err := OpenHexFile(t.Path); err != nil {
+			missing = append(missing, t.Path)
+			continue
+		}
+		if i == s.ActiveTab {
+			active = len(HD.Tabs) - 1
+		}
+		v := HD.Tabs[len(HD.Tabs)-1].view
+		hf := HD.Files[t.Path]
+		hf.ClampAddr(&t.Cursor)
+		v.cursor = t.Cursor
+		v.ScrollTop(t.TopAddr)
+		if t.SelectionStart+t.SelectionSize <= hf.buf.Size() {
+			v.SetSelection(t.SelectionStart, t.SelectionSize)
+		}
+		v.editmode = t.EditMode
+		//opened read-only stays read-only
+		hf.readOnly = hf.readOnly || t.ReadOnly
+	}
+	HD.SelectTab = active
+	if len(missing) > 0 {
+		return mkErr("Couldn't reopen", errors.New(strings.Join(missing, ", ")))
+	}
+	return nil
+}
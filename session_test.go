@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(dir, "config"))
+	files, tabs, active, recent := HD.Files, HD.Tabs, HD.ActiveTab, HD.Recent
+	t.Cleanup(func() {
+		HD.Files, HD.Tabs, HD.ActiveTab, HD.Recent = files, tabs, active, recent
+	})
+
+	paths := []string{filepath.Join(dir, "evidence.img"), filepath.Join(dir, "notes.bin")}
+	HD.Files, HD.Tabs = make(map[string]*HexFile), nil
+	for _, p := range paths {
+		os.WriteFile(p, []byte("hexdunk"), 0644)
+		if _, err := OpenHexFile(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	HD.Files[paths[0]].readOnly = true
+	HD.ActiveTab = 1
+	if err := saveSession(); err != nil {
+		t.Fatal(err)
+	}
+
+	HD.Files, HD.Tabs = make(map[string]*HexFile), nil
+	if err := restoreSession(true); err != nil {
+		t.Fatal(err)
+	}
+	if len(HD.Tabs) != 2 || !HD.Files[paths[0]].readOnly || HD.Files[paths[1]].readOnly {
+		t.Errorf("%d tabs, read-only %v %v", len(HD.Tabs), HD.Files[paths[0]].readOnly, HD.Files[paths[1]].readOnly)
+	}
+}
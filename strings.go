@@ -0,0 +1,522 @@
+package main
+
+//the strings window: like strings(1), the ASCII, UTF-8 and UTF-16 strings of the active
+//file, found in the background and listed while the scan goes on.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	G "github.com/AllenDang/giu"
+	I "github.com/AllenDang/imgui-go"
+	B "github.com/snhmibby/filebuf"
+)
+
+const (
+	StringASCII = iota
+	StringUTF8
+	StringUTF16LE
+	StringUTF16BE
+)
+
+var stringKinds = []string{"ASCII", "UTF-8", "UTF-16LE", "UTF-16BE"}
+
+//the list stops at this many strings, and longer texts are cut
+const (
+	maxStrings    = 1000000
+	maxStringText = 256
+)
+
+type foundString struct {
+	off, size int64
+	kind      int
+	text      string //at most maxStringText bytes
+}
+
+//a run of printable characters
+type stringRun struct {
+	kind  int
+	min   int
+	need  func(r *stringRun) bool //extra condition to keep a long enough run
+	start int64
+	chars int
+	ascii int
+	size  int64
+	text  []byte
+	emit  func(foundString)
+}
+
+func (r *stringRun) add(off int64, c rune, width int) {
+	if r.chars == 0 {
+		r.start = off
+		r.text = r.text[:0]
+	}
+	r.chars++
+	r.size += int64(width)
+	if c < utf8.RuneSelf {
+		r.ascii++
+	}
+	if len(r.text)+utf8.UTFMax <= maxStringText {
+		var b [utf8.UTFMax]byte
+		r.text = append(r.text, b[:utf8.EncodeRune(b[:], c)]...)
+	}
+}
+
+func (r *stringRun) end() {
+	if r.chars >= r.min && (r.need == nil || r.need(r)) {
+		r.emit(foundString{off: r.start, size: r.size, kind: r.kind, text: string(r.text)})
+	}
+	r.chars, r.ascii, r.size = 0, 0, 0
+}
+
+func isPrintASCII(c byte) bool {
+	return c >= 0x20 && c < 0x7F || c == '\t'
+}
+
+func isPrintRune(c rune) bool {
+	return c == '\t' || unicode.IsPrint(c)
+}
+
+//a streaming decoder, the bytes are fed in chunks, off is the offset of p
+type stringDecoder interface {
+	feed(off int64, p []byte)
+	flush()
+}
+
+type asciiDecoder struct {
+	run stringRun
+}
+
+func (d *asciiDecoder) feed(off int64, p []byte) {
+	for i, c := range p {
+		if isPrintASCII(c) {
+			d.run.add(off+int64(i), rune(c), 1)
+		} else if d.run.chars > 0 {
+			d.run.end()
+		}
+	}
+}
+
+func (d *asciiDecoder) flush() { d.run.end() }
+
+type utf8Decoder struct {
+	run   stringRun
+	buf   [utf8.UTFMax]byte
+	n     int //bytes in buf
+	need  int //bytes of the sequence still to come
+	start int64
+}
+
+func (d *utf8Decoder) feed(off int64, p []byte) {
+	for i, c := range p {
+		d.feedByte(off+int64(i), c)
+	}
+}
+
+func (d *utf8Decoder) feedByte(off int64, c byte) {
+	if d.need > 0 {
+		if c&0xC0 == 0x80 {
+			d.buf[d.n] = c
+			d.n++
+			d.need--
+			if d.need == 0 {
+				r, _ := utf8.DecodeRune(d.buf[:d.n])
+				if r != utf8.RuneError && isPrintRune(r) {
+					d.run.add(d.start, r, d.n)
+				} else {
+					d.run.end()
+				}
+			}
+			return
+		}
+		//broken sequence, c starts over
+		d.need = 0
+		d.run.end()
+	}
+	switch {
+	case c < utf8.RuneSelf && isPrintASCII(c):
+		d.run.add(off, rune(c), 1)
+	case c >= 0xC2 && c <= 0xDF:
+		d.buf[0], d.n, d.need, d.start = c, 1, 1, off
+	case c >= 0xE0 && c <= 0xEF:
+		d.buf[0], d.n, d.need, d.start = c, 1, 2, off
+	case c >= 0xF0 && c <= 0xF4:
+		d.buf[0], d.n, d.need, d.start = c, 1, 3, off
+	default:
+		if d.run.chars > 0 {
+			d.run.end()
+		}
+	}
+}
+
+func (d *utf8Decoder) flush() {
+	d.need = 0
+	d.run.end()
+}
+
+//UTF-16 code units starting at even (or odd) offsets. random data is full of printable
+//UTF-16 characters, so a run starts with an ASCII character and stays mostly ASCII.
+//surrogate pairs aren't strings
+type utf16Decoder struct {
+	run       stringRun
+	bigEndian bool
+	parity    int64
+	first     byte
+	have      bool
+}
+
+func (d *utf16Decoder) feed(off int64, p []byte) {
+	for i, c := range p {
+		d.feedByte(off+int64(i), c)
+	}
+}
+
+func (d *utf16Decoder) feedByte(off int64, c byte) {
+	if off&1 == d.parity {
+		d.first, d.have = c, true
+		return
+	}
+	if !d.have {
+		return
+	}
+	d.have = false
+	u := rune(d.first) | rune(c)<<8
+	if d.bigEndian {
+		u = rune(d.first)<<8 | rune(c)
+	}
+	var ok bool
+	switch {
+	case u < utf8.RuneSelf:
+		ok = isPrintASCII(byte(u))
+	case 2*d.run.ascii > d.run.chars:
+		ok = (u < 0xD800 || u > 0xDFFF) && unicode.IsPrint(u)
+	}
+	if ok {
+		d.run.add(off-1, u, 2)
+	} else if d.run.chars > 0 {
+		d.run.end()
+	}
+}
+
+func (d *utf16Decoder) flush() { d.run.end() }
+
+//decoders for the kinds (bits 1<<StringASCII...) of strings of at least min characters
+func stringDecoders(kinds int, min int, emit func(foundString)) []stringDecoder {
+	run := func(kind int) stringRun {
+		return stringRun{kind: kind, min: min, emit: emit}
+	}
+	var ds []stringDecoder
+	if kinds&(1<<StringASCII) != 0 {
+		ds = append(ds, &asciiDecoder{run: run(StringASCII)})
+	}
+	if kinds&(1<<StringUTF8) != 0 {
+		d := &utf8Decoder{run: run(StringUTF8)}
+		if kinds&(1<<StringASCII) != 0 {
+			//plain ASCII is already found
+			d.run.need = func(r *stringRun) bool { return r.ascii < r.chars }
+		}
+		ds = append(ds, d)
+	}
+	for _, k := range []int{StringUTF16LE, StringUTF16BE} {
+		if kinds&(1<<k) != 0 {
+			for parity := int64(0); parity < 2; parity++ {
+				ds = append(ds, &utf16Decoder{run: run(k), bigEndian: k == StringUTF16BE, parity: parity})
+			}
+		}
+	}
+	return ds
+}
+
+//find the strings in b, emit is called for every string (not in order of offset).
+//progress is called for every chunk and can cancel
+func findStrings(b *B.Buffer, kinds, min int, emit func(foundString), progress func(done int64) bool) bool {
+	ds := stringDecoders(kinds, min, emit)
+	var off int64
+	canceled := false
+	b.Iter(func(p []byte) bool {
+		for _, d := range ds {
+			d.feed(off, p)
+		}
+		off += int64(len(p))
+		canceled = !progress(off)
+		return canceled
+	})
+	if canceled {
+		return false
+	}
+	for _, d := range ds {
+		d.flush()
+	}
+	return true
+}
+
+//"\0a\0b\0c\0" is a UTF-16LE and a UTF-16BE string 1 byte apart, the one at the even
+//offset is kept. found must be sorted by offset
+func dropShadows(found []foundString) []foundString {
+	isUTF16 := func(s *foundString) bool { return s.kind == StringUTF16LE || s.kind == StringUTF16BE }
+	shadow := func(s, t *foundString) bool {
+		return s.off&1 == 1 && isUTF16(s) && isUTF16(t) && s.kind != t.kind &&
+			(t.off == s.off-1 || t.off == s.off+1) &&
+			(strings.HasPrefix(s.text, t.text) || strings.HasPrefix(t.text, s.text))
+	}
+	var out []foundString
+	for i := range found {
+		s := &found[i]
+		dropped := false
+		//other kinds of strings can be in between
+		for j := i - 4; j <= i+4 && !dropped; j++ {
+			dropped = j >= 0 && j < len(found) && j != i && shadow(s, &found[j])
+		}
+		if !dropped {
+			out = append(out, *s)
+		}
+	}
+	return out
+}
+
+/*
+ * Strings window
+ */
+
+//the input of the scan, when it changes it is started again
+type stringsKey struct {
+	name    string
+	version int
+	kinds   int
+	min     int32
+}
+
+type stringsWindow struct {
+	open   bool
+	min    int32
+	kinds  [4]bool //per StringASCII...
+	filter string
+
+	//found in the background
+	mu       sync.Mutex
+	gen      int
+	key      stringsKey
+	busy     bool
+	progress float32
+	found    []foundString //sorted by offset when the scan is done
+	list     int           //incremented when found is replaced
+
+	//indices in found that match the filter, extended as found grows
+	shown       []int
+	shownFilter string
+	shownList   int
+	shownLen    int
+}
+
+func (sw *stringsWindow) Open() {
+	if !sw.open && sw.min == 0 {
+		sw.min = 4
+		sw.kinds = [4]bool{true, true, true, false}
+	}
+	sw.open = true
+}
+
+func (sw *stringsWindow) kindBits() int {
+	bits := 0
+	for k, on := range sw.kinds {
+		if on {
+			bits |= 1 << k
+		}
+	}
+	return bits
+}
+
+//(re)start the scan if the file, its contents or the settings changed
+func (sw *stringsWindow) update(hf *HexFile) {
+	if sw.min < 1 {
+		sw.min = 1
+	}
+	key := stringsKey{hf.name, hf.version, sw.kindBits(), sw.min}
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if key == sw.key {
+		return
+	}
+	sw.key = key
+	sw.gen++
+	sw.busy = true
+	sw.progress = 0
+	sw.found = nil
+	sw.list++
+	gen, data, total := sw.gen, hf.buf.Copy(0, hf.buf.Size()), hf.buf.Size()
+	go func() {
+		//found strings are added in batches, so they are listed during the scan
+		var batch []foundString
+		count := 0
+		publish := func() bool {
+			sw.mu.Lock()
+			defer sw.mu.Unlock()
+			if gen != sw.gen {
+				return false
+			}
+			sort.Slice(batch, func(i, j int) bool { return batch[i].off < batch[j].off })
+			sw.found = append(sw.found, dropShadows(batch)...)
+			batch = batch[:0]
+			return true
+		}
+		var shown float32
+		findStrings(data, key.kinds, int(key.min), func(s foundString) {
+			if count < maxStrings {
+				batch = append(batch, s)
+				count++
+			}
+		}, func(done int64) bool {
+			if !publish() || count >= maxStrings {
+				return false
+			}
+			sw.mu.Lock()
+			if total > 0 {
+				sw.progress = float32(done) / float32(total)
+			}
+			p := sw.progress
+			sw.mu.Unlock()
+			if p-shown > 0.01 {
+				shown = p
+				G.Update()
+			}
+			return true
+		})
+		if !publish() {
+			return
+		}
+		//strings spanning chunks can be out of order, the shown list isn't touched
+		sw.mu.Lock()
+		found := append([]foundString(nil), sw.found...)
+		sw.mu.Unlock()
+		sort.SliceStable(found, func(i, j int) bool { return found[i].off < found[j].off })
+		sw.mu.Lock()
+		if gen == sw.gen {
+			sw.found = found
+			sw.list++
+			sw.busy = false
+		}
+		sw.mu.Unlock()
+		G.Update()
+	}()
+}
+
+//stop the scan, i.e. when the window is closed
+func (sw *stringsWindow) cancel() {
+	sw.mu.Lock()
+	sw.gen++
+	sw.key = stringsKey{}
+	sw.busy = false
+	sw.mu.Unlock()
+}
+
+//the indices of the found strings that contain the filter (case insensitive)
+func (sw *stringsWindow) filtered(found []foundString, list int) []int {
+	if sw.filter != sw.shownFilter || list != sw.shownList || len(found) < sw.shownLen {
+		sw.shown = sw.shown[:0]
+		sw.shownLen = 0
+		sw.shownFilter = sw.filter
+		sw.shownList = list
+	}
+	filter := strings.ToLower(sw.filter)
+	for i := sw.shownLen; i < len(found); i++ {
+		if filter == "" || strings.Contains(strings.ToLower(found[i].text), filter) {
+			sw.shown = append(sw.shown, i)
+		}
+	}
+	sw.shownLen = len(found)
+	return sw.shown
+}
+
+func (sw *stringsWindow) jump(s foundString) {
+	if tab := ActiveTab(); tab != nil {
+		tab.setCursor(s.off)
+		tab.view.SetSelection(s.off, s.size)
+	}
+}
+
+func (sw *stringsWindow) stringTable(hf *HexFile, found []foundString, shown []int) {
+	flags := I.TableFlags_ScrollY | I.TableFlags_RowBg | I.TableFlags_Resizable
+	if I.BeginTable("StringsTable", 4, flags, I.ContentRegionAvail(), 0) {
+		defer I.EndTable()
+		I.TableSetupColumn("Offset", 0, 0, 0)
+		I.TableSetupColumn("Type", 0, 0, 0)
+		I.TableSetupColumn("Length", 0, 0, 0)
+		I.TableSetupColumn("String", 0, 0, 0)
+		I.TableSetupScrollFreeze(0, 1)
+		I.TableHeadersRow()
+
+		//only the visible rows are built
+		var clipper I.ListClipper
+		clipper.Begin(len(shown))
+		for clipper.Step() {
+			for _, i := range shown[clipper.DisplayStart:clipper.DisplayEnd] {
+				s := found[i]
+				I.TableNextRow(0, 0)
+				I.TableNextColumn()
+				if I.SelectableV(fmt.Sprintf("%X##string%d", hf.fw.base+s.off, i), false, selectFlags, I.Vec2{}) {
+					sw.jump(s)
+				}
+				I.TableNextColumn()
+				I.Text(stringKinds[s.kind])
+				I.TableNextColumn()
+				I.Text(fmt.Sprint(s.size))
+				I.TableNextColumn()
+				I.Text(s.text)
+			}
+		}
+	}
+}
+
+func drawStringsWindow() {
+	sw := &HD.Strings
+	if !sw.open {
+		sw.mu.Lock()
+		busy := sw.busy
+		sw.mu.Unlock()
+		if busy {
+			sw.cancel()
+		}
+		return
+	}
+	hf := ActiveFile()
+	if hf == nil {
+		G.Window("Strings").IsOpen(&sw.open).Layout(G.Label("No file opened"))
+		return
+	}
+	sw.update(hf)
+
+	sw.mu.Lock()
+	busy, progress, found, list := sw.busy, sw.progress, sw.found, sw.list
+	sw.mu.Unlock()
+	shown := sw.filtered(found, list)
+
+	status := fmt.Sprintf("%d of %d strings", len(shown), len(found))
+	if len(found) >= maxStrings {
+		status += " (stopped at the maximum)"
+	}
+	var progressBar G.Widget = G.Label("")
+	if busy {
+		progressBar = G.ProgressBar(progress).Size(150, 0)
+	}
+	var kinds G.Layout
+	for k := range sw.kinds {
+		kinds = append(kinds, G.Checkbox(stringKinds[k], &sw.kinds[k]))
+	}
+
+	G.Window("Strings").IsOpen(&sw.open).Pos(140, 140).Size(600, 450).Layout(
+		G.Row(
+			G.InputInt(&sw.min).Label("Min Length").Size(80),
+			G.Row(kinds...),
+		),
+		G.Row(
+			G.InputText(&sw.filter).Label("Filter").Size(200),
+			G.Label(status),
+			progressBar,
+		),
+		G.Custom(func() { sw.stringTable(hf, found, shown) }),
+	)
+}
@@ -22,10 +22,28 @@ func mkTabWidget() G.Widget {
 				if hf.dirty {
 					flags |= G.TabItemFlagsUnsavedDocument
 				}
-				if I.BeginTabItem(fmt.Sprint(i) + ": " + hf.stats.Name()) {
+				if i == HD.SelectTab {
+					flags |= G.TabItemFlagsSetSelected
+					HD.SelectTab = -1
+				}
+				if I.BeginTabItemV(fmt.Sprint(i)+": "+hf.stats.Name(), nil, int(flags)) {
 					HD.ActiveTab = i
+					hf.updateSearch()
 					h := HexView(fmt.Sprint(i, ".hexview##", hf.name), hf.buf, tab.view)
-					h.Build()
+					h.ReadOnly(hf.readOnly).BaseAddr(hf.fw.base).Tooltip(hf.annotations.tooltip())
+					h.Highlight(hf.marks.highlighter()).Highlight(hf.annotations.highlighter())
+					if hf.proc != nil {
+						h.Unreadable(hf.proc.isUnreadable)
+					}
+					h.Highlight(hf.searchHighlighter()).Highlight(hf.changeHighlighter())
+					if HD.Minimap.hidden {
+						h.Build()
+					} else {
+						w, _ := G.GetAvailableRegion()
+						h.Width(w - minimapWidth - 8).Build()
+						I.SameLine()
+						HD.Minimap.build(&HD.Tabs[i], hf)
+					}
 					I.EndTabItem()
 				}
 			}
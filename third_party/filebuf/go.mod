@@ -0,0 +1,5 @@
+module github.com/snhmibby/filebuf
+
+go 1.17
+
+require golang.org/x/exp v0.0.0-20210903233438-a2d0902c3ac7
@@ -0,0 +1,260 @@
+package main
+
+//bitwise transforms of the selection (XOR-decoding and such), previewed in the dialog
+//and applied as 1 undo-able edit
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+	"strings"
+
+	G "github.com/AllenDang/giu"
+	B "github.com/snhmibby/filebuf"
+)
+
+//transforms are done in memory
+const maxTransformSize = 256 << 20
+
+//bytes of the selection shown in the preview
+const transformPreview = 64
+
+type transformArgs struct {
+	key   []byte //repeated over the data, starting at the first selected byte
+	count int    //bits to shift or rotate
+	group int    //bytes per group to swap
+}
+
+type transformOp struct {
+	name  string
+	key   bool //needs a key
+	count bool //needs a bit count
+	group bool //needs a group size
+	apply func(data []byte, arg transformArgs)
+}
+
+var transformOps = []transformOp{
+	{name: "XOR", key: true, apply: withKey(func(b, k byte) byte { return b ^ k })},
+	{name: "AND", key: true, apply: withKey(func(b, k byte) byte { return b & k })},
+	{name: "OR", key: true, apply: withKey(func(b, k byte) byte { return b | k })},
+	{name: "Add", key: true, apply: withKey(func(b, k byte) byte { return b + k })},
+	{name: "Subtract", key: true, apply: withKey(func(b, k byte) byte { return b - k })},
+	{name: "NOT", apply: perByte(func(b byte, _ int) byte { return ^b })},
+	{name: "Shift Left", count: true, apply: perByte(func(b byte, n int) byte { return b << uint(n) })},
+	{name: "Shift Right", count: true, apply: perByte(func(b byte, n int) byte { return b >> uint(n) })},
+	{name: "Rotate Left", count: true, apply: perByte(func(b byte, n int) byte { return bits.RotateLeft8(b, n) })},
+	{name: "Rotate Right", count: true, apply: perByte(func(b byte, n int) byte { return bits.RotateLeft8(b, -n) })},
+	{name: "Reverse Bits", apply: perByte(func(b byte, _ int) byte { return bits.Reverse8(b) })},
+	{name: "Byte Swap", group: true, apply: swapGroups},
+}
+
+var transformGroups = []string{"2", "4", "8"}
+
+func withKey(f func(b, k byte) byte) func([]byte, transformArgs) {
+	return func(data []byte, arg transformArgs) {
+		for i := range data {
+			data[i] = f(data[i], arg.key[i%len(arg.key)])
+		}
+	}
+}
+
+func perByte(f func(b byte, n int) byte) func([]byte, transformArgs) {
+	return func(data []byte, arg transformArgs) {
+		for i := range data {
+			data[i] = f(data[i], arg.count)
+		}
+	}
+}
+
+//reverse the bytes of every group, a partial group at the end is left alone
+func swapGroups(data []byte, arg transformArgs) {
+	n := arg.group
+	for g := 0; g+n <= len(data); g += n {
+		for i, j := g, g+n-1; i < j; i, j = i+1, j-1 {
+			data[i], data[j] = data[j], data[i]
+		}
+	}
+}
+
+//transform size bytes at off as 1 undo-able edit
+func (hf *HexFile) Transform(off, size int64, op *transformOp, arg transformArgs) error {
+	if size > maxTransformSize {
+		return fmt.Errorf("can't transform more than %s", mkSize(maxTransformSize))
+	}
+	if op.key && len(arg.key) == 0 {
+		return fmt.Errorf("%s: no key given", op.name)
+	}
+	data := make([]byte, size)
+	hf.buf.Seek(off, io.SeekStart)
+	if _, err := io.ReadFull(hf.buf, data); err != nil {
+		return err
+	}
+	op.apply(data, arg)
+	return hf.Replace(off, size, B.NewMem(data))
+}
+
+/*
+ * the transform dialog
+ */
+
+type transformDialog struct {
+	id   string
+	open bool
+
+	op    int32
+	key   string //hex
+	count int32
+	group int32 //index in transformGroups
+}
+
+func (d *transformDialog) Dispose() {}
+
+func (d *transformDialog) saveState() {
+	G.Context.SetState(d.id, d)
+}
+
+func (d *transformDialog) close() {
+	d.saveState()
+	G.CloseCurrentPopup()
+}
+
+func (d *transformDialog) args() (transformArgs, error) {
+	arg := transformArgs{count: int(d.count) & 7, group: 2 << uint(d.group)}
+	var err error
+	if transformOps[d.op].key {
+		arg.key, err = parseHexBytes(d.key)
+		if err == nil && len(arg.key) == 0 {
+			err = fmt.Errorf("no key given")
+		}
+	}
+	return arg, err
+}
+
+func hexString(data []byte) string {
+	var sb strings.Builder
+	for i, b := range data {
+		if i > 0 && i%16 == 0 {
+			sb.WriteString("\n")
+		} else if i > 0 {
+			sb.WriteString(" ")
+		}
+		fmt.Fprintf(&sb, "%02X", b)
+	}
+	return sb.String()
+}
+
+//the first bytes of the selection before and after the transform
+func (d *transformDialog) preview() (string, string) {
+	tab := ActiveTab()
+	hf := ActiveFile()
+	if tab == nil || hf == nil {
+		return "", ""
+	}
+	off, size := tab.view.Selection()
+	if size > transformPreview {
+		size = transformPreview
+	}
+	data := make([]byte, size)
+	hf.buf.Seek(off, io.SeekStart)
+	io.ReadFull(hf.buf, data)
+	before := hexString(data)
+	arg, err := d.args()
+	if err != nil {
+		return before, err.Error()
+	}
+	transformOps[d.op].apply(data, arg)
+	return before, hexString(data)
+}
+
+func (d *transformDialog) transform() {
+	tab := ActiveTab()
+	hf := ActiveFile()
+	if tab == nil || hf == nil || !hf.writable("Transform") {
+		d.close()
+		return
+	}
+	arg, err := d.args()
+	if err != nil {
+		ErrorDialog("Transform", err.Error())
+		return
+	}
+	off, size := tab.view.Selection()
+	if err := hf.Transform(off, size, &transformOps[d.op], arg); err != nil {
+		ErrorDialog("Transform", err.Error())
+		return
+	}
+	d.close()
+}
+
+func getTransformDialog(id string) *transformDialog {
+	r := G.Context.GetState(id)
+	if r == nil {
+		d := &transformDialog{id: id, count: 1}
+		d.saveState()
+		return d
+	}
+	return r.(*transformDialog)
+}
+
+func prepareTransformDialog(id string) G.Widget {
+	d := getTransformDialog(id)
+	return G.Custom(func() {
+		if d.open {
+			G.OpenPopup(id)
+			d.open = false
+		}
+		names := make([]string, len(transformOps))
+		for i, op := range transformOps {
+			names[i] = op.name
+		}
+		op := transformOps[d.op]
+		var args G.Layout
+		if op.key {
+			args = append(args, G.InputText(&d.key).Label("Key (hex)"))
+		}
+		if op.count {
+			args = append(args, G.SliderInt(&d.count, 1, 7).Label("Bits"))
+		}
+		if op.group {
+			args = append(args, G.Combo("Group Size", transformGroups[d.group], transformGroups, &d.group))
+		}
+		G.SetNextWindowSizeV(450, 0, G.ConditionOnce)
+		G.PopupModal(id).Layout(
+			G.Combo("Operation", names[d.op], names, &d.op),
+			args,
+			G.Separator(),
+			//the popup layout is only built while it is open
+			G.Custom(func() {
+				before, after := d.preview()
+				G.Label("Before:").Build()
+				G.Label(before).Build()
+				G.Label("After:").Build()
+				G.Label(after).Build()
+			}),
+			G.Separator(),
+			G.Row(
+				G.Button("Cancel").OnClick(d.close),
+				G.Button("Apply").OnClick(d.transform),
+			),
+			G.Custom(func() {
+				if G.IsKeyPressed(G.KeyEscape) {
+					d.close()
+				}
+			}),
+		).Build()
+	})
+}
+
+/*
+ *Public:
+ */
+
+func TransformDialog(id string) {
+	d := getTransformDialog(id)
+	d.open = true
+	d.saveState()
+}
+
+func PrepareTransformDialog(id string) G.Widget {
+	return prepareTransformDialog(id)
+}
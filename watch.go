@@ -0,0 +1,202 @@
+package main
+
+//watch opened files for changes by other programs (polling mtime and size).
+//a file that is replaced (written to a new file and renamed) keeps its old contents in
+//the buffer. A file that is written in place is copied when the change is noticed,
+//from then on the buffer keeps its contents until the file is reloaded (see detach).
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	G "github.com/AllenDang/giu"
+)
+
+const watchInterval = time.Second
+
+type fileWatcher struct {
+	started bool
+	last    time.Time
+	pending []string //changed files to ask about, the first one is shown
+}
+
+//redraw every watchInterval, so changes are noticed without user input
+func (fw *fileWatcher) start() {
+	if fw.started {
+		return
+	}
+	fw.started = true
+	go func() {
+		for range time.Tick(watchInterval) {
+			G.Update()
+		}
+	}()
+}
+
+//the file on disk was changed since it was loaded or saved, stats is its new state
+func (hf *HexFile) changedOnDisk() (stats fs.FileInfo, changed bool) {
+	if hf.kind != KindRegular {
+		return nil, false
+	}
+	stats, err := os.Stat(hf.name)
+	if err != nil {
+		return nil, false
+	}
+	changed = stats.Size() != hf.stats.Size() || !stats.ModTime().Equal(hf.stats.ModTime())
+	return stats, changed
+}
+
+//a and b are the same file, not one that replaced the other
+func sameFile(a, b fs.FileInfo) bool {
+	if gi, ok := a.(grownInfo); ok {
+		a = gi.FileInfo
+	}
+	return os.SameFile(a, b)
+}
+
+//the same change was already answered with "keep mine"
+func (hf *HexFile) changeSeen(stats fs.FileInfo) bool {
+	return hf.seen != nil && stats.Size() == hf.seen.Size() && stats.ModTime().Equal(hf.seen.ModTime())
+}
+
+//look for changed files, once every watchInterval
+func (fw *fileWatcher) check() {
+	if time.Since(fw.last) < watchInterval {
+		return
+	}
+	fw.last = time.Now()
+
+	for _, name := range openFileNames() {
+		hf := HD.Files[name]
+		if hf.kind == KindProcess {
+			hf.refreshProcess()
+			continue
+		}
+		if hf.follow && hf.followGrowth() {
+			continue
+		}
+		stats, changed := hf.changedOnDisk()
+		if !changed || hf.changeSeen(stats) || fw.isPending(name) {
+			continue
+		}
+		if sameFile(hf.stats, stats) {
+			if err := hf.detach(); err != nil {
+				ErrorDialog(fmt.Sprintf("Copying <%s>", name), err.Error())
+			}
+		}
+		fw.pending = append(fw.pending, name)
+	}
+}
+
+func (fw *fileWatcher) isPending(name string) bool {
+	for _, p := range fw.pending {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (fw *fileWatcher) done() {
+	fw.pending = fw.pending[1:]
+}
+
+//load the file from disk again, edits and undo history are dropped
+func (hf *HexFile) reload() error {
+	stats, err := os.Stat(hf.name)
+	if err != nil {
+		return err
+	}
+	buf, info, err := loadBuffer(hf.name, hf.format)
+	if err != nil {
+		return err
+	}
+	hf.buf, hf.fw, hf.stats, hf.seen = buf, info, stats, nil
+	hf.setSnapshot("")
+	hf.undo, hf.redo = nil, nil
+	hf.touch()
+	hf.resetOriginal()
+	for _, tab := range HD.Tabs {
+		if tab.name == hf.name {
+			hf.ClampAddr(&tab.view.cursor)
+			tab.view.SetSelection(0, 0)
+		}
+	}
+	return nil
+}
+
+//open the file as it is on disk next to hf and compare the two
+func (hf *HexFile) diffWithDisk() error {
+	name := hf.name + " (on disk)"
+	buf, info, err := loadBuffer(hf.name, hf.format)
+	if err != nil {
+		return err
+	}
+	snap, ok := HD.Files[name]
+	if !ok {
+		snap = new(HexFile)
+		snap.name = name
+		snap.kind = KindSnapshot
+		snap.readOnly = true
+		HD.Files[name] = snap
+		OpenTab(snap)
+	}
+	snap.buf, snap.fw, snap.format = buf, info, hf.format
+	snap.stats = memInfo{name: filepath.Base(name), size: buf.Size()}
+	snap.touch()
+	snap.resetOriginal()
+	HD.Compare.compare(hf.name, name)
+	return nil
+}
+
+func drawFileChangedWindow() {
+	fw := &HD.Watch
+	fw.check()
+	if len(fw.pending) == 0 {
+		return
+	}
+	hf, ok := HD.Files[fw.pending[0]]
+	if !ok {
+		fw.done()
+		return
+	}
+	stats, changed := hf.changedOnDisk()
+	if !changed {
+		fw.done()
+		return
+	}
+
+	msg := fmt.Sprintf("%s was changed by another program.", hf.name)
+	if hf.version != hf.savedVersion {
+		msg += "\nReloading it drops your edits."
+	}
+	open := true
+	G.Window("File Changed").IsOpen(&open).Pos(200, 200).Size(450, 120).Layout(
+		G.Label(msg),
+		G.Row(
+			G.Button("Reload").OnClick(func() {
+				if err := hf.reload(); err != nil {
+					ErrorDialog(fmt.Sprintf("Reloading <%s>", hf.name), err.Error())
+				}
+				fw.done()
+			}),
+			G.Button("Keep Mine").OnClick(func() {
+				hf.seen = stats
+				fw.done()
+			}),
+			G.Button("Show Diff").OnClick(func() {
+				if err := hf.diffWithDisk(); err != nil {
+					ErrorDialog(fmt.Sprintf("Reading <%s>", hf.name), err.Error())
+				}
+			}),
+		),
+	)
+	if !open {
+		//closing is keeping
+		hf.seen = stats
+		fw.done()
+	}
+}
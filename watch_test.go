@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	B "github.com/snhmibby/filebuf"
+)
+
+//a file replaced by another program (written next to it and renamed) doesn't change
+//the buffer, it reads the file that was opened
+func TestReplacedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	os.WriteFile(path, []byte("the original contents"), 0644)
+	hf, _, err := loadHexFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := filepath.Join(dir, "file.new")
+	os.WriteFile(tmp, []byte("THE NEW CONTENTS"), 0644)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	stats, changed := hf.changedOnDisk()
+	if !changed || sameFile(hf.stats, stats) {
+		t.Errorf("changed %v, same file %v", changed, sameFile(hf.stats, stats))
+	}
+	if got := string(contents(hf.buf)); got != "the original contents" {
+		t.Errorf("buffer %q", got)
+	}
+}
+
+//a file written in place is copied, with the edits kept, and doesn't change after that
+func TestDetach(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path, []byte("0123456789abcdef"), 0644)
+	hf, _, err := loadHexFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hf.Replace(0, 2, B.NewMem([]byte("++++")))
+	hf.Replace(12, 1, B.NewMem(nil))
+
+	f, _ := os.OpenFile(path, os.O_WRONLY, 0)
+	f.WriteAt([]byte("ABC"), 4)
+	f.Close()
+	stats, changed := hf.changedOnDisk()
+	if !changed || !sameFile(hf.stats, stats) {
+		t.Fatalf("changed %v, same file %v", changed, sameFile(hf.stats, stats))
+	}
+	if err := hf.detach(); err != nil {
+		t.Fatal(err)
+	}
+	if hf.snapshot == "" {
+		t.Fatalf("no copy")
+	}
+	const want = "++++23ABC789bcdef"
+	for _, data := range []string{"THE NEW CONTENTS, LONGER THAN BEFORE", "short"} {
+		os.WriteFile(path, []byte(data), 0644)
+		if got := string(contents(hf.buf)); got != want {
+			t.Errorf("buffer after writing %q: %q", data, got)
+		}
+		if got := string(contents(hf.orig)); got != "0123ABC789abcdef" {
+			t.Errorf("original after writing %q: %q", data, got)
+		}
+	}
+	checkHunks(t, hf)
+
+	//only once, the buffer doesn't read the file anymore
+	snapshot := hf.snapshot
+	if err := hf.detach(); err != nil || hf.snapshot != snapshot || string(contents(hf.buf)) != want {
+		t.Errorf("detached again: %v", err)
+	}
+	hf.removeTemp()
+	if _, err := os.Stat(snapshot); !os.IsNotExist(err) {
+		t.Errorf("copy %s not removed: %v", snapshot, err)
+	}
+}
+
+//a truncated file is padded to the size it had
+func TestDetachTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(path, []byte("0123456789"), 0644)
+	hf, _, err := loadHexFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hf.removeTemp()
+	os.WriteFile(path, []byte("01"), 0644)
+	if err := hf.detach(); err != nil {
+		t.Fatal(err)
+	}
+	if got := contents(hf.buf); string(got) != "01\x00\x00\x00\x00\x00\x00\x00\x00" {
+		t.Errorf("buffer %q", got)
+	}
+}